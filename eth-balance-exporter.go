@@ -2,165 +2,484 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
-	"math/big"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
+
+	"eth-balance-exporter/pkg/collector"
 
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"google.golang.org/grpc"
 )
 
-// WalletBalanceCollector collects and exposes balance metrics for multiple wallets across RPC URLs.
-type WalletBalanceCollector struct {
-	rpcWalletMapping map[string][]string
-	clientCache      map[string]*ethclient.Client
-	balanceMetric    *prometheus.Desc
-	mutex            sync.Mutex
-}
-
-// NewWalletBalanceCollector creates a new WalletBalanceCollector.
-func NewWalletBalanceCollector(rpcWalletMapping map[string][]string) *WalletBalanceCollector {
-	return &WalletBalanceCollector{
-		rpcWalletMapping: rpcWalletMapping,
-		clientCache:      make(map[string]*ethclient.Client),
-		balanceMetric: prometheus.NewDesc(
-			"wallet_balance_eth",
-			"Balance of the specified wallet in ETH",
-			[]string{"wallet"},
-			nil,
-		),
+// envOr returns the value of the environment variable, or fallback if it is not set.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
 	}
+	return fallback
 }
 
-// Describe sends the descriptor of the metric to Prometheus.
-func (c *WalletBalanceCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.balanceMetric
-}
+func main() {
+	// Flags take precedence over the environment variables they default to
+	listenAddress := flag.String("listen-address", envOr("LISTEN_ADDRESS", ":8080"), "address to serve metrics on (env LISTEN_ADDRESS)")
+	metricsPath := flag.String("metrics-path", envOr("METRICS_PATH", "/metrics"), "path to serve metrics at (env METRICS_PATH)")
+	configFile := flag.String("config-file", os.Getenv("CONFIG_FILE"), "YAML config file, used instead of RPC_URL_MAPPING (env CONFIG_FILE)")
+	printWallets := flag.Bool("print-wallets", false, "print the resolved list of monitored wallets and exit without querying any endpoint")
+	flag.Parse()
 
-// Collect fetches the balance for each wallet and sends it to Prometheus.
-func (c *WalletBalanceCollector) Collect(ch chan<- prometheus.Metric) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	if !strings.HasPrefix(*metricsPath, "/") || *metricsPath == "/balances" || *metricsPath == "/errors" || *metricsPath == "/wallets" || *metricsPath == "/config" || *metricsPath == "/reconnect" || *metricsPath == "/endpoints" {
+		log.Fatalf("Invalid metrics path: %s (must start with / and not be /balances, /errors, /wallets, /endpoints, /config or /reconnect)", *metricsPath)
+	}
 
-	for rpcURL, wallets := range c.rpcWalletMapping {
-		client, err := c.getClient(rpcURL)
+	// Load endpoints from Consul if configured, otherwise from the config file if set, otherwise from
+	// RPC_URL_MAPPING, or start without any with ALLOW_EMPTY_CONFIG
+	var (
+		endpoints []collector.EndpointConfig
+		relabel   []collector.RelabelRule
+		consul    *collector.ConsulSource
+	)
+	if consulKey := os.Getenv("CONSUL_KEY"); consulKey != "" {
+		var err error
+		if consul, err = collector.NewConsulSource(os.Getenv("CONSUL_HTTP_ADDR"), consulKey, os.Getenv("CONSUL_HTTP_TOKEN")); err != nil {
+			log.Fatalf("Error parsing Consul settings: %v", err)
+		}
+		if endpoints, relabel, err = consul.Load(); err != nil {
+			log.Fatalf("Error loading configuration from Consul: %v", err)
+		}
+	} else if *configFile != "" {
+		var err error
+		endpoints, err = collector.LoadConfigFile(*configFile)
 		if err != nil {
-			log.Printf("Error connecting to RPC URL %s: %v", rpcURL, err)
-			continue
+			log.Fatalf("Error loading config file: %v", err)
+		}
+		if relabel, err = collector.LoadRelabelRules(*configFile); err != nil {
+			log.Fatalf("Error loading relabel rules: %v", err)
+		}
+	} else {
+		rpcMapping := os.Getenv("RPC_URL_MAPPING")
+		allowEmptyConfig, err := collector.ParseBool(os.Getenv("ALLOW_EMPTY_CONFIG"))
+		if err != nil {
+			log.Fatalf("Error parsing ALLOW_EMPTY_CONFIG: %v", err)
 		}
 
-		for _, walletAddress := range wallets {
-			balance, err := c.getWalletBalance(client, walletAddress)
+		switch {
+		case rpcMapping != "":
+			rpcWalletMapping, err := collector.ParseRPCMapping(rpcMapping)
 			if err != nil {
-				log.Printf("Error retrieving balance for wallet %s: %v", walletAddress, err)
-				continue
+				log.Fatalf("Error parsing RPC_URL_MAPPING: %v", err)
 			}
+			endpoints = collector.EndpointsFromMapping(rpcWalletMapping)
+		case !allowEmptyConfig:
+			log.Fatal("RPC_URL_MAPPING or CONFIG_FILE environment variable, or -config-file flag, must be set")
+		case os.Getenv("ADMIN_TOKEN") == "":
+			log.Fatal("ALLOW_EMPTY_CONFIG requires ADMIN_TOKEN, as endpoints are then added through the admin API")
+		default:
+			log.Print("Starting without endpoints until they are added through the admin API")
+		}
+	}
 
-			ch <- prometheus.MustNewConstMetric(
-				c.balanceMetric,
-				prometheus.GaugeValue,
-				balance,
-				walletAddress,
-			)
+	// Inject the secrets from Vault into the RPC URLs, before the endpoints are named after their hosts
+	var vault *collector.VaultSource
+	if vaultPath := os.Getenv("VAULT_SECRET_PATH"); vaultPath != "" {
+		var err error
+		if vault, err = collector.NewVaultSource(os.Getenv("VAULT_ADDR"), vaultPath, os.Getenv("VAULT_TOKEN")); err != nil {
+			log.Fatalf("Error parsing Vault settings: %v", err)
+		}
+		if err = vault.Load(); err != nil {
+			log.Fatalf("Error loading secrets from Vault: %v", err)
 		}
+		if endpoints, err = vault.Resolve(endpoints); err != nil {
+			log.Fatalf("Error loading secrets from Vault: %v", err)
+		}
+	} else if collector.HasVaultPlaceholders(endpoints) {
+		log.Fatal("RPC URLs contain ${vault:...} placeholders, but VAULT_SECRET_PATH is not set")
 	}
-}
 
-// getClient retrieves or creates an ethclient.Client for the given RPC URL.
-func (c *WalletBalanceCollector) getClient(rpcURL string) (*ethclient.Client, error) {
-	if client, exists := c.clientCache[rpcURL]; exists {
-		return client, nil
+	if err := collector.AssignProviderNames(endpoints); err != nil {
+		log.Fatalf("Error naming endpoints: %v", err)
+	}
+
+	var (
+		config collector.CollectorConfig
+		err    error
+	)
+	config.Relabel = relabel
+	if config.StaleBehavior, err = collector.ParseStaleBehavior(os.Getenv("STALE_BEHAVIOR")); err != nil {
+		log.Fatalf("Error parsing STALE_BEHAVIOR: %v", err)
+	}
+	if config.DuplicateWallets, err = collector.ParseDuplicateWallets(os.Getenv("DUPLICATE_WALLETS")); err != nil {
+		log.Fatalf("Error parsing DUPLICATE_WALLETS: %v", err)
+	}
+	if config.AddressCase, err = collector.ParseAddressCase(os.Getenv("LABEL_ADDRESS_CASE")); err != nil {
+		log.Fatalf("Error parsing LABEL_ADDRESS_CASE: %v", err)
+	}
+	if config.Precision, err = collector.ParsePrecision(os.Getenv("BALANCE_PRECISION")); err != nil {
+		log.Fatalf("Error parsing BALANCE_PRECISION: %v", err)
+	}
+	if config.RoundingMode, err = collector.ParseRoundingMode(os.Getenv("BALANCE_ROUNDING_MODE")); err != nil {
+		log.Fatalf("Error parsing BALANCE_ROUNDING_MODE: %v", err)
+	}
+	if config.CollectTimeout, err = collector.ParseDuration(os.Getenv("COLLECT_TIMEOUT"), collector.DefaultCollectTimeout); err != nil {
+		log.Fatalf("Error parsing COLLECT_TIMEOUT: %v", err)
+	}
+	if config.RPCTimeout, err = collector.ParseDuration(os.Getenv("RPC_TIMEOUT"), config.CollectTimeout); err != nil {
+		log.Fatalf("Error parsing RPC_TIMEOUT: %v", err)
+	}
+	if config.LockTimeout, err = collector.ParseDuration(os.Getenv("COLLECT_LOCK_TIMEOUT"), config.CollectTimeout); err != nil {
+		log.Fatalf("Error parsing COLLECT_LOCK_TIMEOUT: %v", err)
+	}
+	if config.RetryAttempts, err = collector.ParseRetryAttempts(os.Getenv("RPC_MAX_ATTEMPTS")); err != nil {
+		log.Fatalf("Error parsing RPC_MAX_ATTEMPTS: %v", err)
+	}
+	if config.CircuitFailures, err = collector.ParseCircuitFailures(os.Getenv("CIRCUIT_BREAKER_FAILURES")); err != nil {
+		log.Fatalf("Error parsing CIRCUIT_BREAKER_FAILURES: %v", err)
+	}
+	if config.CircuitCooldown, err = collector.ParseDuration(os.Getenv("CIRCUIT_BREAKER_COOLDOWN"), collector.DefaultCircuitCooldown); err != nil {
+		log.Fatalf("Error parsing CIRCUIT_BREAKER_COOLDOWN: %v", err)
+	}
+	if config.RetryBaseDelay, err = collector.ParseDuration(os.Getenv("RPC_RETRY_BASE_DELAY"), collector.DefaultRetryBaseDelay); err != nil {
+		log.Fatalf("Error parsing RPC_RETRY_BASE_DELAY: %v", err)
+	}
+	if config.Proxy, err = collector.ParseProxy(os.Getenv("RPC_PROXY")); err != nil {
+		log.Fatalf("Error parsing RPC_PROXY: %v", err)
+	}
+	if config.TotalAcrossChains, err = collector.ParseBool(os.Getenv("TOTAL_ACROSS_CHAINS")); err != nil {
+		log.Fatalf("Error parsing TOTAL_ACROSS_CHAINS: %v", err)
+	}
+	if config.WalletFetchDuration, err = collector.ParseBool(os.Getenv("WALLET_FETCH_DURATION")); err != nil {
+		log.Fatalf("Error parsing WALLET_FETCH_DURATION: %v", err)
+	}
+	if config.CrossCheckTolerance, err = collector.ParseCrossCheckTolerance(os.Getenv("CROSS_CHECK_TOLERANCE")); err != nil {
+		log.Fatalf("Error parsing CROSS_CHECK_TOLERANCE: %v", err)
+	}
+	if config.UnifiedBalanceMetric, err = collector.ParseBool(os.Getenv("UNIFIED_BALANCE_METRIC")); err != nil {
+		log.Fatalf("Error parsing UNIFIED_BALANCE_METRIC: %v", err)
+	}
+	if config.MaxConcurrency, err = collector.ParseConcurrency(os.Getenv("MAX_CONCURRENCY")); err != nil {
+		log.Fatalf("Error parsing MAX_CONCURRENCY: %v", err)
+	}
+	if config.HideZeroBalances, err = collector.ParseBool(os.Getenv("HIDE_ZERO_BALANCES")); err != nil {
+		log.Fatalf("Error parsing HIDE_ZERO_BALANCES: %v", err)
+	}
+	if config.EmitOnChange, err = collector.ParseBool(os.Getenv("EMIT_ON_CHANGE")); err != nil {
+		log.Fatalf("Error parsing EMIT_ON_CHANGE: %v", err)
+	}
+	if config.GweiMetric, err = collector.ParseBool(os.Getenv("BALANCE_GWEI")); err != nil {
+		log.Fatalf("Error parsing BALANCE_GWEI: %v", err)
+	}
+	if config.StartupStagger, err = collector.ParseDuration(os.Getenv("STARTUP_STAGGER"), 0); err != nil {
+		log.Fatalf("Error parsing STARTUP_STAGGER: %v", err)
+	}
+	if config.StartupStagger >= config.CollectTimeout {
+		log.Fatal("STARTUP_STAGGER must be below COLLECT_TIMEOUT, as fetches wait for their endpoint's start")
+	}
+	if config.StartupAttempts, err = collector.ParseRetryAttempts(os.Getenv("STARTUP_MAX_ATTEMPTS")); err != nil {
+		log.Fatalf("Error parsing STARTUP_MAX_ATTEMPTS: %v", err)
+	}
+	if config.StartupRetryDelay, err = collector.ParseDuration(os.Getenv("STARTUP_RETRY_DELAY"), collector.DefaultStartupRetryDelay); err != nil {
+		log.Fatalf("Error parsing STARTUP_RETRY_DELAY: %v", err)
+	}
+	if config.DNSCacheTTL, err = collector.ParseDuration(os.Getenv("DNS_CACHE_TTL"), 0); err != nil {
+		log.Fatalf("Error parsing DNS_CACHE_TTL: %v", err)
+	}
+	if config.ChainIDTTL, err = collector.ParseChainIDTTL(os.Getenv("CHAIN_ID_TTL")); err != nil {
+		log.Fatalf("Error parsing CHAIN_ID_TTL: %v", err)
+	}
+	if config.ChainIDChange, err = collector.ParseChainIDChange(os.Getenv("CHAIN_ID_CHANGE")); err != nil {
+		log.Fatalf("Error parsing CHAIN_ID_CHANGE: %v", err)
+	}
+	if config.ErrorReasons, err = collector.ParseErrorReasons(os.Getenv("ERROR_REASONS")); err != nil {
+		log.Fatalf("Error parsing ERROR_REASONS: %v", err)
+	}
+	if config.DurationBuckets, err = collector.ParseBuckets(os.Getenv("RPC_DURATION_BUCKETS")); err != nil {
+		log.Printf("Error parsing RPC_DURATION_BUCKETS, using default buckets: %v", err)
+		config.DurationBuckets = prometheus.DefBuckets
+	}
+	if config.EmptyAccountErrors, err = collector.ParseEmptyAccountErrors(os.Getenv("EMPTY_ACCOUNT_ERRORS")); err != nil {
+		log.Fatalf("Error parsing EMPTY_ACCOUNT_ERRORS: %v", err)
+	}
+	if config.RefreshInterval, err = collector.ParseDuration(os.Getenv("REFRESH_INTERVAL"), 0); err != nil {
+		log.Fatalf("Error parsing REFRESH_INTERVAL: %v", err)
+	}
+	if config.Timestamps, err = collector.ParseTimestampMode(os.Getenv("BALANCE_TIMESTAMPS")); err != nil {
+		log.Fatalf("Error parsing BALANCE_TIMESTAMPS: %v", err)
+	}
+	if config.MaxBalanceAge, err = collector.ParseDuration(os.Getenv("MAX_BALANCE_AGE"), 0); err != nil {
+		log.Fatalf("Error parsing MAX_BALANCE_AGE: %v", err)
+	}
+	if config.LogSampleWindow, err = collector.ParseDuration(os.Getenv("LOG_SAMPLE_WINDOW"), 0); err != nil {
+		log.Fatalf("Error parsing LOG_SAMPLE_WINDOW: %v", err)
+	}
+	if config.RefreshJitter, err = collector.ParseDuration(os.Getenv("REFRESH_JITTER"), 0); err != nil {
+		log.Fatalf("Error parsing REFRESH_JITTER: %v", err)
 	}
 
-	client, err := ethclient.Dial(rpcURL)
+	if config.LabelTemplates, err = collector.ParseLabelTemplates(os.Getenv("WALLET_LABELS")); err != nil {
+		log.Fatalf("Error parsing WALLET_LABELS: %v", err)
+	}
+	if config.NamingServiceURL, err = collector.ParseNamingServiceURL(os.Getenv("NAMING_SERVICE_URL")); err != nil {
+		log.Fatalf("Error parsing NAMING_SERVICE_URL: %v", err)
+	}
+	if config.FiatCurrencies, err = collector.ParseFiatCurrencies(os.Getenv("FIAT_CURRENCIES")); err != nil {
+		log.Fatalf("Error parsing FIAT_CURRENCIES: %v", err)
+	}
+	if config.PriceURL, err = collector.ParsePriceURL(os.Getenv("PRICE_URL")); err != nil {
+		log.Fatalf("Error parsing PRICE_URL: %v", err)
+	}
+	if config.EtherscanURL, err = collector.ParseEtherscanURL(os.Getenv("ETHERSCAN_URL")); err != nil {
+		log.Fatalf("Error parsing ETHERSCAN_URL: %v", err)
+	}
+	config.EtherscanAPIKey = strings.TrimSpace(os.Getenv("ETHERSCAN_API_KEY"))
+	priceInterval, err := collector.ParseDuration(os.Getenv("PRICE_INTERVAL"), collector.DefaultPriceInterval)
+	if err != nil {
+		log.Fatalf("Error parsing PRICE_INTERVAL: %v", err)
+	}
+	namingInterval, err := collector.ParseDuration(os.Getenv("NAMING_SERVICE_INTERVAL"), collector.DefaultNamingServiceInterval)
+	if err != nil {
+		log.Fatalf("Error parsing NAMING_SERVICE_INTERVAL: %v", err)
+	}
+	grpcHealthPort, err := collector.ParseGRPCHealthPort(os.Getenv("GRPC_HEALTH_PORT"))
 	if err != nil {
-		return nil, err
+		log.Fatalf("Error parsing GRPC_HEALTH_PORT: %v", err)
+	}
+	ensInterval, err := collector.ParseDuration(os.Getenv("ENS_INTERVAL"), collector.DefaultENSInterval)
+	if err != nil {
+		log.Fatalf("Error parsing ENS_INTERVAL: %v", err)
+	}
+	consulInterval, err := collector.ParseDuration(os.Getenv("CONSUL_INTERVAL"), collector.DefaultConsulInterval)
+	if err != nil {
+		log.Fatalf("Error parsing CONSUL_INTERVAL: %v", err)
+	}
+	vaultInterval, err := collector.ParseDuration(os.Getenv("VAULT_INTERVAL"), collector.DefaultVaultInterval)
+	if err != nil {
+		log.Fatalf("Error parsing VAULT_INTERVAL: %v", err)
+	}
+	config.Vault = vault
+	watchDelay, err := collector.ParseDuration(os.Getenv("CONFIG_WATCH_DELAY"), collector.DefaultConfigWatchDelay)
+	if err != nil {
+		log.Fatalf("Error parsing CONFIG_WATCH_DELAY: %v", err)
+	}
+	if config.DisplayDecimals, err = collector.ParseDisplayDecimals(os.Getenv("BALANCE_DISPLAY_DECIMALS")); err != nil {
+		log.Fatalf("Error parsing BALANCE_DISPLAY_DECIMALS: %v", err)
+	}
+	if config.GraphiteAddress, err = collector.ParseGraphiteAddress(os.Getenv("GRAPHITE_ADDRESS")); err != nil {
+		log.Fatalf("Error parsing GRAPHITE_ADDRESS: %v", err)
+	}
+	config.GraphitePrefix = os.Getenv("GRAPHITE_PREFIX")
+	if config.GraphiteAddress != "" && config.RefreshInterval == 0 {
+		log.Fatal("GRAPHITE_ADDRESS requires REFRESH_INTERVAL, as balances are sent to Graphite after each refresh")
 	}
 
-	c.clientCache[rpcURL] = client
-	log.Printf("Successfully connected to RPC URL: %s", rpcURL)
-	return client, nil
-}
+	snapshotInterval, err := collector.ParseSnapshotInterval(os.Getenv("SNAPSHOT_INTERVAL"))
+	if err != nil {
+		log.Fatalf("Error parsing SNAPSHOT_INTERVAL: %v", err)
+	}
 
-// getWalletBalance retrieves the balance of the wallet.
-func (c *WalletBalanceCollector) getWalletBalance(client *ethclient.Client, walletAddress string) (float64, error) {
-	address := common.HexToAddress(walletAddress)
-	balanceWei, err := client.BalanceAt(context.Background(), address, nil)
+	beaconURL, err := collector.ParseBeaconURL(os.Getenv("BEACON_URL"))
+	if err != nil {
+		log.Fatalf("Error parsing BEACON_URL: %v", err)
+	}
+	validators, err := collector.ParseValidators(os.Getenv("VALIDATORS"))
 	if err != nil {
-		return 0, err
+		log.Fatalf("Error parsing VALIDATORS: %v", err)
+	}
+	if beaconURL != "" && len(validators) == 0 {
+		log.Fatal("BEACON_URL requires VALIDATORS, the validators whose balances are exported")
 	}
 
-	// Convert Wei to ETH (1 ETH = 10^18 Wei)
-	balanceETH := new(big.Float).Quo(new(big.Float).SetInt(balanceWei), big.NewFloat(1e18))
-	balance, _ := balanceETH.Float64()
-	return balance, nil
-}
+	remoteWriteURL, err := collector.ParseRemoteWriteURL(os.Getenv("REMOTE_WRITE_URL"))
+	if err != nil {
+		log.Fatalf("Error parsing REMOTE_WRITE_URL: %v", err)
+	}
+	remoteWriteInterval, err := collector.ParseDuration(os.Getenv("REMOTE_WRITE_INTERVAL"), collector.DefaultRemoteWriteInterval)
+	if err != nil {
+		log.Fatalf("Error parsing REMOTE_WRITE_INTERVAL: %v", err)
+	}
 
-// parseRPCMapping parses the RPC_URL_MAPPING environment variable into a map of RPC URLs and associated wallet addresses.
-func parseRPCMapping(rpcMapping string) (map[string][]string, error) {
-	rpcWalletMapping := make(map[string][]string)
-	mappings := strings.Split(rpcMapping, "|")
+	failIfAllDown, err := collector.ParseBool(os.Getenv("FAIL_IF_ALL_DOWN"))
+	if err != nil {
+		log.Fatalf("Error parsing FAIL_IF_ALL_DOWN: %v", err)
+	}
+	shutdownTimeout, err := collector.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"), collector.DefaultShutdownTimeout)
+	if err != nil {
+		log.Fatalf("Error parsing SHUTDOWN_TIMEOUT: %v", err)
+	}
 
-	for _, mapping := range mappings {
-		mapping = strings.TrimSpace(mapping)
+	// Export traces of RPC calls if an OTLP endpoint is configured
+	if tracing, err := setupTracing(context.Background()); err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	} else if tracing {
+		log.Print("Exporting traces over OTLP")
+	}
 
-		// Locate the first colon after 'http://' or 'https://'
-		colonIndex := strings.Index(mapping, ":")
-		if strings.HasPrefix(mapping, "http://") {
-			colonIndex = strings.Index(mapping[7:], ":") + 7
-		} else if strings.HasPrefix(mapping, "https://") {
-			colonIndex = strings.Index(mapping[8:], ":") + 8
+	// Create the registry with the Go runtime and process metrics, and register the balanceCollector
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	balanceCollector, err := collector.Register(registry, endpoints, config)
+	if err != nil {
+		log.Fatalf("Error registering collector: %v", err)
+	}
+
+	// Export validator balances from the beacon node if one is configured
+	if beaconURL != "" {
+		registry.MustRegister(collector.NewBeaconCollector(beaconURL, validators, config.RPCTimeout, config.Proxy))
+		log.Printf("Exporting balances of %d validators", len(validators))
+	}
+
+	// Add the wallets persisted by the admin API
+	if walletsFile := os.Getenv("ADMIN_WALLETS_FILE"); walletsFile != "" {
+		if err := balanceCollector.LoadWalletsFile(walletsFile); err != nil {
+			log.Fatalf("Error loading ADMIN_WALLETS_FILE: %v", err)
 		}
+	}
 
-		if colonIndex == -1 || colonIndex == len(mapping)-1 {
-			return nil, fmt.Errorf("invalid format in RPC_URL_MAPPING: %s (missing colon or wallets)", mapping)
+	// Restore the balances persisted before the last restart
+	if cacheFile := os.Getenv("BALANCE_CACHE_FILE"); cacheFile != "" {
+		if err := balanceCollector.LoadBalanceCache(cacheFile); err != nil {
+			log.Printf("Error loading BALANCE_CACHE_FILE, starting without cached balances: %v", err)
 		}
+	}
 
-		rpcURL := mapping[:colonIndex]
-		wallets := mapping[colonIndex+1:]
+	// Print the resolved wallets for a dry run
+	if *printWallets {
+		if err := balanceCollector.PrintWallets(os.Stdout); err != nil {
+			log.Fatalf("Error printing wallets: %v", err)
+		}
+		return
+	}
 
-		// Validate RPC URL format
-		if !strings.HasPrefix(rpcURL, "http://") && !strings.HasPrefix(rpcURL, "https://") {
-			return nil, fmt.Errorf("invalid RPC URL: %s (must start with http:// or https://)", rpcURL)
+	// Check connectivity at startup, failing if requested and no endpoint is reachable
+	if failIfAllDown {
+		if balanceCollector.CheckEndpoints(context.Background()) == 0 {
+			log.Fatal("No provider is reachable at startup and FAIL_IF_ALL_DOWN is set")
 		}
+	} else {
+		go balanceCollector.CheckEndpoints(context.Background())
+	}
 
-		// Split wallet addresses into a slice
-		walletList := strings.Split(wallets, ",")
-		rpcWalletMapping[rpcURL] = walletList
+	go balanceCollector.DetectContracts(context.Background())
+
+	if consul != nil {
+		log.Printf("Reloading the configuration from Consul every %s", consulInterval)
+		go balanceCollector.RunConsul(consul, consulInterval)
+	} else if *configFile != "" {
+		// Reload the config file when it changes and on SIGHUP
+		if err := balanceCollector.WatchConfigFile(*configFile, watchDelay); err != nil {
+			log.Printf("Error watching config file, changes are only reloaded on SIGHUP: %v", err)
+		}
+
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				if err := balanceCollector.ReloadConfigFile(*configFile); err != nil {
+					log.Printf("Error reloading config file, keeping the current configuration: %v", err)
+				}
+			}
+		}()
 	}
 
-	return rpcWalletMapping, nil
-}
+	if vault != nil {
+		log.Printf("Reading secrets from Vault every %s", vaultInterval)
+		go balanceCollector.RunVault(vaultInterval)
+	}
 
-func main() {
-	// Get RPC_URL_MAPPING from environment variables
-	rpcMapping := os.Getenv("RPC_URL_MAPPING")
-	if rpcMapping == "" {
-		log.Fatal("RPC_URL_MAPPING environment variable must be set")
+	if config.NamingServiceURL != "" {
+		log.Printf("Resolving wallet names from the naming service every %s", namingInterval)
+		go balanceCollector.RunNamingService(namingInterval)
 	}
 
-	rpcWalletMapping, err := parseRPCMapping(rpcMapping)
-	if err != nil {
-		log.Fatalf("Error parsing RPC_URL_MAPPING: %v", err)
+	// Resolve the ENS names of wallets, including those added by later reloads
+	go balanceCollector.RunENS(ensInterval)
+
+	if len(config.FiatCurrencies) > 0 {
+		log.Printf("Fetching %s prices every %s", strings.Join(config.FiatCurrencies, ", "), priceInterval)
+		go balanceCollector.RunPrices(priceInterval)
 	}
 
-	// Create and register the Prometheus collector
-	collector := NewWalletBalanceCollector(rpcWalletMapping)
-	prometheus.MustRegister(collector)
+	if config.RefreshInterval > 0 {
+		log.Printf("Refreshing balances every %s with up to %s jitter", config.RefreshInterval, config.RefreshJitter)
+		go balanceCollector.RunRefresh()
+	}
+
+	if snapshotInterval > 0 {
+		log.Printf("Taking balance snapshots every %s", snapshotInterval)
+		go balanceCollector.RunSnapshots(snapshotInterval)
+	}
+
+	// Push metrics to a remote-write endpoint if configured, in addition to serving them for scrapes
+	if remoteWriteURL != "" {
+		log.Printf("Pushing metrics over remote write every %s", remoteWriteInterval)
+		go collector.NewRemoteWriter(remoteWriteURL, registry).Run(remoteWriteInterval)
+	}
+
+	// Expose metrics at the metrics path, the last known balances at /balances and the last fetch
+	// errors at /errors
+	http.Handle(*metricsPath, collector.MetricsHandler(registry, balanceCollector))
+	http.HandleFunc("/balances", balanceCollector.ServeBalances)
+	http.HandleFunc("/errors", balanceCollector.ServeErrors)
+
+	// Expose the admin API at /wallets, /endpoints and /reconnect and the running configuration at
+	// /config if a token is configured
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		http.Handle("/wallets", balanceCollector.WalletsHandler(adminToken))
+		http.Handle("/endpoints", balanceCollector.EndpointsHandler(adminToken))
+		http.Handle("/reconnect", balanceCollector.ReconnectHandler(adminToken))
+		http.Handle("/config", balanceCollector.ConfigHandler(adminToken))
+	}
 
-	// Expose metrics at /metrics
-	http.Handle("/metrics", promhttp.Handler())
+	// Serve the gRPC health service for service meshes on its own port if configured
+	var grpcServer *grpc.Server
+	if grpcHealthPort > 0 {
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(grpcHealthPort))
+		if err != nil {
+			log.Fatalf("Error starting gRPC health service: %v", err)
+		}
+		grpcServer = balanceCollector.GRPCHealthServer()
+		log.Printf("Serving gRPC health checks on port %d", grpcHealthPort)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("Error serving gRPC health checks: %v", err)
+			}
+		}()
+	}
+
+	// On SIGINT or SIGTERM, stop accepting connections and give the requests in progress, such as slow
+	// scrapes, the grace period to finish before closing the remaining connections
+	server := &http.Server{Addr: *listenAddress}
+	stopped := make(chan struct{})
+	stops := make(chan os.Signal, 1)
+	signal.Notify(stops, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(stopped)
+		stop := <-stops
+		log.Printf("Received %s, shutting down within %s", stop, shutdownTimeout)
+		if grpcServer != nil {
+			// Health checks fail from now on, so that the mesh stops routing to the exporter
+			grpcServer.Stop()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Requests still in progress after %s, closing their connections", shutdownTimeout)
+			server.Close()
+		}
+	}()
 
 	// Start the HTTP server
-	port := "8080"
-	log.Printf("Starting server on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	log.Printf("Starting server on %s", *listenAddress)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Error starting server: %v", err)
 	}
+	<-stopped
+	log.Print("Server stopped")
 }
@@ -0,0 +1,437 @@
+package collector
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.yaml.in/yaml/v2"
+)
+
+var (
+	// errUnknownEndpoint is returned by the admin API for an RPC URL that is not configured.
+	errUnknownEndpoint = errors.New("unknown rpc_url (wallets can only be added to configured endpoints and endpoints added through /endpoints)")
+	// errInvalidEndpoint is returned when adding an endpoint whose RPC URL is not an HTTP(S) or WebSocket URL.
+	errInvalidEndpoint = errors.New("invalid rpc_url (must be an http, https, ws or wss URL)")
+	// errEndpointExists is returned when adding an endpoint whose RPC URL or name is already monitored.
+	errEndpointExists = errors.New("an endpoint with this rpc_url or name is already monitored")
+	// errEndpointNotFound is returned when removing an endpoint that is not monitored.
+	errEndpointNotFound = errors.New("endpoint is not monitored")
+	// errWalletExists is returned when adding a wallet that is already monitored on the endpoint.
+	errWalletExists = errors.New("wallet is already monitored on this endpoint")
+	// errWalletNotFound is returned when removing a wallet that is not monitored on the endpoint.
+	errWalletNotFound = errors.New("wallet is not monitored on this endpoint")
+)
+
+// AdminWallet is a wallet added to or removed from an endpoint through the admin API.
+type AdminWallet struct {
+	RPCURL  string `json:"rpc_url" yaml:"rpc_url"`
+	Address string `json:"address" yaml:"address"`
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+}
+
+// AdminEndpoint is an endpoint added to or removed from the monitored endpoints through the admin API.
+// An added endpoint has no wallets until they are added through AddWallet.
+type AdminEndpoint struct {
+	RPCURL string `json:"rpc_url" yaml:"rpc_url"`
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Chain  string `json:"chain,omitempty" yaml:"chain,omitempty"`
+}
+
+// adminWalletsFile is the layout of the file that persists endpoints and wallets added through the
+// admin API.
+type adminWalletsFile struct {
+	Endpoints []AdminEndpoint `yaml:"endpoints,omitempty"`
+	Wallets   []AdminWallet   `yaml:"wallets"`
+}
+
+// getEndpoints returns the monitored endpoints, with wallets configured by ENS name at their resolved
+// addresses. The returned slice is never modified, so it may be used after wallets are added or removed.
+func (c *WalletBalanceCollector) getEndpoints() []EndpointConfig {
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+	return c.withENSAddresses(c.endpoints)
+}
+
+// AddWallet starts monitoring a wallet on the configured endpoint with the wallet's RPC URL. The wallet
+// is included from the next collection or refresh on.
+func (c *WalletBalanceCollector) AddWallet(wallet AdminWallet) error {
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+
+	wallet, err := c.addWallet(wallet)
+	if err != nil {
+		return err
+	}
+	c.added = append(c.added, wallet)
+	return c.saveWalletsFile()
+}
+
+// addWallet adds the wallet to a copy of the endpoints and returns it with its address normalized. It
+// must be called with endpointsMutex held.
+func (c *WalletBalanceCollector) addWallet(wallet AdminWallet) (AdminWallet, error) {
+	address, err := NormalizeAddress(wallet.Address)
+	if err != nil {
+		return wallet, err
+	}
+	wallet.Address = address
+
+	endpoints := make([]EndpointConfig, len(c.endpoints))
+	copy(endpoints, c.endpoints)
+	for i, endpoint := range endpoints {
+		if endpoint.URL != wallet.RPCURL {
+			continue
+		}
+		for _, existing := range endpoint.Wallets {
+			if strings.EqualFold(existing.Address, address) {
+				return wallet, errWalletExists
+			}
+		}
+
+		wallets := make([]WalletConfig, len(endpoint.Wallets), len(endpoint.Wallets)+1)
+		copy(wallets, endpoint.Wallets)
+		endpoints[i].Wallets = append(wallets, WalletConfig{Address: address, Name: wallet.Name, parsed: common.HexToAddress(address)})
+		c.endpoints = endpoints
+		log.Printf("Added wallet %s on provider %s", address, endpoint.Name)
+		return wallet, nil
+	}
+	return wallet, errUnknownEndpoint
+}
+
+// AddEndpoint starts monitoring an endpoint, without wallets until they are added through AddWallet.
+// An endpoint without a name is named after the host of its URL.
+func (c *WalletBalanceCollector) AddEndpoint(endpoint AdminEndpoint) error {
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+
+	if err := c.addEndpoint(endpoint); err != nil {
+		return err
+	}
+	c.addedEndpoints = append(c.addedEndpoints, endpoint)
+	return c.saveWalletsFile()
+}
+
+// addEndpoint adds the endpoint to a copy of the endpoints. It must be called with endpointsMutex held.
+func (c *WalletBalanceCollector) addEndpoint(endpoint AdminEndpoint) error {
+	parsed, err := url.Parse(endpoint.RPCURL)
+	if err != nil || parsed.Host == "" || !slices.Contains([]string{"http", "https", "ws", "wss"}, parsed.Scheme) {
+		return errInvalidEndpoint
+	}
+	for _, existing := range c.endpoints {
+		if existing.URL == endpoint.RPCURL || (endpoint.Name != "" && existing.Name == endpoint.Name) {
+			return errEndpointExists
+		}
+	}
+
+	endpoints := append(slices.Clone(c.endpoints), EndpointConfig{URL: endpoint.RPCURL, Name: endpoint.Name, Chain: endpoint.Chain})
+	if err := AssignProviderNames(endpoints); err != nil {
+		return err
+	}
+	if c.config.UnifiedBalanceMetric {
+		if err := validateUnifiedAssets(endpoints); err != nil {
+			return err
+		}
+	}
+	c.endpoints = endpoints
+	c.staggerStart(endpoints)
+	log.Printf("Added provider %s", endpoints[len(endpoints)-1].Name)
+	return nil
+}
+
+// RemoveEndpoint stops monitoring the endpoint with the given RPC URL and its wallets, closes its client
+// and forgets their cached balances. Endpoints from the configuration are monitored again after a restart.
+func (c *WalletBalanceCollector) RemoveEndpoint(rpcURL string) error {
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+
+	index := slices.IndexFunc(c.endpoints, func(endpoint EndpointConfig) bool { return endpoint.URL == rpcURL })
+	if index < 0 {
+		return errEndpointNotFound
+	}
+	removed := c.endpoints[index]
+	c.endpoints = slices.Delete(slices.Clone(c.endpoints), index, index+1)
+	for _, wallet := range removed.Wallets {
+		c.forgetWallet(removed, wallet.Address)
+	}
+	c.forgetEndpoint(removed, true)
+	log.Printf("Removed provider %s", removed.Name)
+
+	c.addedEndpoints = slices.DeleteFunc(c.addedEndpoints, func(endpoint AdminEndpoint) bool { return endpoint.RPCURL == rpcURL })
+	c.added = slices.DeleteFunc(c.added, func(wallet AdminWallet) bool { return wallet.RPCURL == rpcURL })
+	return c.saveWalletsFile()
+}
+
+// RemoveWallet stops monitoring a wallet on the endpoint with the given RPC URL and forgets its cached
+// balances. Wallets from the configuration are monitored again after a restart.
+func (c *WalletBalanceCollector) RemoveWallet(rpcURL, walletAddress string) error {
+	address, err := NormalizeAddress(walletAddress)
+	if err != nil {
+		return err
+	}
+
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+
+	endpoints := make([]EndpointConfig, len(c.endpoints))
+	copy(endpoints, c.endpoints)
+	for i, endpoint := range endpoints {
+		if endpoint.URL != rpcURL {
+			continue
+		}
+
+		var (
+			wallets []WalletConfig
+			removed *WalletConfig
+		)
+		for _, wallet := range endpoint.Wallets {
+			if removed == nil && strings.EqualFold(wallet.Address, address) {
+				removed = &wallet
+				continue
+			}
+			wallets = append(wallets, wallet)
+		}
+		if removed == nil {
+			return errWalletNotFound
+		}
+
+		endpoints[i].Wallets = wallets
+		c.endpoints = endpoints
+		c.forgetWallet(endpoint, removed.Address)
+		log.Printf("Removed wallet %s on provider %s", removed.Address, endpoint.Name)
+
+		added := c.added[:0]
+		for _, wallet := range c.added {
+			if wallet.RPCURL != rpcURL || !strings.EqualFold(wallet.Address, removed.Address) {
+				added = append(added, wallet)
+			}
+		}
+		c.added = added
+		return c.saveWalletsFile()
+	}
+	return errUnknownEndpoint
+}
+
+// forgetWallet drops the cached balances, last error, contract status, snapshot and refresh result of a
+// removed wallet.
+func (c *WalletBalanceCollector) forgetWallet(endpoint EndpointConfig, walletAddress string) {
+	key := balanceKey(endpoint.URL, walletAddress)
+
+	c.cacheMutex.Lock()
+	delete(c.lastBalances, key)
+	delete(c.lastErrors, key)
+	delete(c.contracts, key)
+	delete(c.emitted, key)
+	var refreshed []fetchResult
+	for _, result := range c.refreshed {
+		if balanceKey(result.endpoint.URL, result.wallet.Address) != key {
+			refreshed = append(refreshed, result)
+		}
+	}
+	c.refreshed = refreshed
+	c.cacheMutex.Unlock()
+
+	c.snapshotMutex.Lock()
+	delete(c.snapshots, key)
+	c.snapshotMutex.Unlock()
+
+	c.walletDuration.DeleteLabelValues(c.addressLabel(walletAddress), endpoint.Name)
+}
+
+// LoadWalletsFile adds the endpoints and wallets persisted in path and persists later additions there. A
+// missing file is created on the first addition. Persisted endpoints that are now configured, and wallets
+// whose endpoint is no longer configured, are skipped.
+func (c *WalletBalanceCollector) LoadWalletsFile(path string) error {
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+
+	c.walletsFile = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file adminWalletsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("invalid wallets file %s: %v", path, err)
+	}
+
+	for _, endpoint := range file.Endpoints {
+		if err := c.addEndpoint(endpoint); err != nil {
+			log.Printf("Skipping endpoint %s from %s: %v", redactURL(endpoint.RPCURL), path, err)
+			continue
+		}
+		c.addedEndpoints = append(c.addedEndpoints, endpoint)
+	}
+	for _, wallet := range file.Wallets {
+		wallet, err := c.addWallet(wallet)
+		if err != nil {
+			log.Printf("Skipping wallet %s from %s: %v", wallet.Address, path, err)
+			continue
+		}
+		c.added = append(c.added, wallet)
+	}
+	return nil
+}
+
+// saveWalletsFile persists the endpoints and wallets added through the admin API, if a wallets file is
+// configured. It must be called with endpointsMutex held.
+func (c *WalletBalanceCollector) saveWalletsFile() error {
+	if c.walletsFile == "" {
+		return nil
+	}
+
+	data, err := yaml.Marshal(adminWalletsFile{Endpoints: c.addedEndpoints, Wallets: c.added})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.walletsFile, data)
+}
+
+// authorized reports whether the request carries the admin token as a bearer token in the
+// Authorization header, answering it with 401 Unauthorized if it does not.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// WalletsHandler serves the admin API for adding (POST) and removing (DELETE) wallets at runtime. Both
+// take an AdminWallet as JSON and require the token as a bearer token in the Authorization header.
+func (c *WalletBalanceCollector) WalletsHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var wallet AdminWallet
+		if err := json.NewDecoder(r.Body).Decode(&wallet); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, err := NormalizeAddress(wallet.Address); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		status := http.StatusNoContent
+		if r.Method == http.MethodPost {
+			err = c.AddWallet(wallet)
+			status = http.StatusCreated
+		} else {
+			err = c.RemoveWallet(wallet.RPCURL, wallet.Address)
+		}
+
+		switch {
+		case errors.Is(err, errUnknownEndpoint), errors.Is(err, errWalletNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errWalletExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			log.Printf("Error persisting admin wallet change: %v", err)
+			http.Error(w, "wallet change applied but could not be persisted", http.StatusInternalServerError)
+		default:
+			w.WriteHeader(status)
+		}
+	})
+}
+
+// EndpointsHandler serves the admin API for adding (POST) and removing (DELETE) endpoints at runtime.
+// Both take an AdminEndpoint as JSON and require the token as a bearer token in the Authorization header.
+func (c *WalletBalanceCollector) EndpointsHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var endpoint AdminEndpoint
+		if err := json.NewDecoder(r.Body).Decode(&endpoint); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		status := http.StatusNoContent
+		if r.Method == http.MethodPost {
+			err = c.AddEndpoint(endpoint)
+			status = http.StatusCreated
+		} else {
+			err = c.RemoveEndpoint(endpoint.RPCURL)
+		}
+
+		switch {
+		case errors.Is(err, errInvalidEndpoint):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errEndpointNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errEndpointExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			log.Printf("Error persisting admin endpoint change: %v", err)
+			http.Error(w, "endpoint change applied but could not be persisted", http.StatusInternalServerError)
+		default:
+			w.WriteHeader(status)
+		}
+	})
+}
+
+// Reconnect closes and drops every cached client, so that the next fetch dials each endpoint again. It
+// returns the number of clients closed. Fetches in flight over a closed client fail.
+func (c *WalletBalanceCollector) Reconnect() int {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	closed := len(c.clientCache)
+	for _, client := range c.clientCache {
+		client.Close()
+	}
+	clear(c.clientCache)
+	clear(c.clientCreated)
+	return closed
+}
+
+// ReconnectHandler serves POST /reconnect, which closes every provider connection so that the next
+// fetch dials them again, e.g. during a provider incident. It requires the token as a bearer token in
+// the Authorization header.
+func (c *WalletBalanceCollector) ReconnectHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("Closed %d provider connections on admin request", c.Reconnect())
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
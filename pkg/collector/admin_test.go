@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const adminToken = "s3cret"
+
+// adminRequest sends a request with a JSON body to an admin API handler and returns the response status.
+func adminRequest(handler http.Handler, method, authorization, body string) int {
+	request := httptest.NewRequest(method, "/admin", strings.NewReader(body))
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+// newAdminCollector returns a collector monitoring one wallet on http://configured.invalid, with its
+// admin changes persisted to path.
+func newAdminCollector(t *testing.T, path string) *WalletBalanceCollector {
+	t.Helper()
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	c := New([]EndpointConfig{{
+		Name:    "configured",
+		URL:     "http://configured.invalid",
+		Wallets: []WalletConfig{{Address: address, parsed: common.HexToAddress(address)}},
+	}}, CollectorConfig{})
+	if err := c.LoadWalletsFile(path); err != nil {
+		t.Fatalf("LoadWalletsFile() error = %v", err)
+	}
+	return c
+}
+
+// monitoredWallets returns the addresses of the wallets monitored on the endpoint with the RPC URL, and
+// whether the endpoint is monitored.
+func monitoredWallets(c *WalletBalanceCollector, rpcURL string) ([]string, bool) {
+	for _, endpoint := range c.getEndpoints() {
+		if endpoint.URL == rpcURL {
+			var addresses []string
+			for _, wallet := range endpoint.Wallets {
+				addresses = append(addresses, wallet.Address)
+			}
+			return addresses, true
+		}
+	}
+	return nil, false
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	c := New(nil, CollectorConfig{})
+	handlers := map[string]http.Handler{
+		"wallets":   c.WalletsHandler(adminToken),
+		"endpoints": c.EndpointsHandler(adminToken),
+		"reconnect": c.ReconnectHandler(adminToken),
+	}
+	for name, handler := range handlers {
+		for _, authorization := range []string{"", "Bearer wrong", "Basic " + adminToken, adminToken} {
+			if status := adminRequest(handler, http.MethodPost, authorization, `{}`); status != http.StatusUnauthorized {
+				t.Errorf("%s with Authorization %q: status %d, want 401", name, authorization, status)
+			}
+		}
+		if status := adminRequest(handler, http.MethodGet, "Bearer "+adminToken, ""); status != http.StatusMethodNotAllowed {
+			t.Errorf("%s GET: status %d, want 405", name, status)
+		}
+	}
+}
+
+func TestWalletsHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallets.yml")
+	c := newAdminCollector(t, path)
+	handler := c.WalletsHandler(adminToken)
+	send := func(method, body string) int {
+		return adminRequest(handler, method, "Bearer "+adminToken, body)
+	}
+
+	added := `{"rpc_url":"http://configured.invalid","address":"0x000d836201318ec6899a67540690382780743280","name":"hot"}`
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"add", http.MethodPost, added, http.StatusCreated},
+		{"add again", http.MethodPost, strings.Replace(added, "0x000d8362", "0x000D8362", 1), http.StatusConflict},
+		{"add a configured wallet", http.MethodPost, `{"rpc_url":"http://configured.invalid","address":"0x742d35cc6634c0532925a3b844bc454e4438f44e"}`, http.StatusConflict},
+		{"add on an unknown endpoint", http.MethodPost, `{"rpc_url":"http://unknown.invalid","address":"0x000d836201318ec6899a67540690382780743280"}`, http.StatusNotFound},
+		{"add an invalid address", http.MethodPost, `{"rpc_url":"http://configured.invalid","address":"0x1234"}`, http.StatusBadRequest},
+		{"add an invalid body", http.MethodPost, `{"rpc_url":`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := send(test.method, test.body); status != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.want)
+		}
+	}
+
+	want := []string{"0x742d35Cc6634C0532925a3b844Bc454e4438f44e", "0x000d836201318ec6899a67540690382780743280"}
+	if wallets, _ := monitoredWallets(c, "http://configured.invalid"); !slices.Equal(wallets, want) {
+		t.Errorf("monitored wallets %v, want %v", wallets, want)
+	}
+	// The added wallet is persisted, and monitored again after a restart
+	if wallets, _ := monitoredWallets(newAdminCollector(t, path), "http://configured.invalid"); !slices.Equal(wallets, want) {
+		t.Errorf("monitored wallets after a restart %v, want %v", wallets, want)
+	}
+
+	if status := send(http.MethodDelete, added); status != http.StatusNoContent {
+		t.Errorf("remove: status %d, want 204", status)
+	}
+	if status := send(http.MethodDelete, added); status != http.StatusNotFound {
+		t.Errorf("remove again: status %d, want 404", status)
+	}
+	want = want[:1]
+	if wallets, _ := monitoredWallets(c, "http://configured.invalid"); !slices.Equal(wallets, want) {
+		t.Errorf("monitored wallets after removal %v, want %v", wallets, want)
+	}
+	if wallets, _ := monitoredWallets(newAdminCollector(t, path), "http://configured.invalid"); !slices.Equal(wallets, want) {
+		t.Errorf("monitored wallets after removal and a restart %v, want %v", wallets, want)
+	}
+}
+
+func TestEndpointsHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallets.yml")
+	c := newAdminCollector(t, path)
+	endpoints := c.EndpointsHandler(adminToken)
+	send := func(handler http.Handler, method, body string) int {
+		return adminRequest(handler, method, "Bearer "+adminToken, body)
+	}
+
+	added := `{"rpc_url":"https://added.invalid/v2/key","name":"added"}`
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"add", added, http.StatusCreated},
+		{"add the same URL", `{"rpc_url":"https://added.invalid/v2/key","name":"other"}`, http.StatusConflict},
+		{"add the same name", `{"rpc_url":"https://other.invalid","name":"configured"}`, http.StatusConflict},
+		{"add an invalid URL", `{"rpc_url":"ftp://added.invalid"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := send(endpoints, http.MethodPost, test.body); status != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.want)
+		}
+	}
+
+	wallet := `{"rpc_url":"https://added.invalid/v2/key","address":"0x000d836201318ec6899a67540690382780743280"}`
+	if status := send(c.WalletsHandler(adminToken), http.MethodPost, wallet); status != http.StatusCreated {
+		t.Fatalf("add a wallet to the added endpoint: status %d, want 201", status)
+	}
+	want := []string{"0x000d836201318ec6899a67540690382780743280"}
+	// The added endpoint and its wallet are persisted, and monitored again after a restart
+	if wallets, ok := monitoredWallets(newAdminCollector(t, path), "https://added.invalid/v2/key"); !ok || !slices.Equal(wallets, want) {
+		t.Errorf("added endpoint after a restart: monitored %v with wallets %v, want wallets %v", ok, wallets, want)
+	}
+
+	if status := send(endpoints, http.MethodDelete, added); status != http.StatusNoContent {
+		t.Errorf("remove: status %d, want 204", status)
+	}
+	if status := send(endpoints, http.MethodDelete, added); status != http.StatusNotFound {
+		t.Errorf("remove again: status %d, want 404", status)
+	}
+	if _, ok := monitoredWallets(c, "https://added.invalid/v2/key"); ok {
+		t.Error("removed endpoint still monitored")
+	}
+	if _, ok := monitoredWallets(newAdminCollector(t, path), "https://added.invalid/v2/key"); ok {
+		t.Error("removed endpoint monitored again after a restart")
+	}
+}
@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nativeAsset is the asset label of native balances in the unified wallet_balance metric, which, like
+// wallet_balance_eth, does not distinguish between the native tokens of different chains.
+const nativeAsset = "ETH"
+
+// newBalanceMetric returns the descriptor of wallet_balance_eth, with a source label if withSource is
+// set and a verified label if withVerified is set.
+func newBalanceMetric(templates []LabelTemplate, withSource, withVerified bool) *prometheus.Desc {
+	return prometheus.NewDesc(
+		"wallet_balance_eth",
+		"Balance of the specified wallet in ETH",
+		walletLabelNames(templates, balanceLabelNames(withSource, withVerified, "wallet", "chain_id", "derivation_index")...),
+		nil,
+	)
+}
+
+// newUnifiedMetric returns the descriptor of wallet_balance, which holds native and token balances
+// with an asset label when CollectorConfig.UnifiedBalanceMetric is set, a source label if withSource
+// is set and a verified label if withVerified is set.
+func newUnifiedMetric(templates []LabelTemplate, withSource, withVerified bool) *prometheus.Desc {
+	return prometheus.NewDesc(
+		"wallet_balance",
+		"Balance of the specified asset held by the wallet, in ETH for the native balance and in whole tokens for ERC-20 tokens",
+		walletLabelNames(templates, balanceLabelNames(withSource, withVerified, "wallet", "chain_id", "derivation_index", "asset")...),
+		nil,
+	)
+}
+
+// balanceLabelNames appends the optional source and verified labels to the builtin labels of a
+// balance metric.
+func balanceLabelNames(withSource, withVerified bool, builtin ...string) []string {
+	if withSource {
+		builtin = append(builtin, "source")
+	}
+	if withVerified {
+		builtin = append(builtin, "verified")
+	}
+	return builtin
+}
+
+// balanceLabels returns the builtin label values of a wallet's native balance fetched from source,
+// which is empty for a balance that could not be fetched, and whether it was verified.
+func (c *WalletBalanceCollector) balanceLabels(wallet WalletConfig, chainID, source string, verified bool) []string {
+	labels := []string{c.addressLabel(wallet.Address), chainID, wallet.derivationLabel()}
+	if c.config.UnifiedBalanceMetric {
+		labels = append(labels, nativeAsset)
+	}
+	if c.etherscan != nil {
+		labels = append(labels, source)
+	}
+	if c.verifyProofs {
+		labels = append(labels, strconv.FormatBool(verified))
+	}
+	return labels
+}
+
+// tokenLabels returns the builtin label values of a wallet's token balance.
+func (c *WalletBalanceCollector) tokenLabels(wallet WalletConfig, chainID, symbol string) []string {
+	if !c.config.UnifiedBalanceMetric {
+		return []string{c.addressLabel(wallet.Address), chainID, symbol}
+	}
+	labels := []string{c.addressLabel(wallet.Address), chainID, wallet.derivationLabel(), symbol}
+	if c.etherscan != nil {
+		// Token balances are only fetched over RPC
+		labels = append(labels, sourceRPC)
+	}
+	if c.verifyProofs {
+		// Token balances are not verified
+		labels = append(labels, "false")
+	}
+	return labels
+}
+
+// validateUnifiedAssets rejects tokens whose symbol is the native asset label, as their balances
+// would collide with the native balances in wallet_balance.
+func validateUnifiedAssets(endpoints []EndpointConfig) error {
+	for _, endpoint := range endpoints {
+		for _, token := range endpoint.Tokens {
+			if token.Symbol == nativeAsset {
+				return fmt.Errorf("token %s of provider %s cannot be exported in wallet_balance, as the %s asset is the native balance", token.Symbol, endpoint.Name, nativeAsset)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachedBalance is a last-known balance as persisted in the balance cache file. Wallets are identified
+// by provider name rather than RPC URL, so that API keys are not written to disk.
+type cachedBalance struct {
+	Provider  string    `json:"provider"`
+	ChainID   string    `json:"chain_id,omitempty"`
+	Wallet    string    `json:"wallet"`
+	Balance   float64   `json:"balance"`
+	Source    string    `json:"source,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// balanceCacheFile is the format of the balance cache file.
+type balanceCacheFile struct {
+	Balances []cachedBalance `json:"balances"`
+}
+
+// LoadBalanceCache restores the last-known balances persisted in path and persists the balances of
+// every later fetch there. Restored balances keep the time they were originally fetched, and are
+// exported until the wallet is fetched again: by the first background refresh, or with STALE_BEHAVIOR
+// hold when a fetch fails. Until an endpoint's chain ID is queried, its chain_id label is the one
+// persisted with its balances. A missing file is created after the first fetch. Balances whose endpoint
+// or wallet is no longer configured are skipped.
+func (c *WalletBalanceCollector) LoadBalanceCache(path string) error {
+	c.balanceCachePath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file balanceCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid balance cache file %s: %v", path, err)
+	}
+
+	cached := make(map[string]cachedBalance, len(file.Balances))
+	for _, balance := range file.Balances {
+		cached[balance.Provider+"|"+strings.ToLower(balance.Wallet)] = balance
+	}
+
+	var restored []fetchResult
+	chainIDs := make(map[string]string)
+	for _, endpoint := range c.getEndpoints() {
+		for _, wallet := range endpoint.Wallets {
+			balance, ok := cached[endpoint.Name+"|"+strings.ToLower(wallet.Address)]
+			if !ok {
+				continue
+			}
+			if balance.ChainID != "" {
+				chainIDs[endpoint.URL] = balance.ChainID
+			}
+			restored = append(restored, fetchResult{
+				endpoint:  endpoint,
+				wallet:    wallet,
+				balance:   balance.Balance,
+				fetchedAt: balance.FetchedAt,
+				source:    balance.Source,
+				restored:  true,
+			})
+		}
+	}
+
+	c.clientMutex.Lock()
+	c.restoredChainIDs = chainIDs
+	c.clientMutex.Unlock()
+
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	for _, result := range restored {
+		key := balanceKey(result.endpoint.URL, result.wallet.Address)
+		if _, fetched := c.lastBalances[key]; !fetched {
+			c.lastBalances[key] = result
+		}
+	}
+	if c.refreshed == nil {
+		c.refreshed = restored
+	}
+	return nil
+}
+
+// saveBalanceCache persists the last-known balances, if a balance cache file is configured. Errors are
+// logged, as the next fetch tries again.
+func (c *WalletBalanceCollector) saveBalanceCache() {
+	if c.balanceCachePath == "" {
+		return
+	}
+
+	c.cacheMutex.Lock()
+	results := slices.Collect(maps.Values(c.lastBalances))
+	c.cacheMutex.Unlock()
+
+	file := balanceCacheFile{Balances: make([]cachedBalance, 0, len(results))}
+	for _, result := range results {
+		file.Balances = append(file.Balances, cachedBalance{
+			Provider:  result.endpoint.Name,
+			ChainID:   c.chainLabel(result.endpoint),
+			Wallet:    result.wallet.Address,
+			Balance:   result.balance,
+			Source:    result.source,
+			FetchedAt: result.fetchedAt,
+		})
+	}
+
+	data, err := json.Marshal(file)
+	if err == nil {
+		err = writeFileAtomic(c.balanceCachePath, data)
+	}
+	if err != nil {
+		c.logs.printf("", "Error saving balance cache to %s: %v", c.balanceCachePath, err)
+	}
+}
+
+// writeFileAtomic writes data to path through a temporary file, so that a crash never leaves a
+// truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// withRestoredTime timestamps a balance restored from the balance cache file with the time it was
+// originally fetched, whatever BALANCE_TIMESTAMPS selects, so that a restart does not make it look
+// fresh. Other balances are timestamped as by withFetchTime.
+func (c *WalletBalanceCollector) withRestoredTime(metric prometheus.Metric, fetchedAt time.Time, restored bool) prometheus.Metric {
+	if restored && !fetchedAt.IsZero() {
+		return prometheus.NewMetricWithTimestamp(fetchedAt, metric)
+	}
+	return c.withFetchTime(metric, fetchedAt)
+}
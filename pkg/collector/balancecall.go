@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// balanceMethodPattern matches the signature of a view method taking a single address, such as balanceOf(address).
+var balanceMethodPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\(address\)$`)
+
+// BalanceCallConfig describes a contract method that returns the native balance of an address, for
+// chains where it is not the account balance.
+type BalanceCallConfig struct {
+	Contract string `yaml:"contract" json:"contract"`
+	// Method is the method signature, such as balanceOf(address). It must take a single address
+	// and return the balance in Wei as a uint256.
+	Method string `yaml:"method" json:"method"`
+}
+
+// validate normalizes the contract address and checks the method signature.
+func (b *BalanceCallConfig) validate() error {
+	contract, err := NormalizeAddress(b.Contract)
+	if err != nil {
+		return fmt.Errorf("invalid balance_call contract: %v", err)
+	}
+	b.Contract = contract
+
+	b.Method = strings.ReplaceAll(b.Method, " ", "")
+	if !balanceMethodPattern.MatchString(b.Method) {
+		return fmt.Errorf("invalid balance_call method: %s (must take a single address, e.g. balanceOf(address))", b.Method)
+	}
+	return nil
+}
+
+// callBalance retrieves the balance in Wei of the address by calling the configured contract method at
+// the given block, or at the latest block if blockNumber is nil.
+func callBalance(ctx context.Context, client *ethclient.Client, call *BalanceCallConfig, address common.Address, blockNumber *big.Int) (*big.Int, error) {
+	contract := common.HexToAddress(call.Contract)
+	data := append(crypto.Keccak256([]byte(call.Method))[:4], common.LeftPadBytes(address.Bytes(), 32)...)
+
+	result, err := client.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) < 32 {
+		return nil, fmt.Errorf("unexpected result of %s on %s: %d bytes (expected a uint256)", call.Method, call.Contract, len(result))
+	}
+	return new(big.Int).SetBytes(result[:32]), nil
+}
@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// walletBalance is a row of the /balances endpoint. The balance is kept as a formatted number so that
+// JSON and CSV show it in the same fixed-point notation.
+type walletBalance struct {
+	RPCURL     string      `json:"rpc_url"`
+	ChainID    string      `json:"chain_id"`
+	Wallet     string      `json:"wallet"`
+	Name       string      `json:"name"`
+	BalanceETH json.Number `json:"balance_eth"`
+}
+
+// balancesCSVHeader is the header row of the CSV output of the /balances endpoint.
+var balancesCSVHeader = []string{"rpc_url", "chain_id", "wallet", "name", "balance_eth"}
+
+// lastKnownBalances returns the last successfully fetched balance of each wallet, in config order.
+// Wallets that have not been fetched successfully yet are omitted.
+func (c *WalletBalanceCollector) lastKnownBalances() []walletBalance {
+	endpoints := c.getEndpoints()
+
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	var balances []walletBalance
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			last, ok := c.lastBalances[balanceKey(endpoint.URL, wallet.Address)]
+			if !ok {
+				continue
+			}
+
+			balances = append(balances, walletBalance{
+				RPCURL:     redactURL(endpoint.URL),
+				ChainID:    c.chainLabel(endpoint),
+				Wallet:     wallet.Address,
+				Name:       c.walletName(wallet),
+				BalanceETH: formatDisplay(last.balance, c.config.DisplayDecimals),
+			})
+		}
+	}
+	return balances
+}
+
+// formatDisplay formats a balance in fixed-point notation, never as an exponent such as 1e-09, with
+// the given number of decimal places, or with the fewest that identify the float64 if decimals is zero.
+func formatDisplay(balance float64, decimals int) json.Number {
+	if decimals <= 0 {
+		decimals = -1
+	}
+	return json.Number(strconv.FormatFloat(balance, 'f', decimals, 64))
+}
+
+// ServeBalances serves the last known balances as JSON, or as CSV when requested with ?format=csv
+// or an Accept header preferring text/csv.
+func (c *WalletBalanceCollector) ServeBalances(w http.ResponseWriter, r *http.Request) {
+	balances := c.lastKnownBalances()
+
+	if !wantsCSV(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if balances == nil {
+			balances = []walletBalance{}
+		}
+		if err := json.NewEncoder(w).Encode(balances); err != nil {
+			log.Printf("Error writing balances: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="balances.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write(balancesCSVHeader)
+	for _, balance := range balances {
+		writer.Write([]string{
+			balance.RPCURL,
+			balance.ChainID,
+			balance.Wallet,
+			balance.Name,
+			balance.BalanceETH.String(),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing balances: %v", err)
+	}
+}
+
+// wantsCSV reports whether the request asks for CSV output.
+func wantsCSV(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.EqualFold(format, "csv")
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// fetchBatches fetches the balances of the endpoint's wallets in JSON-RPC batches of up to its batch
+// size, one batch per slot of the endpoint's concurrency. The wallets of a batch that fails as a whole,
+// such as one the provider rejects as too large, and wallets whose call in a batch fails are fetched
+// individually instead, with retries.
+func (c *WalletBalanceCollector) fetchBatches(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, blockNumber *big.Int, results chan<- fetchResult) {
+	semaphore := c.endpointSemaphore(endpoint)
+	for start := 0; start < len(endpoint.Wallets); start += endpoint.BatchSize {
+		wallets := endpoint.Wallets[start:min(start+endpoint.BatchSize, len(endpoint.Wallets))]
+		if !c.acquire(ctx, semaphore) {
+			return
+		}
+		go func() {
+			defer c.release(semaphore)
+
+			balances, err := c.getBatchBalances(ctx, endpoint, client, wallets, blockNumber)
+			if err != nil {
+				c.logEndpointError(endpoint, err, "Error sending batch of %d balance queries to provider %s, querying them individually: %v", len(wallets), endpoint.Name, err)
+			}
+			for i, wallet := range wallets {
+				if err != nil || balances[i] == nil {
+					results <- c.fetchWallet(ctx, endpoint, client, wallet, blockNumber)
+					continue
+				}
+				c.detectContract(ctx, endpoint, client, wallet)
+				results <- c.walletResult(ctx, endpoint, client, wallet, blockNumber, balances[i], nil)
+			}
+		}()
+	}
+}
+
+// getBatchBalances retrieves the balances in Wei of the wallets at the given block, or at the latest
+// block if blockNumber is nil, with a single JSON-RPC batch of eth_getBalance calls. The balance of a
+// wallet whose call failed is nil; the error of the call is not returned, as the wallet is queried again
+// on its own.
+func (c *WalletBalanceCollector) getBatchBalances(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallets []WalletConfig, blockNumber *big.Int) ([]*big.Int, error) {
+	ctx, span := startRPCSpan(ctx, "eth_getBalance", endpoint, attribute.Int("batch_size", len(wallets)))
+	block := "latest"
+	if blockNumber != nil {
+		block = hexutil.EncodeBig(blockNumber)
+		span.SetAttributes(attribute.String("block", blockNumber.String()))
+	}
+
+	batch := make([]rpc.BatchElem, len(wallets))
+	balances := make([]hexutil.Big, len(wallets))
+	for i, wallet := range wallets {
+		batch[i] = rpc.BatchElem{Method: "eth_getBalance", Args: []any{wallet.hexAddress(), block}, Result: &balances[i]}
+	}
+	if err := client.Client().BatchCallContext(ctx, batch); err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		endSpan(span, err)
+		return nil, err
+	}
+
+	fetched := make([]*big.Int, len(wallets))
+	failed := 0
+	for i, call := range batch {
+		if call.Error != nil {
+			c.countRPCError(endpoint, call.Error)
+			failed++
+			continue
+		}
+		fetched[i] = balances[i].ToInt()
+	}
+	span.SetAttributes(attribute.Int("failed", failed))
+	if failed == len(wallets) {
+		endSpan(span, fmt.Errorf("all %d calls of the batch failed", failed))
+	} else {
+		endSpan(span, nil)
+	}
+	return fetched, nil
+}
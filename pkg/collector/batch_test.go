@@ -0,0 +1,205 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// batchServer is a stub JSON-RPC server that records the size of each request, 0 for a single call,
+// and answers batches larger than maxBatch with HTTP 413, as providers limiting batch sizes do.
+type batchServer struct {
+	*httptest.Server
+	maxBatch int
+
+	mutex sync.Mutex
+	sizes []int
+}
+
+func newBatchServer(t *testing.T, maxBatch int, answer func(method string, params []json.RawMessage) (string, error)) *batchServer {
+	server := &batchServer{maxBatch: maxBatch}
+	handler := stubRPCHandler(answer)
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		size := 0
+		var batch []json.RawMessage
+		if json.Unmarshal(body, &batch) == nil {
+			size = len(batch)
+		}
+		server.mutex.Lock()
+		server.sizes = append(server.sizes, size)
+		server.mutex.Unlock()
+
+		if size > server.maxBatch {
+			http.Error(w, "batch too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// batchWallets returns count wallets with the addresses 0x…01, 0x…02 and so on.
+func batchWallets(count int) []WalletConfig {
+	wallets := make([]WalletConfig, count)
+	for i := range wallets {
+		address := fmt.Sprintf("0x%040x", i+1)
+		wallets[i] = WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	}
+	return wallets
+}
+
+// fetchBatchBalances fetches the balances of the wallets from the server in batches of batchSize and
+// returns them by wallet address. Failed fetches are test errors.
+func fetchBatchBalances(t *testing.T, server *batchServer, wallets []WalletConfig, batchSize int) map[string]float64 {
+	t.Helper()
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	endpoint := EndpointConfig{Name: "stub", URL: server.URL, Wallets: wallets, BatchSize: batchSize}
+	c := New([]EndpointConfig{endpoint}, CollectorConfig{})
+	results := make(chan fetchResult, len(wallets))
+	c.fetchBatches(context.Background(), endpoint, client, nil, results)
+
+	balances := make(map[string]float64)
+	for range wallets {
+		result := <-results
+		if result.err != nil {
+			t.Errorf("wallet %s: %v", result.wallet.Address, result.err)
+			continue
+		}
+		balances[result.wallet.Address] = result.balance
+	}
+	return balances
+}
+
+// balanceAnswer answers eth_getBalance with 1 ETH, and eth_getCode with no code.
+func balanceAnswer(method string, _ []json.RawMessage) (string, error) {
+	switch method {
+	case "eth_getBalance":
+		return `"0xde0b6b3a7640000"`, nil
+	case "eth_getCode":
+		return `"0x"`, nil
+	}
+	return "", errors.New("method not found")
+}
+
+// sortedBatches returns the sizes of the batches among the request sizes, in increasing order.
+func sortedBatches(sizes []int) []int {
+	var batches []int
+	for _, size := range sizes {
+		if size > 0 {
+			batches = append(batches, size)
+		}
+	}
+	slices.Sort(batches)
+	return batches
+}
+
+func TestFetchBatchesChunking(t *testing.T) {
+	tests := []struct {
+		wallets   int
+		batchSize int
+		want      []int
+	}{
+		{5, 2, []int{1, 2, 2}},
+		{4, 2, []int{2, 2}},
+		{3, 5, []int{3}},
+		{1, 1, []int{1}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d wallets, batch size %d", test.wallets, test.batchSize), func(t *testing.T) {
+			server := newBatchServer(t, test.batchSize, balanceAnswer)
+			balances := fetchBatchBalances(t, server, batchWallets(test.wallets), test.batchSize)
+			if len(balances) != test.wallets {
+				t.Fatalf("fetched %d balances, want %d", len(balances), test.wallets)
+			}
+			for address, balance := range balances {
+				if balance != 1 {
+					t.Errorf("wallet %s has balance %v, want 1", address, balance)
+				}
+			}
+			if batches := sortedBatches(server.sizes); !slices.Equal(batches, test.want) {
+				t.Errorf("sent batches of %v, want %v", batches, test.want)
+			}
+		})
+	}
+}
+
+// TestFetchBatchesRejectedBatch checks that the wallets of a batch the provider rejects as a whole are
+// fetched individually.
+func TestFetchBatchesRejectedBatch(t *testing.T) {
+	server := newBatchServer(t, 2, balanceAnswer)
+	wallets := batchWallets(3)
+	balances := fetchBatchBalances(t, server, wallets, 3)
+	if len(balances) != len(wallets) {
+		t.Fatalf("fetched %d balances, want %d", len(balances), len(wallets))
+	}
+
+	single := 0
+	for _, size := range server.sizes {
+		if size == 0 {
+			single++
+		}
+	}
+	// Each wallet is fetched on its own with eth_getBalance and eth_getCode
+	if batches := sortedBatches(server.sizes); !slices.Equal(batches, []int{3}) || single != 2*len(wallets) {
+		t.Errorf("sent batches of %v and %d single calls, want one batch of 3 and %d single calls", batches, single, 2*len(wallets))
+	}
+}
+
+// TestFetchBatchesFailedCall checks that a wallet whose call fails within a batch is fetched again on
+// its own, while the other wallets of the batch keep their batched balances.
+func TestFetchBatchesFailedCall(t *testing.T) {
+	wallets := batchWallets(3)
+	failing := `"` + wallets[1].hexAddress().Hex() + `"`
+
+	var (
+		mutex    sync.Mutex
+		requests = make(map[string]int)
+	)
+	server := newBatchServer(t, 3, func(method string, params []json.RawMessage) (string, error) {
+		if method != "eth_getBalance" {
+			return balanceAnswer(method, params)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		address := string(params[0])
+		requests[address]++
+		if address == failing && requests[address] == 1 {
+			return "", errors.New("header not found")
+		}
+		return balanceAnswer(method, params)
+	})
+
+	balances := fetchBatchBalances(t, server, wallets, 3)
+	if len(balances) != len(wallets) {
+		t.Fatalf("fetched %d balances, want %d", len(balances), len(wallets))
+	}
+	for _, wallet := range wallets {
+		want := 1
+		if `"`+wallet.hexAddress().Hex()+`"` == failing {
+			want = 2
+		}
+		if got := requests[`"`+wallet.hexAddress().Hex()+`"`]; got != want {
+			t.Errorf("balance of wallet %s requested %d times, want %d", wallet.Address, got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// validatorID matches a validator index or a 48-byte BLS public key.
+var validatorID = regexp.MustCompile(`^([0-9]+|0x[0-9a-fA-F]{96})$`)
+
+// gweiPerETH is the number of Gwei in one ETH. The beacon API reports balances in Gwei.
+const gweiPerETH = 1e9
+
+// ParseBeaconURL parses the BEACON_URL environment variable. An empty value disables validator balances.
+func ParseBeaconURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	beaconURL, err := url.Parse(value)
+	if err != nil || (beaconURL.Scheme != "http" && beaconURL.Scheme != "https") || beaconURL.Host == "" {
+		return "", fmt.Errorf("invalid beacon node URL: %s (expected e.g. http://beacon:5052)", value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// ParseValidators parses a comma-separated list of validator indices and 0x-prefixed public keys.
+func ParseValidators(value string) ([]string, error) {
+	var validators []string
+	for _, validator := range strings.Split(value, ",") {
+		validator = strings.TrimSpace(validator)
+		if validator == "" {
+			continue
+		}
+		if !validatorID.MatchString(validator) {
+			return nil, fmt.Errorf("invalid validator: %s (must be an index or a 0x-prefixed 48-byte public key)", validator)
+		}
+		validators = append(validators, strings.ToLower(validator))
+	}
+	return validators, nil
+}
+
+// BeaconCollector exports the balances of validators queried from a beacon node REST API. It is
+// independent of the execution-layer RPC endpoints and their metrics.
+type BeaconCollector struct {
+	beaconURL  string
+	validators []string
+	client     *http.Client
+
+	balanceMetric *prometheus.Desc
+	upMetric      *prometheus.Desc
+}
+
+// NewBeaconCollector creates a BeaconCollector for the validators, identified by index or public key.
+// Each collection sends a single request bounded by timeout, through proxy if it is not nil.
+func NewBeaconCollector(beaconURL string, validators []string, timeout time.Duration, proxy func(*http.Request) (*url.URL, error)) *BeaconCollector {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = proxy
+	}
+
+	return &BeaconCollector{
+		beaconURL:  beaconURL,
+		validators: validators,
+		client:     &http.Client{Timeout: timeout, Transport: transport},
+		balanceMetric: prometheus.NewDesc(
+			"validator_balance_eth",
+			"Balance of the specified validator on the beacon chain in ETH",
+			[]string{"pubkey"},
+			nil,
+		),
+		upMetric: prometheus.NewDesc(
+			"beacon_up",
+			"Whether the beacon node answered the last collection (1) or not (0)",
+			nil,
+			nil,
+		),
+	}
+}
+
+// beaconValidatorsResponse is the response of the beacon API's state validators endpoint.
+type beaconValidatorsResponse struct {
+	Data []struct {
+		Index     string `json:"index"`
+		Balance   string `json:"balance"`
+		Validator struct {
+			Pubkey string `json:"pubkey"`
+		} `json:"validator"`
+	} `json:"data"`
+}
+
+// Describe sends the descriptors of the metrics to Prometheus.
+func (b *BeaconCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.balanceMetric
+	ch <- b.upMetric
+}
+
+// Collect fetches the validator balances at the head state and sends them to Prometheus. Validators
+// unknown to the beacon node, such as deposits that are not processed yet, are omitted.
+func (b *BeaconCollector) Collect(ch chan<- prometheus.Metric) {
+	response, err := b.fetchValidators(context.Background())
+	if err != nil {
+		log.Printf("Error fetching validator balances from %s: %v", redactURL(b.beaconURL), err)
+		ch <- prometheus.MustNewConstMetric(b.upMetric, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(b.upMetric, prometheus.GaugeValue, 1)
+
+	for _, validator := range response.Data {
+		gwei, err := strconv.ParseUint(validator.Balance, 10, 64)
+		if err != nil {
+			log.Printf("Invalid balance %q of validator %s: %v", validator.Balance, validator.Index, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			b.balanceMetric,
+			prometheus.GaugeValue,
+			float64(gwei)/gweiPerETH,
+			strings.ToLower(validator.Validator.Pubkey),
+		)
+	}
+}
+
+// fetchValidators queries the head state of the configured validators.
+func (b *BeaconCollector) fetchValidators(ctx context.Context) (*beaconValidatorsResponse, error) {
+	// The validators are indices and hex public keys, which need no escaping
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, b.beaconURL+"/eth/v1/beacon/states/head/validators?id="+strings.Join(b.validators, ","), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := doHTTP(b.client, request, "beacon node")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response beaconValidatorsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return &response, nil
+}
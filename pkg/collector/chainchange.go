@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ChainIDChange selects how an endpoint whose chain ID changes while the exporter runs is handled, such
+// as a load-balanced proxy whose backends serve different chains.
+type ChainIDChange string
+
+const (
+	// ChainIDFollow exports the endpoint's balances under its new chain_id label after the change.
+	ChainIDFollow ChainIDChange = "follow"
+	// ChainIDPin pins each endpoint to the first chain ID it reports and stops exporting its balances
+	// while it reports another one.
+	ChainIDPin ChainIDChange = "pin"
+)
+
+// ParseChainIDChange parses the CHAIN_ID_CHANGE environment variable. An empty value selects
+// ChainIDFollow.
+func ParseChainIDChange(value string) (ChainIDChange, error) {
+	switch handling := ChainIDChange(strings.ToLower(strings.TrimSpace(value))); handling {
+	case "":
+		return ChainIDFollow, nil
+	case ChainIDFollow, ChainIDPin:
+		return handling, nil
+	default:
+		return "", fmt.Errorf("invalid CHAIN_ID_CHANGE: %s (must be one of follow, pin)", value)
+	}
+}
+
+// expectedChainID returns the chain ID the endpoint must report: its expected_chain_id, or with
+// ChainIDPin the first chain ID it reported, pinning chainID if it has none yet. It is empty if any chain
+// ID is accepted.
+func (c *WalletBalanceCollector) expectedChainID(endpoint EndpointConfig, chainID *big.Int) string {
+	if endpoint.ExpectedChainID != "" || c.config.ChainIDChange != ChainIDPin {
+		return endpoint.ExpectedChainID
+	}
+
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+	if _, pinned := c.pinnedChainIDs[endpoint.URL]; !pinned && chainID != nil {
+		c.pinnedChainIDs[endpoint.URL] = chainID.String()
+	}
+	return c.pinnedChainIDs[endpoint.URL]
+}
+
+// pinnedChainID returns the chain ID the endpoint is pinned to with ChainIDPin, if it is.
+func (c *WalletBalanceCollector) pinnedChainID(endpoint EndpointConfig) (string, bool) {
+	if c.config.ChainIDChange != ChainIDPin {
+		return "", false
+	}
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+	chainID, pinned := c.pinnedChainIDs[endpoint.URL]
+	return chainID, pinned
+}
+
+// evictClient closes the endpoint's client and drops it from the cache, if it is still the cached one,
+// so that the next fetch connects again.
+func (c *WalletBalanceCollector) evictClient(endpoint EndpointConfig, client *ethclient.Client) {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	if cached, exists := c.clientCache[endpoint.URL]; exists && cached == client {
+		delete(c.clientCache, endpoint.URL)
+		delete(c.clientCreated, endpoint.URL)
+		delete(c.chainIDs, endpoint.URL)
+		client.Close()
+	}
+}
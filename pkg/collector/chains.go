@@ -0,0 +1,95 @@
+package collector
+
+// multicall3Address is the address Multicall3 is deployed at on almost every EVM chain.
+const multicall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+// knownToken is a well-known ERC-20 token deployment.
+type knownToken struct {
+	address  string
+	decimals uint8
+}
+
+// knownChain holds the well-known contract addresses of a chain, so that common tokens can be
+// configured by symbol alone, and the coin ID of its native token at the price source.
+type knownChain struct {
+	multicall string
+	tokens    map[string]knownToken
+	priceID   string
+}
+
+// knownChains is the built-in registry of well-known contracts, keyed by chain ID.
+var knownChains = map[string]knownChain{
+	// Ethereum
+	"1": {
+		multicall: multicall3Address,
+		priceID:   "ethereum",
+		tokens: map[string]knownToken{
+			"USDC": {"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", 6},
+			"USDT": {"0xdAC17F958D2ee523a2206206994597C13D831ec7", 6},
+			"DAI":  {"0x6B175474E89094C44Da98b954EedeAC495271d0F", 18},
+		},
+	},
+	// OP Mainnet
+	"10": {
+		multicall: multicall3Address,
+		priceID:   "ethereum",
+		tokens: map[string]knownToken{
+			"USDC": {"0x0b2C639c533813f4Aa9D7837CAf62653d097Ff85", 6},
+			"USDT": {"0x94b008aA00579c1307B0EF2c499aD98a8ce58e58", 6},
+			"DAI":  {"0xDA10009cBd5D07dd0CeCc66161FC93D7c9000da1", 18},
+		},
+	},
+	// BNB Smart Chain, where the bridged stablecoins have 18 decimals
+	"56": {
+		multicall: multicall3Address,
+		priceID:   "binancecoin",
+		tokens: map[string]knownToken{
+			"USDC": {"0x8AC76a51cc950d9822D68b83fE1Ad97B32Cd580d", 18},
+			"USDT": {"0x55d398326f99059fF775485246999027B3197955", 18},
+			"DAI":  {"0x1AF3F329e8BE154074D8769D1FFa4eE058B1DBc3", 18},
+		},
+	},
+	// Polygon PoS
+	"137": {
+		multicall: multicall3Address,
+		priceID:   "polygon-ecosystem-token",
+		tokens: map[string]knownToken{
+			"USDC": {"0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359", 6},
+			"USDT": {"0xc2132D05D31c914a87C6611C10748AEb04B58e8F", 6},
+			"DAI":  {"0x8f3Cf7ad23Cd3CaDbD9735AFf958023239c6A063", 18},
+		},
+	},
+	// Base
+	"8453": {
+		multicall: multicall3Address,
+		priceID:   "ethereum",
+		tokens: map[string]knownToken{
+			"USDC": {"0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913", 6},
+			"DAI":  {"0x50c5725949A6F0c72E6C4a641F24049A917DB0Cb", 18},
+		},
+	},
+	// Arbitrum One
+	"42161": {
+		multicall: multicall3Address,
+		priceID:   "ethereum",
+		tokens: map[string]knownToken{
+			"USDC": {"0xaf88d065e77c8cC2239327C5EDb3A432268e5831", 6},
+			"USDT": {"0xFd086bC7CD5C481DCC9C85ebE478A1C0b69FCbb9", 6},
+			"DAI":  {"0xDA10009cBd5D07dd0CeCc66161FC93D7c9000da1", 18},
+		},
+	},
+	// Sepolia, which has no canonical stablecoins
+	"11155111": {
+		multicall: multicall3Address,
+	},
+}
+
+// isKnownToken reports whether the symbol is in the registry of any chain.
+func isKnownToken(symbol string) bool {
+	for _, chain := range knownChains {
+		if _, ok := chain.tokens[symbol]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// changeHeartbeat is how long an unchanged balance keeps the timestamp of its last change with
+// EmitOnChange. After that it is emitted with its fetch time once, so that the sample stays within the
+// time range Prometheus accepts and queries over this window always find a sample.
+const changeHeartbeat = 30 * time.Minute
+
+// emittedBalance is a balance as last emitted with EmitOnChange, and the timestamp it was emitted with.
+type emittedBalance struct {
+	value float64
+	at    time.Time
+}
+
+// withChangeTime timestamps a balance with the time it last changed, as seen by the collector, if
+// EmitOnChange is set, so that Prometheus stores no new sample while it stays the same: a sample with
+// the timestamp and value of the previous one is ignored. The series is the balance's key among the
+// wallet's balances, such as a token symbol, and value the balance emitted. Otherwise the balance is
+// timestamped as by withRestoredTime.
+func (c *WalletBalanceCollector) withChangeTime(metric prometheus.Metric, result fetchResult, series string, value float64) prometheus.Metric {
+	if !c.config.EmitOnChange || result.fetchedAt.IsZero() || result.restored {
+		return c.withRestoredTime(metric, result.fetchedAt, result.restored)
+	}
+
+	c.cacheMutex.Lock()
+	key := balanceKey(result.endpoint.URL, result.wallet.Address)
+	emitted, ok := c.emitted[key][series]
+	if !ok || emitted.value != value || result.fetchedAt.Sub(emitted.at) >= changeHeartbeat || result.fetchedAt.Before(emitted.at) {
+		emitted = emittedBalance{value: value, at: result.fetchedAt}
+		if c.emitted[key] == nil {
+			c.emitted[key] = make(map[string]emittedBalance)
+		}
+		c.emitted[key][series] = emitted
+	}
+	c.cacheMutex.Unlock()
+
+	return prometheus.NewMetricWithTimestamp(emitted.at, metric)
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestWithChangeTime feeds a wallet's balances fetched at increasing times through withChangeTime, and
+// checks the timestamp each one is emitted with.
+func TestWithChangeTime(t *testing.T) {
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	endpoint := EndpointConfig{Name: "stub", URL: "http://stub.invalid", Wallets: []WalletConfig{wallet}}
+	c := New([]EndpointConfig{endpoint}, CollectorConfig{EmitOnChange: true})
+	desc := prometheus.NewDesc("wallet_balance_eth", "Balance", nil, nil)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		after   time.Duration
+		series  string
+		value   float64
+		emitted time.Duration
+	}{
+		{"first fetch", 0, "", 1, 0},
+		{"unchanged", time.Minute, "", 1, 0},
+		{"changed", 2 * time.Minute, "", 2, 2 * time.Minute},
+		{"unchanged after the change", 3 * time.Minute, "", 2, 2 * time.Minute},
+		{"unchanged just before the heartbeat", 2*time.Minute + changeHeartbeat - time.Second, "", 2, 2 * time.Minute},
+		{"unchanged at the heartbeat", 2*time.Minute + changeHeartbeat, "", 2, 2*time.Minute + changeHeartbeat},
+		{"unchanged after the heartbeat", 3*time.Minute + changeHeartbeat, "", 2, 2*time.Minute + changeHeartbeat},
+		{"another series", 3*time.Minute + changeHeartbeat, "USDC", 5, 3*time.Minute + changeHeartbeat},
+		{"unchanged, before the last emission", time.Minute, "", 2, time.Minute},
+	}
+	for _, test := range tests {
+		result := fetchResult{endpoint: endpoint, wallet: wallet, balance: test.value, fetchedAt: start.Add(test.after)}
+		metric := c.withChangeTime(prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, test.value), result, test.series, test.value)
+
+		var written dto.Metric
+		if err := metric.Write(&written); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := written.GetTimestampMs(), start.Add(test.emitted).UnixMilli(); got != want {
+			t.Errorf("%s: emitted at %s, want %s", test.name, time.UnixMilli(got).UTC(), time.UnixMilli(want).UTC())
+		}
+	}
+}
+
+// TestWithChangeTimeDisabled checks that balances are not timestamped with their last change without
+// EmitOnChange, and that restored balances keep the time they were fetched.
+func TestWithChangeTimeDisabled(t *testing.T) {
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	endpoint := EndpointConfig{Name: "stub", URL: "http://stub.invalid", Wallets: []WalletConfig{wallet}}
+	desc := prometheus.NewDesc("wallet_balance_eth", "Balance", nil, nil)
+	fetchedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	timestamp := func(c *WalletBalanceCollector, result fetchResult) int64 {
+		var written dto.Metric
+		if err := c.withChangeTime(prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1), result, "", 1).Write(&written); err != nil {
+			t.Fatal(err)
+		}
+		return written.GetTimestampMs()
+	}
+
+	disabled := New([]EndpointConfig{endpoint}, CollectorConfig{})
+	for range 2 {
+		if got := timestamp(disabled, fetchResult{endpoint: endpoint, wallet: wallet, balance: 1, fetchedAt: fetchedAt}); got != 0 {
+			t.Errorf("without EmitOnChange emitted at %s, want no timestamp", time.UnixMilli(got).UTC())
+		}
+	}
+
+	enabled := New([]EndpointConfig{endpoint}, CollectorConfig{EmitOnChange: true})
+	restored := fetchResult{endpoint: endpoint, wallet: wallet, balance: 1, fetchedAt: fetchedAt, restored: true}
+	if got := timestamp(enabled, restored); got != fetchedAt.UnixMilli() {
+		t.Errorf("restored balance emitted at %s, want %s", time.UnixMilli(got).UTC(), fetchedAt)
+	}
+	enabled.cacheMutex.Lock()
+	defer enabled.cacheMutex.Unlock()
+	if len(enabled.emitted) != 0 {
+		t.Errorf("restored balance recorded as emitted: %v", enabled.emitted)
+	}
+}
@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultCircuitCooldown is how long an open circuit breaker stops queries to its endpoint when
+// CIRCUIT_BREAKER_COOLDOWN is not set.
+const DefaultCircuitCooldown = time.Minute
+
+// errCircuitOpen is wrapped around the errors of the wallets of an endpoint that is not queried because
+// its circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// States of a circuit breaker, exported as the value of rpc_circuit_state.
+const (
+	circuitClosed   = 0
+	circuitHalfOpen = 1
+	circuitOpen     = 2
+)
+
+// circuit is the circuit breaker of an endpoint.
+type circuit struct {
+	// failures is the number of consecutive collections in which no wallet of the endpoint was fetched.
+	failures int
+	// openedAt is when the circuit last opened, or zero while it is closed.
+	openedAt time.Time
+	// probing is set from the probe after the cooldown until the outcome of its collection is recorded.
+	probing bool
+}
+
+// ParseCircuitFailures parses the number of consecutive failed collections that opens an endpoint's
+// circuit breaker. An empty value returns zero, which disables circuit breakers.
+func ParseCircuitFailures(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	failures, err := strconv.Atoi(value)
+	if err != nil || failures < 1 {
+		return 0, fmt.Errorf("invalid number of failures: %s (must be an integer of at least 1)", value)
+	}
+	return failures, nil
+}
+
+// admitCircuit reports whether the endpoint may be queried: always while its circuit breaker is closed,
+// and once the cooldown of an open breaker has passed only by a single fetch, which must probe the
+// endpoint first. Otherwise it returns an error wrapping errCircuitOpen.
+func (c *WalletBalanceCollector) admitCircuit(endpoint EndpointConfig) (probe bool, err error) {
+	if c.config.CircuitFailures == 0 {
+		return false, nil
+	}
+
+	c.circuitMutex.Lock()
+	defer c.circuitMutex.Unlock()
+	breaker := c.circuits[endpoint.URL]
+	if breaker == nil || breaker.openedAt.IsZero() {
+		return false, nil
+	}
+	if remaining := c.config.CircuitCooldown - time.Since(breaker.openedAt); remaining > 0 || breaker.probing {
+		return false, fmt.Errorf("%w after %d consecutive failed collections, probing again in %s", errCircuitOpen, breaker.failures, max(remaining, 0).Round(time.Second))
+	}
+	breaker.probing = true
+	return true, nil
+}
+
+// probeCircuit probes an endpoint whose circuit breaker is half-open with eth_blockNumber, unless
+// connecting to it already failed with err, and opens the breaker for another cooldown if it does not
+// answer. Otherwise the breaker stays half-open until the outcome of the collection is recorded. It
+// returns the error of the probe.
+func (c *WalletBalanceCollector) probeCircuit(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, err error) error {
+	if err == nil {
+		if _, err = client.BlockNumber(ctx); err != nil {
+			c.countRPCError(endpoint, err)
+			err = wrapRPCError(err)
+		}
+	}
+	if err != nil {
+		log.Printf("Circuit breaker probe of provider %s failed, not querying it for another %s: %v", endpoint.Name, c.config.CircuitCooldown, err)
+		c.circuitMutex.Lock()
+		if breaker := c.circuits[endpoint.URL]; breaker != nil {
+			breaker.openedAt, breaker.probing = time.Now(), false
+		}
+		c.circuitMutex.Unlock()
+	}
+	return err
+}
+
+// recordCircuits records the outcome of a collection for the circuit breaker of each endpoint that was
+// queried: a failure if none of its wallets was fetched over RPC. A success closes the breaker, while
+// CircuitFailures consecutive failures, or a failure right after a successful probe, open it for
+// CircuitCooldown. A half-open breaker whose endpoint was not queried is probed again next time.
+func (c *WalletBalanceCollector) recordCircuits(endpoints []EndpointConfig, results []fetchResult) {
+	if c.config.CircuitFailures == 0 {
+		return
+	}
+
+	queried := make(map[string]bool)
+	fetched := make(map[string]bool)
+	for _, result := range results {
+		if errors.Is(result.err, errCircuitOpen) {
+			continue
+		}
+		queried[result.endpoint.URL] = true
+		if result.err == nil && result.source == sourceRPC {
+			fetched[result.endpoint.URL] = true
+		}
+	}
+
+	c.circuitMutex.Lock()
+	defer c.circuitMutex.Unlock()
+	if c.circuits == nil {
+		c.circuits = make(map[string]*circuit)
+	}
+	for _, endpoint := range endpoints {
+		breaker := c.circuits[endpoint.URL]
+		if breaker == nil {
+			breaker = &circuit{}
+			c.circuits[endpoint.URL] = breaker
+		}
+		probing := breaker.probing
+		breaker.probing = false
+
+		switch {
+		case !queried[endpoint.URL]:
+		case fetched[endpoint.URL]:
+			if !breaker.openedAt.IsZero() {
+				log.Printf("Circuit breaker of provider %s closed", endpoint.Name)
+			}
+			*breaker = circuit{}
+		case probing:
+			breaker.failures++
+			breaker.openedAt = time.Now()
+			log.Printf("Circuit breaker of provider %s opened again after a failed collection, not querying it for %s", endpoint.Name, c.config.CircuitCooldown)
+		default:
+			breaker.failures++
+			if breaker.openedAt.IsZero() && breaker.failures >= c.config.CircuitFailures {
+				breaker.openedAt = time.Now()
+				log.Printf("Circuit breaker of provider %s opened after %d consecutive failed collections, not querying it for %s", endpoint.Name, breaker.failures, c.config.CircuitCooldown)
+			}
+		}
+	}
+}
+
+// collectCircuits emits rpc_circuit_state for every endpoint, if circuit breakers are enabled.
+func (c *WalletBalanceCollector) collectCircuits(ch chan<- prometheus.Metric, endpoints []EndpointConfig) {
+	if c.config.CircuitFailures == 0 {
+		return
+	}
+
+	c.circuitMutex.Lock()
+	defer c.circuitMutex.Unlock()
+	for _, endpoint := range endpoints {
+		state := circuitClosed
+		if breaker := c.circuits[endpoint.URL]; breaker != nil && !breaker.openedAt.IsZero() {
+			state = circuitOpen
+			if breaker.probing || time.Since(breaker.openedAt) >= c.config.CircuitCooldown {
+				state = circuitHalfOpen
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.circuitState, prometheus.GaugeValue, float64(state), c.endpointLabelValues(endpoint, endpoint.Name)...)
+	}
+}
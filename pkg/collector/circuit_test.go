@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestCircuitBreaker drives an endpoint through CircuitFailures failed collections, checks that it is
+// not queried during the cooldown, that a failed probe opens the breaker again, and that the breaker
+// closes once the endpoint recovers.
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 200 * time.Millisecond
+	var (
+		failing atomic.Bool
+		mutex   sync.Mutex
+		calls   = make(map[string]int)
+	)
+	server := newStubRPCFunc(t, func(method string, params []json.RawMessage) (string, error) {
+		mutex.Lock()
+		calls[method]++
+		mutex.Unlock()
+		if failing.Load() && method != "eth_chainId" {
+			return "", errors.New("upstream unavailable")
+		}
+		switch method {
+		case "eth_chainId":
+			return `"0x1"`, nil
+		case "eth_blockNumber":
+			return `"0x10"`, nil
+		}
+		return balanceAnswer(method, params)
+	})
+
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	endpoint := EndpointConfig{Name: "stub", URL: server.URL, Wallets: []WalletConfig{wallet}}
+	registry := prometheus.NewPedanticRegistry()
+	if _, err := Register(registry, []EndpointConfig{endpoint}, CollectorConfig{CircuitFailures: 2, CircuitCooldown: cooldown}); err != nil {
+		t.Fatal(err)
+	}
+
+	// collect gathers the metrics and returns the breaker's state and the calls the collection made
+	collect := func() (float64, map[string]int) {
+		t.Helper()
+		mutex.Lock()
+		clear(calls)
+		mutex.Unlock()
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := -1.0
+		for _, family := range families {
+			if family.GetName() == "rpc_circuit_state" {
+				state = family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		made := make(map[string]int)
+		for method, count := range calls {
+			made[method] = count
+		}
+		return state, made
+	}
+
+	failing.Store(true)
+	if state, _ := collect(); state != circuitClosed {
+		t.Fatalf("state after 1 failed collection = %v, want closed", state)
+	}
+	if state, _ := collect(); state != circuitOpen {
+		t.Fatalf("state after 2 failed collections = %v, want open", state)
+	}
+	if state, made := collect(); state != circuitOpen || len(made) != 0 {
+		t.Fatalf("collection during the cooldown: state %v, calls %v, want open and no calls", state, made)
+	}
+
+	// The probe after the cooldown fails, so the balance is not queried and the breaker opens again
+	time.Sleep(cooldown)
+	if state, made := collect(); state != circuitOpen || made["eth_blockNumber"] != 1 || made["eth_getBalance"] != 0 {
+		t.Fatalf("collection with a failed probe: state %v, calls %v, want open after a single probe", state, made)
+	}
+	if state, made := collect(); state != circuitOpen || len(made) != 0 {
+		t.Fatalf("collection after the failed probe: state %v, calls %v, want open and no calls", state, made)
+	}
+
+	failing.Store(false)
+	time.Sleep(cooldown)
+	if state, made := collect(); state != circuitClosed || made["eth_blockNumber"] != 1 || made["eth_getBalance"] == 0 {
+		t.Fatalf("collection after recovery: state %v, calls %v, want closed after a probe and a balance query", state, made)
+	}
+	if state, made := collect(); state != circuitClosed || made["eth_blockNumber"] != 0 || made["eth_getBalance"] == 0 {
+		t.Fatalf("collection once closed: state %v, calls %v, want the balance queried without a probe", state, made)
+	}
+}
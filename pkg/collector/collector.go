@@ -0,0 +1,1826 @@
+// Package collector implements a Prometheus collector exporting the native token balances of wallets
+// queried from Ethereum JSON-RPC endpoints, together with the parsing of its settings.
+package collector
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/health"
+)
+
+// StaleBehavior controls what is exported for a wallet whose balance could not be fetched.
+type StaleBehavior string
+
+const (
+	// StaleDrop omits the series until the next successful fetch.
+	StaleDrop StaleBehavior = "drop"
+	// StaleHold keeps exporting the last successfully fetched balance.
+	StaleHold StaleBehavior = "hold"
+	// StaleNaN exports NaN so the series stays present but carries no value.
+	StaleNaN StaleBehavior = "nan"
+)
+
+// ParseStaleBehavior parses the STALE_BEHAVIOR environment variable. An empty value selects StaleDrop.
+func ParseStaleBehavior(value string) (StaleBehavior, error) {
+	switch behavior := StaleBehavior(strings.ToLower(strings.TrimSpace(value))); behavior {
+	case "":
+		return StaleDrop, nil
+	case StaleDrop, StaleHold, StaleNaN:
+		return behavior, nil
+	default:
+		return "", fmt.Errorf("invalid STALE_BEHAVIOR: %s (must be one of drop, hold, nan)", value)
+	}
+}
+
+// ParseRoundingMode parses a big.RoundingMode by name (e.g. ToNearestEven), ignoring case.
+// An empty value selects big.ToNearestEven, the mode big.Float uses by default.
+func ParseRoundingMode(value string) (big.RoundingMode, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return big.ToNearestEven, nil
+	}
+
+	for mode := big.ToNearestEven; mode <= big.ToPositiveInf; mode++ {
+		if strings.EqualFold(mode.String(), value) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid rounding mode: %s (must be one of ToNearestEven, ToNearestAway, ToZero, AwayFromZero, ToNegativeInf, ToPositiveInf)", value)
+}
+
+// ParsePrecision parses a big.Float mantissa precision in bits. An empty value or 0 keeps the default precision.
+func ParsePrecision(value string) (uint, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	precision, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || precision > big.MaxPrec {
+		return 0, fmt.Errorf("invalid precision: %s (must be an integer between 0 and %d)", value, uint32(big.MaxPrec))
+	}
+	return uint(precision), nil
+}
+
+// maxDisplayDecimals is the number of decimal places of one Wei in ETH, beyond which rounding has no effect.
+const maxDisplayDecimals = 18
+
+// ParseDisplayDecimals parses the number of decimal places balances are rounded to in the /balances
+// output. An empty value returns zero, which leaves them unrounded.
+func ParseDisplayDecimals(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	decimals, err := strconv.Atoi(value)
+	if err != nil || decimals < 1 || decimals > maxDisplayDecimals {
+		return 0, fmt.Errorf("invalid decimal places: %s (must be an integer between 1 and %d)", value, maxDisplayDecimals)
+	}
+	return decimals, nil
+}
+
+// DefaultCollectTimeout is the collection timeout used when COLLECT_TIMEOUT is not set.
+const DefaultCollectTimeout = 30 * time.Second
+
+// DefaultShutdownTimeout is the grace period for requests in progress at shutdown used when
+// SHUTDOWN_TIMEOUT is not set.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// ParseBool parses a boolean setting such as true or false. An empty value is false.
+func ParseBool(value string) (bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean: %s (must be true or false)", value)
+	}
+	return enabled, nil
+}
+
+// DefaultChainIDTTL is the chain ID cache TTL used when CHAIN_ID_TTL is not set.
+const DefaultChainIDTTL = time.Hour
+
+// ParseChainIDTTL parses the CHAIN_ID_TTL environment variable, returning DefaultChainIDTTL for an
+// empty value. Unlike other durations it may be 0, which caches chain IDs until the client is re-dialed.
+func ParseChainIDTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return DefaultChainIDTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid CHAIN_ID_TTL: %s (must be a duration of at least 0, e.g. 10m)", value)
+	}
+	return ttl, nil
+}
+
+// ParseDuration parses a positive duration such as 30s, returning fallback for an empty value.
+func ParseDuration(value string, fallback time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s (must be positive, e.g. 30s)", value)
+	}
+	return duration, nil
+}
+
+// ParseProxy returns the proxy selection for RPC connections. An explicit RPC_PROXY URL is used for
+// every connection. Otherwise HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored, and ALL_PROXY is
+// used for connections that they do not route through a proxy.
+func ParseProxy(rpcProxy string) (func(*http.Request) (*url.URL, error), error) {
+	parseProxyURL := func(value string) (*url.URL, error) {
+		proxyURL, err := url.Parse(value)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: %s (expected e.g. http://proxy:3128 or socks5://proxy:1080)", value)
+		}
+		return proxyURL, nil
+	}
+
+	if rpcProxy = strings.TrimSpace(rpcProxy); rpcProxy != "" {
+		proxyURL, err := parseProxyURL(rpcProxy)
+		if err != nil {
+			return nil, err
+		}
+		return http.ProxyURL(proxyURL), nil
+	}
+
+	allProxy := os.Getenv("ALL_PROXY")
+	if allProxy == "" {
+		allProxy = os.Getenv("all_proxy")
+	}
+	if allProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	allProxyURL, err := parseProxyURL(allProxy)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := http.ProxyFromEnvironment(req)
+		if proxyURL != nil || err != nil {
+			return proxyURL, err
+		}
+		return allProxyURL, nil
+	}, nil
+}
+
+// ParseBuckets parses comma-separated histogram bucket upper bounds in seconds. An empty value selects
+// prometheus.DefBuckets.
+func ParseBuckets(value string) ([]float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return prometheus.DefBuckets, nil
+	}
+
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || bucket <= 0 {
+			return nil, fmt.Errorf("invalid bucket: %s (must be a positive number of seconds)", field)
+		}
+		if len(buckets) > 0 && bucket <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("invalid buckets: %s (must be in increasing order)", value)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// CollectorConfig holds the tunable behavior of a WalletBalanceCollector.
+type CollectorConfig struct {
+	// StaleBehavior selects what is exported when a balance fetch fails.
+	StaleBehavior StaleBehavior
+	// DuplicateWallets selects how wallets listed under several endpoints of the same chain are
+	// exported. Empty selects DuplicateDrop.
+	DuplicateWallets DuplicateWallets
+	// AddressCase selects how wallet addresses are rendered in the wallet label. Empty selects
+	// AddressChecksum.
+	AddressCase AddressCase
+	// Precision is the big.Float mantissa precision in bits used for the Wei to ETH conversion.
+	// Zero keeps the default, which is wide enough to hold the Wei balance exactly.
+	Precision uint
+	// RoundingMode is the big.RoundingMode used for the Wei to ETH conversion.
+	RoundingMode big.RoundingMode
+	// CollectTimeout bounds how long a single collection may take. Zero selects DefaultCollectTimeout.
+	CollectTimeout time.Duration
+	// RPCTimeout bounds each HTTP JSON-RPC request and each WebSocket handshake. Zero selects CollectTimeout.
+	RPCTimeout time.Duration
+	// LockTimeout bounds how long a collection waits for a fetch already in progress, such as a slow
+	// concurrent collection, to finish. Zero selects CollectTimeout.
+	LockTimeout time.Duration
+	// Proxy selects the proxy for HTTP and WebSocket RPC connections. Nil selects http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+	// TotalAcrossChains enables wallet_balance_total_across_chains_eth.
+	TotalAcrossChains bool
+	// WalletFetchDuration enables wallet_balance_fetch_duration_seconds.
+	WalletFetchDuration bool
+	// CrossCheckTolerance is the largest difference in ETH between the balances of a wallet reported by
+	// cross-check peers that still counts as agreement.
+	CrossCheckTolerance float64
+	// UnifiedBalanceMetric exports native and token balances as wallet_balance with an asset label,
+	// instead of wallet_balance_eth and wallet_token_balance.
+	UnifiedBalanceMetric bool
+	// ChainIDTTL is how long a chain ID reported by an endpoint is cached before it is queried again.
+	// Zero caches it until the client is re-dialed.
+	ChainIDTTL time.Duration
+	// ChainIDChange selects how an endpoint whose chain ID changes is handled. Empty selects
+	// ChainIDFollow.
+	ChainIDChange ChainIDChange
+	// ErrorReasons map error messages to reasons of rpc_endpoint_status and balance_fetch_errors_total,
+	// matched in order before the defaults.
+	ErrorReasons []ErrorReason
+	// DurationBuckets are the rpc_request_duration_seconds histogram buckets. Nil selects prometheus.DefBuckets.
+	DurationBuckets []float64
+	// RefreshInterval enables fetching balances in the background at this interval instead of on every
+	// collection. Zero disables background refreshes.
+	RefreshInterval time.Duration
+	// Timestamps selects which balances carry their fetch time as sample timestamp. Empty selects
+	// TimestampRefresh.
+	Timestamps TimestampMode
+	// MaxBalanceAge stops exporting balances, such as held or background-refreshed ones, fetched longer
+	// ago than this. Zero exports balances regardless of their age.
+	MaxBalanceAge time.Duration
+	// LogSampleWindow collapses repeated errors, such as those of every wallet of a provider that is
+	// down, into one log message per window and a count of those suppressed. Zero logs every error.
+	LogSampleWindow time.Duration
+	// RefreshJitter is the maximum random delay added to each refresh interval.
+	RefreshJitter time.Duration
+	// LabelTemplates are additional labels of the wallet balance, snapshot and contract metrics.
+	LabelTemplates []LabelTemplate
+	// GraphiteAddress is the host:port of a Graphite plaintext receiver that the balances are sent to
+	// after each refresh. Empty disables Graphite output.
+	GraphiteAddress string
+	// GraphitePrefix is the first component of Graphite metric paths. Empty selects DefaultGraphitePrefix.
+	GraphitePrefix string
+	// MaxConcurrency limits the wallet fetches in flight across all endpoints, in addition to each
+	// endpoint's own limit. Zero removes the global limit.
+	MaxConcurrency int
+	// HideZeroBalances skips wallet_balance_eth for wallets whose balance is exactly zero.
+	HideZeroBalances bool
+	// EmitOnChange timestamps wallet and token balances with the time they last changed, so that
+	// Prometheus stores no new samples for unchanged balances.
+	EmitOnChange bool
+	// GweiMetric additionally exports wallet balances in gwei in wallet_balance_gwei.
+	GweiMetric bool
+	// RetryAttempts is the maximum number of attempts of a balance query, including the first. Zero or
+	// one disables retries.
+	RetryAttempts int
+	// RetryBaseDelay is the delay before the first retry, doubled for each further retry. Zero selects
+	// DefaultRetryBaseDelay.
+	RetryBaseDelay time.Duration
+	// CircuitFailures is the number of consecutive collections in which no wallet of an endpoint is
+	// fetched that opens its circuit breaker. Zero disables circuit breakers.
+	CircuitFailures int
+	// CircuitCooldown is how long an open circuit breaker stops queries to its endpoint before probing
+	// it. Zero selects DefaultCircuitCooldown.
+	CircuitCooldown time.Duration
+	// StartupStagger spreads the first contact with each endpoint after startup randomly over this
+	// window. Zero contacts every endpoint at once.
+	StartupStagger time.Duration
+	// StartupAttempts is the maximum number of startup connectivity checks of an unreachable endpoint,
+	// including the first. Zero or one checks once.
+	StartupAttempts int
+	// StartupRetryDelay is the delay before the second startup connectivity check, doubled for each
+	// further one. Zero selects DefaultStartupRetryDelay.
+	StartupRetryDelay time.Duration
+	// DNSCacheTTL is how long the resolved addresses of RPC hosts are cached. Zero disables the
+	// cache, resolving the host for every new connection.
+	DNSCacheTTL time.Duration
+	// NamingServiceURL is the URL of a service that resolves names for wallets without a configured
+	// name. It adds a name label to the wallet metrics. Empty disables the naming service.
+	NamingServiceURL string
+	// FiatCurrencies are the currencies that balances are converted to in wallet_balance_fiat. Empty
+	// disables fiat conversion.
+	FiatCurrencies []string
+	// PriceURL is the URL of the price source used with FiatCurrencies. Empty selects DefaultPriceURL.
+	PriceURL string
+	// EtherscanURL is the URL of an Etherscan-compatible API that balances are fetched from when they
+	// cannot be fetched over RPC. It adds a source label to the native balance metrics. Empty disables
+	// the fallback.
+	EtherscanURL string
+	// EtherscanAPIKey is the API key sent to EtherscanURL. Empty sends none.
+	EtherscanAPIKey string
+	// Vault is the source of the secrets injected into the RPC URLs of reloaded configurations. Nil
+	// leaves placeholders in RPC URLs as they are.
+	Vault *VaultSource
+	// EmptyAccountErrors are the eth_getBalance error messages that mean the account does not exist
+	// yet, so that its balance is 0 rather than a failure. Nil selects DefaultEmptyAccountErrors and an
+	// empty list treats every error as a failure.
+	EmptyAccountErrors []string
+	// Relabel are the rules, applied in order, that decide which series are exported. Nil exports
+	// every series.
+	Relabel []RelabelRule
+	// DisplayDecimals is the number of decimal places balances are rounded to in the /balances output.
+	// The exported metrics are never rounded. Zero leaves the balances unrounded.
+	DisplayDecimals int
+}
+
+// WalletBalanceCollector collects and exposes balance metrics for multiple wallets across RPC URLs.
+type WalletBalanceCollector struct {
+	// endpoints is replaced, never modified in place, when endpoints or wallets are added or removed. It
+	// is guarded by endpointsMutex, as are the endpoints and wallets added through the admin API and the
+	// file they are saved to.
+	endpoints      []EndpointConfig
+	addedEndpoints []AdminEndpoint
+	added          []AdminWallet
+	walletsFile    string
+	endpointsMutex sync.Mutex
+
+	clientCache     map[string]*ethclient.Client
+	clientCreated   map[string]time.Time
+	chainIDs        map[string]cachedChainID
+	config          CollectorConfig
+	balanceMetric   *prometheus.Desc
+	snapshotMetric  *prometheus.Desc
+	contractMetric  *prometheus.Desc
+	tokenMetric     *prometheus.Desc
+	thresholdMetric *prometheus.Desc
+	nftMetric       *prometheus.Desc
+	groupMetric     *prometheus.Desc
+	groupFailures   *prometheus.Desc
+	totalMetric     *prometheus.Desc
+	fiatMetric      *prometheus.Desc
+	ratioMetric     *prometheus.Desc
+	gweiMetric      *prometheus.Desc
+	priceUpdated    *prometheus.Desc
+	statusMetric    *prometheus.Desc
+	upMetric        *prometheus.Desc
+	downMetric      *prometheus.Desc
+	refreshMetric   *prometheus.Desc
+	timeoutMetric   *prometheus.Desc
+	clientsMetric   *prometheus.Desc
+	clientAge       *prometheus.Desc
+	circuitState    *prometheus.Desc
+	blockMetric     *prometheus.Desc
+	rpcCalls        *prometheus.CounterVec
+	rpcDuration     *prometheus.HistogramVec
+	walletDuration  *prometheus.HistogramVec
+	rpcRetries      *prometheus.CounterVec
+	fetchErrors     *prometheus.CounterVec
+	rpcErrorCodes   *prometheus.CounterVec
+	ensChanges      *prometheus.CounterVec
+	mismatches      *prometheus.CounterVec
+	peerSpread      *prometheus.Desc
+	resolvedMetric  *prometheus.Desc
+	precisionLoss   *prometheus.CounterVec
+	collectTimeouts prometheus.Counter
+	timedOutWallets prometheus.Counter
+	lockTimeouts    prometheus.Counter
+	lastReload      prometheus.Gauge
+	ready           prometheus.Gauge
+	// fetchLock serializes fetches, whether made by collections or background refreshes. It holds a
+	// value while a fetch is in progress, so that collections can give up waiting for it.
+	fetchLock chan struct{}
+
+	// clientMutex guards clientCache, clientCreated, chainIDs, pinnedChainIDs, restoredChainIDs and
+	// semaphores, which are shared by concurrent fetches.
+	clientMutex sync.Mutex
+	// pinnedChainIDs holds the chain ID each endpoint is pinned to with ChainIDPin, keyed by URL. Unlike
+	// chainIDs, it is kept when the client is re-dialed.
+	pinnedChainIDs map[string]string
+	// restoredChainIDs holds the chain ID of each endpoint persisted in the balance cache file, keyed by
+	// URL. It labels the endpoint until its chain ID is queried.
+	restoredChainIDs map[string]string
+
+	// logs samples the errors logged for every wallet or scrape.
+	logs *logSampler
+
+	// endpointLabels are the names of the static labels of all endpoints.
+	endpointLabels []string
+	// tagNames are the names of the tags of all wallets.
+	tagNames []string
+	// verifyProofs is set if any endpoint verifies balance proofs, which adds the verified label to the
+	// balance metrics.
+	verifyProofs bool
+
+	// startAt holds the time from which each endpoint, keyed by RPC URL, may be contacted after startup
+	// or after it was added.
+	startAt    map[string]time.Time
+	startMutex sync.Mutex
+
+	// semaphores limit the concurrent wallet fetches per RPC URL, and concurrency across all endpoints
+	// if a global limit is configured.
+	semaphores  map[string]chan struct{}
+	concurrency chan struct{}
+
+	// transport is shared by all HTTP RPC connections. It dials through dnsCache, if configured, as
+	// do WebSocket connections.
+	transport *http.Transport
+	dnsCache  *dnsCache
+
+	// names resolves wallet names from the naming service, if configured.
+	names *namingService
+
+	// health reports the collector's health over gRPC, if served.
+	health *health.Server
+
+	// ens holds the resolved addresses of wallets configured by ENS name.
+	ens *ensResolver
+
+	// prices caches the prices of native tokens for wallet_balance_fiat, if fiat currencies are configured.
+	prices *priceSource
+
+	// etherscan is the fallback source of balances that cannot be fetched over RPC, if configured.
+	etherscan *etherscanSource
+
+	// lastBalances holds the last successful fetch result per wallet. It is guarded by its own
+	// mutex so that it can be read while a collection is in progress.
+	lastBalances map[string]fetchResult
+	cacheMutex   sync.Mutex
+
+	// balanceCachePath is the file the last-known balances are persisted to, if any. It is not
+	// modified after LoadBalanceCache.
+	balanceCachePath string
+
+	// lastErrors holds the error of each wallet whose latest fetch failed. It is guarded by cacheMutex.
+	lastErrors map[string]fetchError
+
+	// contracts records whether each wallet is a contract, once known. It is guarded by cacheMutex.
+	contracts map[string]bool
+
+	// emitted holds the balances last emitted with EmitOnChange per wallet, keyed by balanceKey and by
+	// the balance's series. It is guarded by cacheMutex.
+	emitted map[string]map[string]emittedBalance
+
+	// confirmedBlocks holds the block each endpoint with confirmations was last read at, keyed by
+	// endpoint name. It is guarded by cacheMutex.
+	confirmedBlocks map[string]uint64
+
+	// refreshed holds the results of the latest background refresh. It is guarded by cacheMutex.
+	refreshed []fetchResult
+
+	// refreshDue holds when each wallet, keyed by balanceKey, is next due to be fetched by a background
+	// refresh. It is guarded by cacheMutex.
+	refreshDue map[string]time.Time
+
+	// circuits holds the circuit breaker of each endpoint, keyed by URL, once it has been queried. It is
+	// guarded by its own mutex.
+	circuits     map[string]*circuit
+	circuitMutex sync.Mutex
+
+	// snapshots holds the latest scheduled snapshot per wallet. It is guarded by its own mutex
+	// so that slow snapshot block searches do not block scrapes.
+	snapshots     map[string]snapshot
+	snapshotMutex sync.Mutex
+
+	// graphiteMutex serializes the sends to Graphite, which run in the background.
+	graphiteMutex sync.Mutex
+}
+
+// New creates a new WalletBalanceCollector.
+func New(endpoints []EndpointConfig, config CollectorConfig) *WalletBalanceCollector {
+	if config.Proxy == nil {
+		config.Proxy = http.ProxyFromEnvironment
+	}
+	if config.DurationBuckets == nil {
+		config.DurationBuckets = prometheus.DefBuckets
+	}
+	if config.GraphitePrefix == "" {
+		config.GraphitePrefix = DefaultGraphitePrefix
+	}
+	if config.CollectTimeout == 0 {
+		config.CollectTimeout = DefaultCollectTimeout
+	}
+	if config.RPCTimeout == 0 {
+		config.RPCTimeout = config.CollectTimeout
+	}
+	if config.LockTimeout == 0 {
+		config.LockTimeout = config.CollectTimeout
+	}
+	if config.RetryBaseDelay == 0 {
+		config.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+	if config.CircuitCooldown == 0 {
+		config.CircuitCooldown = DefaultCircuitCooldown
+	}
+	if config.StartupRetryDelay == 0 {
+		config.StartupRetryDelay = DefaultStartupRetryDelay
+	}
+	if config.Timestamps == "" {
+		config.Timestamps = TimestampRefresh
+	}
+	if config.EmptyAccountErrors == nil {
+		config.EmptyAccountErrors = DefaultEmptyAccountErrors
+	}
+	// Wallet metrics carry the tags of every wallet
+	tagNames := walletTagNames(endpoints)
+	config.LabelTemplates = withTagLabels(config.LabelTemplates, tagNames)
+	if config.NamingServiceURL != "" {
+		config.LabelTemplates = withNameLabel(config.LabelTemplates)
+	}
+	if config.DuplicateWallets == "" {
+		config.DuplicateWallets = DuplicateDrop
+	}
+	if config.AddressCase == "" {
+		config.AddressCase = AddressChecksum
+	}
+	if config.DuplicateWallets == DuplicateProvider {
+		config.LabelTemplates = withProviderLabel(config.LabelTemplates)
+	}
+	if config.PriceURL == "" {
+		config.PriceURL = DefaultPriceURL
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = config.Proxy
+	var cache *dnsCache
+	if config.DNSCacheTTL > 0 {
+		cache = newDNSCache(config.DNSCacheTTL)
+		transport.DialContext = cache.DialContext
+	}
+
+	// Endpoint metrics carry the static labels of every endpoint
+	endpointLabels := endpointLabelNames(endpoints)
+
+	c := &WalletBalanceCollector{
+		transport:       transport,
+		dnsCache:        cache,
+		endpoints:       endpoints,
+		clientCache:     make(map[string]*ethclient.Client),
+		clientCreated:   make(map[string]time.Time),
+		chainIDs:        make(map[string]cachedChainID),
+		pinnedChainIDs:  make(map[string]string),
+		semaphores:      make(map[string]chan struct{}),
+		lastBalances:    make(map[string]fetchResult),
+		lastErrors:      make(map[string]fetchError),
+		contracts:       make(map[string]bool),
+		emitted:         make(map[string]map[string]emittedBalance),
+		confirmedBlocks: make(map[string]uint64),
+		snapshots:       make(map[string]snapshot),
+		ens:             &ensResolver{addresses: make(map[string]common.Address)},
+		health:          newHealthServer(),
+		logs:            newLogSampler(config.LogSampleWindow),
+		config:          config,
+		balanceMetric: prometheus.NewDesc(
+			"wallet_balance_eth",
+			"Balance of the specified wallet in ETH",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "derivation_index"),
+			nil,
+		),
+		snapshotMetric: prometheus.NewDesc(
+			"wallet_balance_snapshot_eth",
+			"Balance of the specified wallet in ETH at the block closest to the latest scheduled snapshot",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "date"),
+			nil,
+		),
+		totalMetric: prometheus.NewDesc(
+			"wallet_balance_total_across_chains_eth",
+			"Sum of the specified wallet's balances in the native token across all endpoints it is monitored on",
+			[]string{"wallet"},
+			nil,
+		),
+		fiatMetric: prometheus.NewDesc(
+			"wallet_balance_fiat",
+			"Balance of the specified wallet in the native token converted to the currency at the last fetched price",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "derivation_index", "currency"),
+			nil,
+		),
+		ratioMetric: prometheus.NewDesc(
+			"wallet_balance_ratio",
+			"Balance of the specified wallet as a fraction of its configured target balance",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "derivation_index"),
+			nil,
+		),
+		gweiMetric: prometheus.NewDesc(
+			"wallet_balance_gwei",
+			"Balance of the specified wallet in gwei, a billionth of the native token",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "derivation_index"),
+			nil,
+		),
+		priceUpdated: prometheus.NewDesc(
+			"price_last_update_timestamp_seconds",
+			"Time the prices used for wallet_balance_fiat were last fetched, in seconds since the epoch",
+			nil,
+			nil,
+		),
+		statusMetric: prometheus.NewDesc(
+			"rpc_endpoint_status",
+			"Whether the provider answered the last collection (1) or is down (0), with the reason of the failure",
+			append([]string{"provider", "reason"}, endpointLabels...),
+			nil,
+		),
+		upMetric: prometheus.NewDesc(
+			"rpc_endpoints_up",
+			"Number of providers that answered the last collection",
+			nil,
+			nil,
+		),
+		downMetric: prometheus.NewDesc(
+			"rpc_endpoints_down",
+			"Number of providers that failed every request of the last collection",
+			nil,
+			nil,
+		),
+		refreshMetric: prometheus.NewDesc(
+			"config_refresh_interval_seconds",
+			"Configured background refresh interval, or 0 if balances are fetched on every scrape",
+			nil,
+			nil,
+		),
+		timeoutMetric: prometheus.NewDesc(
+			"config_rpc_timeout_seconds",
+			"Configured timeout of a single JSON-RPC request",
+			nil,
+			nil,
+		),
+		clientsMetric: prometheus.NewDesc(
+			"rpc_clients_cached",
+			"Number of RPC clients held in the client cache",
+			nil,
+			nil,
+		),
+		blockMetric: prometheus.NewDesc(
+			"balance_block_number",
+			"Block number that the provider's balances were last read at, for providers with confirmations",
+			append([]string{"provider"}, endpointLabels...),
+			nil,
+		),
+		clientAge: prometheus.NewDesc(
+			"rpc_client_age_seconds",
+			"Time since the cached RPC client of the provider was created",
+			append([]string{"provider"}, endpointLabels...),
+			nil,
+		),
+		circuitState: prometheus.NewDesc(
+			"rpc_circuit_state",
+			"State of the provider's circuit breaker: 0 closed, 1 half-open, 2 open",
+			append([]string{"provider"}, endpointLabels...),
+			nil,
+		),
+		tokenMetric: prometheus.NewDesc(
+			"wallet_token_balance",
+			"Balance of the specified ERC-20 token held by the wallet, in whole tokens",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "token"),
+			nil,
+		),
+		thresholdMetric: prometheus.NewDesc(
+			"wallet_token_below_threshold",
+			"Whether the wallet's balance of the specified ERC-20 token is below its configured threshold (1) or not (0)",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "symbol"),
+			nil,
+		),
+		nftMetric: prometheus.NewDesc(
+			"wallet_nft_balance",
+			"Number of tokens of the specified NFT collection held by the wallet",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id", "collection"),
+			nil,
+		),
+		groupMetric: prometheus.NewDesc(
+			"wallet_group_balance_eth",
+			"Sum of the balances in ETH of the wallets in the group on aggregated endpoints",
+			[]string{"group", "chain_id"},
+			nil,
+		),
+		groupFailures: prometheus.NewDesc(
+			"wallet_group_fetch_failures",
+			"Number of wallets in the group whose balance could not be fetched in the last collection",
+			[]string{"group", "chain_id"},
+			nil,
+		),
+		contractMetric: prometheus.NewDesc(
+			"wallet_is_contract",
+			"Whether the specified wallet is a smart contract (1) or an externally owned account (0)",
+			walletLabelNames(config.LabelTemplates, "wallet", "chain_id"),
+			nil,
+		),
+		rpcCalls: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_calls_total",
+				Help: "Number of JSON-RPC calls sent to the provider, counting each call of a batch",
+			},
+			append([]string{"provider"}, endpointLabels...),
+		),
+		rpcDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "rpc_request_duration_seconds",
+				Help:    "Duration of JSON-RPC requests sent to the provider, by method",
+				Buckets: config.DurationBuckets,
+			},
+			append([]string{"provider"}, append(endpointLabels, "method")...),
+		),
+		walletDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "wallet_balance_fetch_duration_seconds",
+				Help:    "Duration of each attempt to fetch the balance of the wallet from the provider",
+				Buckets: config.DurationBuckets,
+			},
+			[]string{"wallet", "provider"},
+		),
+		rpcRetries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_retries_total",
+				Help: "Number of balance queries retried after a failed attempt against the provider",
+			},
+			append([]string{"provider"}, endpointLabels...),
+		),
+		fetchErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "balance_fetch_errors_total",
+				Help: "Number of wallet balances that could not be fetched from the provider, by reason",
+			},
+			append([]string{"provider", "reason"}, endpointLabels...),
+		),
+		rpcErrorCodes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rpc_error_code_total",
+				Help: "Number of JSON-RPC errors answered by the provider, by error code",
+			},
+			append([]string{"provider", "code"}, endpointLabels...),
+		),
+		ensChanges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ens_resolution_changes_total",
+				Help: "Number of times the ENS name of a wallet resolved to a different address than before",
+			},
+			append([]string{"provider", "name"}, endpointLabels...),
+		),
+		resolvedMetric: prometheus.NewDesc(
+			"resolved_addresses_total",
+			"Number of monitored wallets across all endpoints by the source of their address",
+			[]string{"source"},
+			nil,
+		),
+		peerSpread: prometheus.NewDesc(
+			"balance_peer_spread_wei",
+			"Difference in Wei between the highest and lowest balance of the wallet fetched from the cross-check peers",
+			[]string{"wallet", "cross_check"},
+			nil,
+		),
+		mismatches: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "balance_mismatch_total",
+				Help: "Number of fetches in which the cross-check peers of the group disagreed on the wallet's balance beyond the tolerance",
+			},
+			[]string{"wallet", "cross_check"},
+		),
+		precisionLoss: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "balance_precision_loss_total",
+				Help: "Number of fetched balances of the specified wallet that the ETH gauge does not represent exactly",
+			},
+			[]string{"wallet", "chain_id"},
+		),
+		collectTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "collect_timeout_total",
+			Help: "Number of collections that hit the collection timeout and returned partial results",
+		}),
+		timedOutWallets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "collect_timed_out_wallets_total",
+			Help: "Number of wallet balances skipped because the collection timeout expired before they were fetched",
+		}),
+		lockTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "collect_lock_timeout_total",
+			Help: "Number of collections that gave up waiting for a fetch in progress and returned without fetching",
+		}),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_timestamp_seconds",
+			Help: "Time of the last successful load of the configuration, in seconds since the epoch",
+		}),
+		ready: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "collector_ready",
+			Help: "Whether a collection or refresh has fetched balances since startup (1) or not yet (0)",
+		}),
+		fetchLock: make(chan struct{}, 1),
+	}
+	c.lastReload.SetToCurrentTime()
+
+	c.endpointLabels = endpointLabels
+	c.tagNames = tagNames
+	c.verifyProofs = hasProofVerification(endpoints)
+	if config.EtherscanURL != "" {
+		c.etherscan = newEtherscanSource(config.EtherscanURL, config.EtherscanAPIKey, transport)
+	}
+	if c.etherscan != nil || c.verifyProofs {
+		c.balanceMetric = newBalanceMetric(config.LabelTemplates, c.etherscan != nil, c.verifyProofs)
+	}
+	if config.UnifiedBalanceMetric {
+		c.balanceMetric = newUnifiedMetric(config.LabelTemplates, c.etherscan != nil, c.verifyProofs)
+		c.tokenMetric = c.balanceMetric
+	}
+	c.staggerStart(endpoints)
+	if config.NamingServiceURL != "" {
+		c.names = newNamingService(config.NamingServiceURL)
+	}
+	if len(config.FiatCurrencies) > 0 {
+		c.prices = newPriceSource(config.PriceURL, config.FiatCurrencies)
+	}
+	if config.MaxConcurrency > 0 {
+		c.concurrency = make(chan struct{}, config.MaxConcurrency)
+	}
+
+	for _, endpoint := range endpoints {
+		c.rpcCalls.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name)...)
+		c.rpcRetries.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name)...)
+	}
+	return c
+}
+
+// Register creates a new WalletBalanceCollector and registers it with registerer.
+func Register(registerer prometheus.Registerer, endpoints []EndpointConfig, config CollectorConfig) (*WalletBalanceCollector, error) {
+	if config.UnifiedBalanceMetric {
+		if err := validateUnifiedAssets(endpoints); err != nil {
+			return nil, err
+		}
+	}
+	collector := New(endpoints, config)
+	if err := registerer.Register(collector); err != nil {
+		return nil, err
+	}
+	return collector, nil
+}
+
+// Describe sends the descriptors of the metrics to Prometheus.
+func (c *WalletBalanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.balanceMetric
+	ch <- c.snapshotMetric
+	ch <- c.contractMetric
+	if c.tokenMetric != c.balanceMetric {
+		ch <- c.tokenMetric
+	}
+	ch <- c.thresholdMetric
+	ch <- c.nftMetric
+	ch <- c.groupMetric
+	ch <- c.groupFailures
+	if c.config.TotalAcrossChains {
+		ch <- c.totalMetric
+	}
+	if c.prices != nil {
+		ch <- c.fiatMetric
+		ch <- c.priceUpdated
+	}
+	ch <- c.ratioMetric
+	if c.config.GweiMetric {
+		ch <- c.gweiMetric
+	}
+	ch <- c.statusMetric
+	ch <- c.upMetric
+	ch <- c.downMetric
+	ch <- c.refreshMetric
+	ch <- c.timeoutMetric
+	ch <- c.clientsMetric
+	ch <- c.clientAge
+	if c.config.CircuitFailures > 0 {
+		ch <- c.circuitState
+	}
+	ch <- c.blockMetric
+	c.rpcCalls.Describe(ch)
+	c.rpcDuration.Describe(ch)
+	c.walletDuration.Describe(ch)
+	c.rpcRetries.Describe(ch)
+	c.fetchErrors.Describe(ch)
+	c.rpcErrorCodes.Describe(ch)
+	c.ensChanges.Describe(ch)
+	c.mismatches.Describe(ch)
+	ch <- c.peerSpread
+	ch <- c.resolvedMetric
+	c.precisionLoss.Describe(ch)
+	ch <- c.collectTimeouts.Desc()
+	ch <- c.timedOutWallets.Desc()
+	ch <- c.lockTimeouts.Desc()
+	ch <- c.lastReload.Desc()
+	ch <- c.ready.Desc()
+}
+
+// Collect fetches the balance for each wallet and sends it to Prometheus.
+func (c *WalletBalanceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(context.Background(), ch, nil)
+}
+
+// ForWallets returns a collector that only fetches and exports the wallets whose lowercase addresses
+// are in wallets. Endpoint-level metrics are exported unchanged.
+func (c *WalletBalanceCollector) ForWallets(wallets map[string]bool) prometheus.Collector {
+	return &walletFilterCollector{ctx: context.Background(), collector: c, wallets: wallets}
+}
+
+// walletFilterCollector is a view of a WalletBalanceCollector restricted to some wallets. Its fetches
+// are canceled when ctx is done.
+type walletFilterCollector struct {
+	ctx       context.Context
+	collector *WalletBalanceCollector
+	wallets   map[string]bool
+}
+
+// Describe sends the descriptors of the underlying collector's metrics to Prometheus.
+func (f *walletFilterCollector) Describe(ch chan<- *prometheus.Desc) {
+	f.collector.Describe(ch)
+}
+
+// Collect fetches the balance for each selected wallet and sends it to Prometheus.
+func (f *walletFilterCollector) Collect(ch chan<- prometheus.Metric) {
+	f.collector.collect(f.ctx, ch, f.wallets)
+}
+
+// selectEndpoints returns the endpoints restricted to the wallets in filter, dropping endpoints
+// left without wallets. A nil filter selects every wallet.
+func (c *WalletBalanceCollector) selectEndpoints(filter map[string]bool) []EndpointConfig {
+	if filter == nil {
+		return c.getEndpoints()
+	}
+
+	var endpoints []EndpointConfig
+	for _, endpoint := range c.getEndpoints() {
+		var wallets []WalletConfig
+		for _, wallet := range endpoint.Wallets {
+			if filter[strings.ToLower(wallet.Address)] {
+				wallets = append(wallets, wallet)
+			}
+		}
+		if len(wallets) > 0 {
+			endpoint.Wallets = wallets
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// collect sends the metrics of the wallets selected by filter to Prometheus. With a refresh interval
+// configured, the balances of the latest background refresh are exported; otherwise they are fetched
+// for the collection. Every RPC call of the collection is canceled once it returns or ctx is done.
+// Series dropped by the relabel rules are not sent.
+func (c *WalletBalanceCollector) collect(ctx context.Context, ch chan<- prometheus.Metric, filter map[string]bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch, flush := c.relabel(ch)
+	defer flush()
+	if c.config.DuplicateWallets == DuplicateDrop {
+		var flushDuplicates func()
+		ch, flushDuplicates = c.dropDuplicates(ch)
+		defer flushDuplicates()
+	}
+
+	endpoints := c.selectEndpoints(filter)
+
+	var results []fetchResult
+	if c.config.RefreshInterval > 0 {
+		results = c.refreshedResults(filter)
+	} else if c.lockFetches(ctx) {
+		results = c.fetchAll(ctx, endpoints)
+		c.unlockFetches()
+	} else {
+		results = lockedResults(endpoints)
+	}
+
+	totals := make(walletTotals)
+	groups := make(groupTotals)
+	statuses := make(endpointStatuses)
+	skipped := c.crossCheckSkipped(results)
+	for i, result := range results {
+		if !errors.Is(result.err, errFetchLocked) && !result.restored {
+			reason := reasonOK
+			if result.err != nil {
+				reason = c.endpointStatusReason(result.err)
+			}
+			statuses.add(result.endpoint, reason)
+		}
+		if skipped[i] {
+			continue
+		}
+		if result.endpoint.Aggregate {
+			c.addGroupResult(groups, result)
+			continue
+		}
+		c.collectTokens(ch, result)
+		c.collectNFTs(ch, result)
+		if balance, ok := c.collectResult(ch, result); ok {
+			totals.add(result.wallet, balance)
+			c.collectFiat(ch, result, balance)
+			c.collectRatio(ch, result, balance)
+			c.collectGwei(ch, result, balance)
+		}
+	}
+
+	if c.config.TotalAcrossChains {
+		for _, total := range totals {
+			ch <- prometheus.MustNewConstMetric(
+				c.totalMetric,
+				prometheus.GaugeValue,
+				total.balance,
+				c.addressLabel(total.walletAddress),
+			)
+		}
+	}
+
+	c.collectGroups(ch, groups)
+	c.collectPeerSpreads(ch, results)
+	c.collectResolvedAddresses(ch)
+	c.collectPriceAge(ch)
+	c.collectEndpointStatuses(ch, endpoints, statuses)
+	ch <- prometheus.MustNewConstMetric(c.refreshMetric, prometheus.GaugeValue, c.config.RefreshInterval.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.timeoutMetric, prometheus.GaugeValue, c.config.RPCTimeout.Seconds())
+	c.collectSnapshots(ch, endpoints)
+	c.collectConfirmedBlocks(ch, endpoints)
+	c.collectContracts(ch, endpoints)
+
+	c.clientMutex.Lock()
+	ch <- prometheus.MustNewConstMetric(c.clientsMetric, prometheus.GaugeValue, float64(len(c.clientCache)))
+	for _, endpoint := range endpoints {
+		if created, exists := c.clientCreated[endpoint.URL]; exists {
+			ch <- prometheus.MustNewConstMetric(c.clientAge, prometheus.GaugeValue, time.Since(created).Seconds(), c.endpointLabelValues(endpoint, endpoint.Name)...)
+		}
+	}
+	c.clientMutex.Unlock()
+	c.collectCircuits(ch, endpoints)
+
+	c.rpcCalls.Collect(ch)
+	c.rpcDuration.Collect(ch)
+	c.walletDuration.Collect(ch)
+	c.rpcRetries.Collect(ch)
+	c.fetchErrors.Collect(ch)
+	c.rpcErrorCodes.Collect(ch)
+	c.ensChanges.Collect(ch)
+	c.mismatches.Collect(ch)
+	c.precisionLoss.Collect(ch)
+	ch <- c.collectTimeouts
+	ch <- c.timedOutWallets
+	ch <- c.lockTimeouts
+	ch <- c.lastReload
+	ch <- c.ready
+}
+
+// fetchAll fetches the balance of each wallet on the endpoints. Endpoints are fetched concurrently
+// and share the collection timeout as their budget: once it expires or ctx is done, outstanding
+// fetches are abandoned, wallets not yet fetched are skipped, and they are all reported as failed.
+// Wallets skipped by the timeout fail with an error marking them as timed out.
+func (c *WalletBalanceCollector) fetchAll(ctx context.Context, endpoints []EndpointConfig) []fetchResult {
+	ctx, cancel := context.WithTimeout(ctx, c.config.CollectTimeout)
+	defer cancel()
+
+	pending := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			pending[balanceKey(endpoint.URL, wallet.Address)] = true
+		}
+	}
+
+	// The buffer holds every result so that abandoned fetches never block.
+	fetched := make(chan fetchResult, len(pending))
+	for _, endpoint := range endpoints {
+		go c.fetchEndpoint(ctx, endpoint, fetched)
+	}
+
+	results := make([]fetchResult, 0, len(pending))
+	for len(pending) > 0 {
+		select {
+		case result := <-fetched:
+			delete(pending, balanceKey(result.endpoint.URL, result.wallet.Address))
+			c.cacheMutex.Lock()
+			if result.err == nil {
+				c.lastBalances[balanceKey(result.endpoint.URL, result.wallet.Address)] = result
+			}
+			c.recordFetchError(result)
+			c.cacheMutex.Unlock()
+			results = append(results, result)
+		case <-ctx.Done():
+			err := ctx.Err()
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("Collection timed out after %s with %d balances outstanding", c.config.CollectTimeout, len(pending))
+				c.collectTimeouts.Inc()
+				c.timedOutWallets.Add(float64(len(pending)))
+				err = fmt.Errorf("balance not fetched: collection timed out after %s: %w", c.config.CollectTimeout, err)
+			} else {
+				log.Printf("Collection canceled with %d balances outstanding", len(pending))
+			}
+			for _, endpoint := range endpoints {
+				for _, wallet := range endpoint.Wallets {
+					if pending[balanceKey(endpoint.URL, wallet.Address)] {
+						result := fetchResult{endpoint: endpoint, wallet: wallet, err: err}
+						c.cacheMutex.Lock()
+						c.recordFetchError(result)
+						c.cacheMutex.Unlock()
+						results = append(results, result)
+					}
+				}
+			}
+			clear(pending)
+		}
+	}
+
+	// Order the results as configured, so that of duplicate series the first endpoint's is exported
+	order := make(map[string]int, len(results))
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			if _, ok := order[balanceKey(endpoint.URL, wallet.Address)]; !ok {
+				order[balanceKey(endpoint.URL, wallet.Address)] = len(order)
+			}
+		}
+	}
+	slices.SortStableFunc(results, func(a, b fetchResult) int {
+		return cmp.Compare(order[balanceKey(a.endpoint.URL, a.wallet.Address)], order[balanceKey(b.endpoint.URL, b.wallet.Address)])
+	})
+
+	c.recordCircuits(endpoints, results)
+	c.crossCheck(results)
+
+	// The collector is ready once any balance has been fetched, so that readiness reflects real data
+	for _, result := range results {
+		if result.err == nil {
+			c.ready.Set(1)
+			break
+		}
+	}
+	c.updateHealth(results)
+	c.saveBalanceCache()
+	return results
+}
+
+// fetchResult is the outcome of fetching one wallet's balance.
+type fetchResult struct {
+	endpoint  EndpointConfig
+	wallet    WalletConfig
+	balance   float64
+	tokens    []tokenBalance
+	nfts      []nftBalance
+	fetchedAt time.Time
+	err       error
+	// balanceWei is the balance in Wei as fetched over RPC, before the wallet's factor is applied. It is
+	// nil if the balance was not fetched over RPC.
+	balanceWei *big.Int
+	// source is the source the balance was fetched from, sourceRPC or sourceEtherscan.
+	source string
+	// restored marks a balance restored from the balance cache file rather than fetched since startup.
+	restored bool
+}
+
+// fetchEndpoint fetches the balance of each wallet on the endpoint and sends the results, fetching
+// up to the endpoint's concurrency of wallets at a time within the global concurrency limit. It stops
+// starting fetches once ctx is done.
+func (c *WalletBalanceCollector) fetchEndpoint(ctx context.Context, endpoint EndpointConfig, results chan<- fetchResult) {
+	if c.waitForStart(ctx, endpoint) != nil {
+		return
+	}
+
+	probe, err := c.admitCircuit(endpoint)
+	if err != nil {
+		for _, wallet := range endpoint.Wallets {
+			results <- c.fallbackResult(ctx, endpoint, wallet, err)
+		}
+		return
+	}
+
+	client, err := c.getClient(ctx, endpoint)
+	if probe {
+		err = c.probeCircuit(ctx, endpoint, client, err)
+	}
+	if err != nil {
+		c.logEndpointError(endpoint, err, "Error connecting to provider %s: %v", endpoint.Name, err)
+		for _, wallet := range endpoint.Wallets {
+			results <- c.fallbackResult(ctx, endpoint, wallet, err)
+		}
+		return
+	}
+
+	if !endpoint.SkipChainID {
+		chainID, err := c.getChainID(ctx, endpoint, client)
+		if err == nil {
+			err = c.verifyChainID(endpoint, chainID)
+		}
+		if err != nil {
+			c.logEndpointError(endpoint, err, "WARNING: Not exporting the balances of provider %s, which is on the wrong chain: %v", endpoint.Name, err)
+			for _, wallet := range endpoint.Wallets {
+				results <- fetchResult{endpoint: endpoint, wallet: wallet, err: err}
+			}
+			return
+		}
+	}
+
+	// With confirmations, every wallet is read at the same block behind the head
+	var blockNumber *big.Int
+	if endpoint.Confirmations > 0 {
+		if blockNumber, err = c.confirmedBlock(ctx, endpoint, client); err != nil {
+			c.logEndpointError(endpoint, err, "Error retrieving confirmed block from provider %s: %v", endpoint.Name, err)
+			for _, wallet := range endpoint.Wallets {
+				results <- fetchResult{endpoint: endpoint, wallet: wallet, err: err}
+			}
+			return
+		}
+	}
+
+	if endpoint.BatchSize > 0 {
+		c.fetchBatches(ctx, endpoint, client, blockNumber, results)
+		return
+	}
+
+	semaphore := c.endpointSemaphore(endpoint)
+	for _, wallet := range endpoint.Wallets {
+		if !c.acquire(ctx, semaphore) {
+			return
+		}
+		go func() {
+			defer c.release(semaphore)
+			results <- c.fetchWallet(ctx, endpoint, client, wallet, blockNumber)
+		}()
+	}
+}
+
+// fetchWallet fetches the balance and token balances of a wallet on the endpoint at blockNumber, or at
+// the latest block if it is nil.
+func (c *WalletBalanceCollector) fetchWallet(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig, blockNumber *big.Int) fetchResult {
+	c.detectContract(ctx, endpoint, client, wallet)
+
+	var balanceWei *big.Int
+	err := c.withRetries(ctx, endpoint, func() error {
+		start := time.Now()
+		var err error
+		balanceWei, err = c.getWalletBalance(ctx, endpoint, client, wallet, blockNumber)
+		if c.config.WalletFetchDuration && !endpoint.Aggregate {
+			c.walletDuration.WithLabelValues(c.addressLabel(wallet.Address), endpoint.Name).Observe(time.Since(start).Seconds())
+		}
+		return err
+	})
+	return c.walletResult(ctx, endpoint, client, wallet, blockNumber, balanceWei, err)
+}
+
+// walletResult completes the result of a wallet whose balance in Wei was fetched, or failed with err:
+// it converts the balance, falls back to the Etherscan API on error and fetches the token balances.
+func (c *WalletBalanceCollector) walletResult(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig, blockNumber, balanceWei *big.Int, err error) fetchResult {
+	var balance float64
+	if err != nil && ctx.Err() == nil {
+		c.logEndpointError(endpoint, err, "Error retrieving balance for wallet %s from provider %s: %v", wallet.Address, endpoint.Name, err)
+		c.fetchErrors.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name, c.endpointStatusReason(err))...).Inc()
+	}
+	if err == nil {
+		var exact bool
+		balance, exact = weiToETH(balanceWei, endpoint.nativeDecimals(), c.config.Precision, c.config.RoundingMode)
+		if !exact && !endpoint.Aggregate {
+			c.precisionLoss.WithLabelValues(c.addressLabel(wallet.Address), c.chainLabel(endpoint)).Inc()
+		}
+	}
+	source := sourceRPC
+	if err != nil {
+		if fallback, ok := c.fetchFallback(ctx, endpoint, wallet); ok {
+			balance, source, err = fallback, sourceEtherscan, nil
+		}
+	}
+	tokens := c.fetchTokens(ctx, endpoint, client, wallet, blockNumber)
+	nfts := c.fetchNFTs(ctx, endpoint, client, wallet, blockNumber)
+	return fetchResult{endpoint: endpoint, wallet: wallet, balance: balance * wallet.factor(), balanceWei: balanceWei, tokens: tokens, nfts: nfts, fetchedAt: time.Now(), err: err, source: source}
+}
+
+// collectResult emits the balance of a fetched wallet, or the configured fallback if the fetch failed.
+// It returns the emitted balance and whether it is a number.
+func (c *WalletBalanceCollector) collectResult(ch chan<- prometheus.Metric, result fetchResult) (float64, bool) {
+	if result.err != nil {
+		return c.collectStale(ch, result.endpoint, result.wallet)
+	}
+	if c.expired(result.fetchedAt) {
+		return 0, false
+	}
+	if result.balance == 0 && c.config.HideZeroBalances {
+		return 0, true
+	}
+
+	chainID := c.chainLabel(result.endpoint)
+	ch <- c.withChangeTime(prometheus.MustNewConstMetric(
+		c.balanceMetric,
+		prometheus.GaugeValue,
+		result.balance,
+		c.walletLabelValues(result.endpoint, result.wallet, chainID, c.balanceLabels(result.wallet, chainID, result.source, verified(result.endpoint, result.source, result.restored))...)...,
+	), result, "", result.balance)
+	return result.balance, true
+}
+
+// collectRatio emits a wallet's balance, as exported in wallet_balance_eth, relative to its target, if
+// it has one. A zero balance is exported even with HideZeroBalances, as it is furthest from the target.
+func (c *WalletBalanceCollector) collectRatio(ch chan<- prometheus.Metric, result fetchResult, balance float64) {
+	if result.wallet.Target == 0 {
+		return
+	}
+
+	chainID := c.chainLabel(result.endpoint)
+	ch <- prometheus.MustNewConstMetric(
+		c.ratioMetric,
+		prometheus.GaugeValue,
+		balance/result.wallet.Target,
+		c.walletLabelValues(result.endpoint, result.wallet, chainID, c.addressLabel(result.wallet.Address), chainID, result.wallet.derivationLabel())...,
+	)
+}
+
+// collectGwei emits a wallet's balance, as exported in wallet_balance_eth, in gwei if GweiMetric is set.
+func (c *WalletBalanceCollector) collectGwei(ch chan<- prometheus.Metric, result fetchResult, balance float64) {
+	if !c.config.GweiMetric || (balance == 0 && c.config.HideZeroBalances) {
+		return
+	}
+
+	chainID := c.chainLabel(result.endpoint)
+	ch <- prometheus.MustNewConstMetric(
+		c.gweiMetric,
+		prometheus.GaugeValue,
+		gweiBalance(result, balance),
+		c.walletLabelValues(result.endpoint, result.wallet, chainID, c.addressLabel(result.wallet.Address), chainID, result.wallet.derivationLabel())...,
+	)
+}
+
+// gweiBalance returns the balance in gwei of a result whose balance exported in ETH is balance. A balance
+// fetched over RPC is converted from Wei, so that it keeps gwei resolution whatever the precision of the
+// ETH value; held, restored and Etherscan balances are converted from ETH.
+func gweiBalance(result fetchResult, balance float64) float64 {
+	if result.err != nil || result.balanceWei == nil {
+		return balance * 1e9
+	}
+
+	gwei := new(big.Float).SetInt(result.balanceWei)
+	if decimals := int(result.endpoint.nativeDecimals()); decimals >= 9 {
+		gwei.Quo(gwei, decimalUnits[decimals-9].float)
+	} else {
+		gwei.Mul(gwei, decimalUnits[9-decimals].float)
+	}
+	value, _ := gwei.Float64()
+	return value * result.wallet.factor()
+}
+
+// walletTotal is the sum of a wallet's balances across endpoints, with the address of its first entry.
+type walletTotal struct {
+	walletAddress string
+	balance       float64
+}
+
+// walletTotals sums balances per wallet, keyed by address so that differently cased entries of the
+// same wallet are combined.
+type walletTotals map[common.Address]walletTotal
+
+// add adds a balance of the wallet to its total.
+func (t walletTotals) add(wallet WalletConfig, balance float64) {
+	key := wallet.hexAddress()
+	total := t[key]
+	if total.walletAddress == "" {
+		total.walletAddress = wallet.Address
+	}
+	total.balance += balance
+	t[key] = total
+}
+
+// collectSnapshots emits the latest recorded snapshot of each wallet on the endpoints.
+func (c *WalletBalanceCollector) collectSnapshots(ch chan<- prometheus.Metric, endpoints []EndpointConfig) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+
+	secondary := crossCheckSecondary(endpoints)
+	for _, endpoint := range endpoints {
+		chainID := c.chainLabel(endpoint)
+		for _, wallet := range endpoint.Wallets {
+			snap, ok := c.snapshots[balanceKey(endpoint.URL, wallet.Address)]
+			if !ok || secondary[balanceKey(endpoint.URL, wallet.Address)] {
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.snapshotMetric,
+				prometheus.GaugeValue,
+				snap.balance,
+				c.walletLabelValues(endpoint, wallet, chainID, c.addressLabel(wallet.Address), chainID, snap.date)...,
+			)
+		}
+	}
+}
+
+// collectStale emits the configured fallback for a wallet whose balance could not be fetched.
+// It returns the emitted balance and whether it is a number.
+func (c *WalletBalanceCollector) collectStale(ch chan<- prometheus.Metric, endpoint EndpointConfig, wallet WalletConfig) (float64, bool) {
+	var (
+		value     float64
+		fetchedAt time.Time
+		source    string
+		restored  bool
+	)
+	switch c.config.StaleBehavior {
+	case StaleHold:
+		c.cacheMutex.Lock()
+		last, ok := c.lastBalances[balanceKey(endpoint.URL, wallet.Address)]
+		c.cacheMutex.Unlock()
+		if !ok || c.expired(last.fetchedAt) {
+			return 0, false
+		}
+		if last.balance == 0 && c.config.HideZeroBalances {
+			return 0, true
+		}
+		value, fetchedAt, source, restored = last.balance, last.fetchedAt, last.source, last.restored
+	case StaleNaN:
+		value = math.NaN()
+	default:
+		return 0, false
+	}
+
+	chainID := c.chainLabel(endpoint)
+	ch <- c.withRestoredTime(prometheus.MustNewConstMetric(
+		c.balanceMetric,
+		prometheus.GaugeValue,
+		value,
+		c.walletLabelValues(endpoint, wallet, chainID, c.balanceLabels(wallet, chainID, source, verified(endpoint, source, restored))...)...,
+	), fetchedAt, restored)
+	return value, !math.IsNaN(value)
+}
+
+// balanceKey identifies a wallet on a specific RPC URL in the last-known balance cache.
+func balanceKey(rpcURL, walletAddress string) string {
+	return rpcURL + "|" + walletAddress
+}
+
+// getClient retrieves or creates an ethclient.Client for the given endpoint.
+func (c *WalletBalanceCollector) getClient(ctx context.Context, endpoint EndpointConfig) (*ethclient.Client, error) {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	if client, exists := c.clientCache[endpoint.URL]; exists {
+		return client, nil
+	}
+
+	httpClient := &http.Client{
+		Timeout: c.config.RPCTimeout,
+		Transport: &instrumentedTransport{
+			next:  c.transport,
+			calls: c.rpcCalls.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name)...),
+			duration: func(method string) prometheus.Observer {
+				return c.rpcDuration.WithLabelValues(append(c.endpointLabelValues(endpoint, endpoint.Name), method)...)
+			},
+		},
+	}
+	wsDialer := websocket.Dialer{
+		Proxy:            c.config.Proxy,
+		HandshakeTimeout: c.config.RPCTimeout,
+	}
+	if c.dnsCache != nil {
+		wsDialer.NetDialContext = c.dnsCache.DialContext
+	}
+	ctx, span := startRPCSpan(ctx, "rpc.dial", endpoint)
+	rpcClient, err := rpc.DialOptions(ctx, endpoint.URL, rpc.WithHTTPClient(httpClient), rpc.WithWebsocketDialer(wsDialer))
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	client := ethclient.NewClient(rpcClient)
+	c.clientCache[endpoint.URL] = client
+	c.clientCreated[endpoint.URL] = time.Now()
+	// A new connection may reach a different chain, so the chain ID is queried again
+	delete(c.chainIDs, endpoint.URL)
+	log.Printf("Successfully connected to provider: %s", endpoint.Name)
+	return client, nil
+}
+
+// cachedChainID is a chain ID reported by an endpoint and when it was retrieved.
+type cachedChainID struct {
+	chainID   *big.Int
+	fetchedAt time.Time
+}
+
+// verifyChainID returns an error wrapping errChainIDMismatch if the endpoint has an expected chain ID,
+// or is pinned to one, and reported a different one. A chain ID that could not be queried is not verified.
+func (c *WalletBalanceCollector) verifyChainID(endpoint EndpointConfig, chainID *big.Int) error {
+	expected := c.expectedChainID(endpoint, chainID)
+	if expected == "" || chainID == nil || chainID.String() == expected {
+		return nil
+	}
+	return fmt.Errorf("%w: provider reports chain %s, expected chain %s", errChainIDMismatch, chainID, expected)
+}
+
+// getChainID returns the chain ID of the endpoint, querying it again once the cached value is older
+// than the chain ID TTL. If the query fails, the previously cached chain ID, or nil if there is none,
+// is returned. If the chain ID changed since it was last queried over the client, the client is
+// evicted, so that the next fetch connects again, and an error wrapping errChainIDMismatch is returned,
+// so that no balances are exported from the connection that switched chains.
+func (c *WalletBalanceCollector) getChainID(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client) (*big.Int, error) {
+	c.clientMutex.Lock()
+	cached, exists := c.chainIDs[endpoint.URL]
+	c.clientMutex.Unlock()
+	if exists && (c.config.ChainIDTTL == 0 || time.Since(cached.fetchedAt) < c.config.ChainIDTTL) {
+		return cached.chainID, nil
+	}
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		c.logEndpointError(endpoint, err, "Error retrieving chain ID from provider %s: %v", endpoint.Name, err)
+		return cached.chainID, nil
+	}
+
+	if exists && cached.chainID.Cmp(chainID) != 0 {
+		log.Printf("WARNING: Chain ID of provider %s changed from %s to %s, reconnecting", endpoint.Name, cached.chainID, chainID)
+		c.evictClient(endpoint, client)
+		return nil, fmt.Errorf("%w: provider switched from chain %s to chain %s", errChainIDMismatch, cached.chainID, chainID)
+	}
+
+	c.clientMutex.Lock()
+	c.chainIDs[endpoint.URL] = cachedChainID{chainID: chainID, fetchedAt: time.Now()}
+	c.clientMutex.Unlock()
+	return chainID, nil
+}
+
+// chainLabel returns the chain_id label value of the endpoint: its expected chain ID if set, else the
+// chain ID reported by the endpoint if known, else the one restored from the balance cache file,
+// otherwise the statically configured chain.
+func (c *WalletBalanceCollector) chainLabel(endpoint EndpointConfig) string {
+	if endpoint.ExpectedChainID != "" {
+		return endpoint.ExpectedChainID
+	}
+	if chainID, pinned := c.pinnedChainID(endpoint); pinned {
+		return chainID
+	}
+
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	if cached, exists := c.chainIDs[endpoint.URL]; exists {
+		return cached.chainID.String()
+	}
+	if chainID, restored := c.restoredChainIDs[endpoint.URL]; restored {
+		return chainID
+	}
+	return endpoint.Chain
+}
+
+// instrumentedTransport counts and times the JSON-RPC requests sent over an HTTP RPC connection. Requests
+// over WebSocket bypass it, as go-ethereum's client offers no hook into their messages.
+type instrumentedTransport struct {
+	next  http.RoundTripper
+	calls prometheus.Counter
+	// duration returns the observer of the request duration of a JSON-RPC method.
+	duration func(method string) prometheus.Observer
+}
+
+// RoundTrip counts the JSON-RPC calls of the request, each call of a batch on its own, passes it on to
+// the next transport and records how long it took under each JSON-RPC method of its calls, so that a
+// batch mixing methods is timed under every one of them.
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	methods := requestMethods(req)
+	t.calls.Add(float64(max(len(methods), 1)))
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+	if len(methods) == 0 {
+		t.duration("unknown").Observe(elapsed)
+	}
+	for _, method := range slices.Compact(slices.Sorted(slices.Values(methods))) {
+		t.duration(method).Observe(elapsed)
+	}
+	return resp, err
+}
+
+// requestMethods returns the JSON-RPC methods of the calls of an HTTP request, read from a copy of its
+// body: the method of a single call or those of every call of a batch, or nil if the body cannot be
+// read.
+func requestMethods(req *http.Request) []string {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil
+	}
+
+	type call struct {
+		Method string `json:"method"`
+	}
+	var single call
+	if err := json.Unmarshal(data, &single); err == nil && single.Method != "" {
+		return []string{single.Method}
+	}
+	var batch []call
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil
+	}
+	methods := make([]string, 0, len(batch))
+	for _, element := range batch {
+		if element.Method == "" {
+			return nil
+		}
+		methods = append(methods, element.Method)
+	}
+	return methods
+}
+
+// errNoBalance is returned for a balance query that the provider answered without a balance.
+var errNoBalance = errors.New("provider returned no balance")
+
+// getWalletBalance retrieves the balance in Wei of the wallet at the given block, or at the latest block if blockNumber
+// is nil, using the endpoint's balance call if configured. Endpoints with verify_proofs prove it with eth_getProof.
+func (c *WalletBalanceCollector) getWalletBalance(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig, blockNumber *big.Int) (*big.Int, error) {
+	if endpoint.VerifyProofs {
+		return c.getProvenBalance(ctx, endpoint, client, wallet, blockNumber)
+	}
+
+	method := "eth_getBalance"
+	if endpoint.BalanceCall != nil {
+		method = "eth_call"
+	}
+	ctx, span := startRPCSpan(ctx, method, endpoint, attribute.String("wallet", wallet.Address))
+	if blockNumber != nil {
+		span.SetAttributes(attribute.String("block", blockNumber.String()))
+	}
+
+	var (
+		address    = wallet.hexAddress()
+		balanceWei *big.Int
+		err        error
+	)
+	if endpoint.BalanceCall != nil {
+		balanceWei, err = callBalance(ctx, client, endpoint.BalanceCall, address, blockNumber)
+	} else {
+		balanceWei, err = client.BalanceAt(ctx, address, blockNumber)
+		// Some providers answer with an error rather than 0 for accounts that were never used
+		if err != nil && c.isEmptyAccount(err) {
+			span.SetAttributes(attribute.String("empty_account", err.Error()))
+			balanceWei, err = new(big.Int), nil
+		}
+	}
+	if err == nil && balanceWei == nil {
+		err = errNoBalance
+	}
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		endSpan(span, err)
+		return nil, err
+	}
+	endSpan(span, nil)
+	return balanceWei, nil
+}
+
+// weiToETH converts a Wei amount to ETH (1 ETH = 10^18 Wei), or more generally an amount in the smallest
+// unit of a native token with the given decimals to whole tokens. The quotient is rounded to precision
+// bits using mode before the final conversion to float64; a precision of 0 keeps the default precision.
+//
+// It also reports whether the float64 exactly represents the balance when read back in its shortest
+// decimal form, as Prometheus exposes it. The big.Float accuracy alone would flag almost every balance,
+// since 10^-18 has no exact binary representation, so an inexact conversion is confirmed by comparing
+// that decimal form with the exact quotient.
+func weiToETH(balanceWei *big.Int, decimals uint8, precision uint, mode big.RoundingMode) (float64, bool) {
+	unit := decimalUnits[decimals]
+	balanceETH := new(big.Float).SetMode(mode).SetPrec(precision)
+	balanceETH.Quo(new(big.Float).SetInt(balanceWei), unit.float)
+	// Float64 always rounds to nearest even, so round to a float64 mantissa with the mode first
+	rounded := new(big.Float).SetMode(mode).SetPrec(53).Set(balanceETH)
+	balance, _ := rounded.Float64()
+	if balanceETH.Acc() == big.Exact && rounded.Acc() == big.Exact {
+		return balance, true
+	}
+
+	exported, ok := new(big.Rat).SetString(strconv.FormatFloat(balance, 'f', -1, 64))
+	if !ok {
+		return balance, false
+	}
+	return balance, exported.Cmp(new(big.Rat).SetFrac(balanceWei, unit.integer)) == 0
+}
+
+// decimalUnit is 10^decimals, the smallest unit of a token with that many decimals per whole token.
+type decimalUnit struct {
+	integer *big.Int
+	float   *big.Float
+}
+
+// decimalUnits holds the unit of every number of decimals, computed once rather than for every
+// balance. They are shared by concurrent conversions and must not be modified.
+var decimalUnits = func() (units [256]decimalUnit) {
+	unit := big.NewInt(1)
+	for decimals := range units {
+		units[decimals] = decimalUnit{new(big.Int).Set(unit), new(big.Float).SetInt(unit)}
+		unit.Mul(unit, big.NewInt(10))
+	}
+	return units
+}()
+
+// ParseRPCMapping parses the RPC_URL_MAPPING environment variable into a map of RPC URLs and associated wallets,
+// whose addresses are validated and parsed once here.
+func ParseRPCMapping(rpcMapping string) (map[string][]WalletConfig, error) {
+	rpcWalletMapping := make(map[string][]WalletConfig)
+	mappings := strings.Split(strings.TrimSpace(rpcMapping), "|")
+
+	for i, mapping := range mappings {
+		mapping = strings.TrimSpace(mapping)
+		segment := fmt.Sprintf("RPC_URL_MAPPING segment %d of %d", i+1, len(mappings))
+
+		if mapping == "" {
+			return nil, fmt.Errorf("%s is empty (check for a stray or doubled |)", segment)
+		}
+
+		// Wallet addresses never contain a colon, so the last colon after the URL scheme separates
+		// the RPC URL, which may include a port, from the wallets
+		schemeEnd := strings.Index(mapping, "://") + len("://")
+		colonIndex := strings.LastIndex(mapping, ":")
+		if colonIndex < schemeEnd || colonIndex == len(mapping)-1 {
+			return nil, fmt.Errorf("invalid format in %s: %s (missing colon or wallets, expected RPC_URL:wallet1,wallet2)", segment, mapping)
+		}
+
+		rpcURL := strings.TrimSpace(mapping[:colonIndex])
+		wallets := mapping[colonIndex+1:]
+
+		// A segment of only a URL with a port, such as http://localhost:8545, splits at the port
+		if isPort(wallets) {
+			return nil, fmt.Errorf("invalid format in %s: %s (missing wallets after the RPC URL, expected RPC_URL:wallet1,wallet2)", segment, mapping)
+		}
+
+		// Validate RPC URL format
+		if !isRPCURL(rpcURL) {
+			return nil, fmt.Errorf("invalid RPC URL in %s: %s (must start with http://, https://, ws:// or wss://)", segment, rpcURL)
+		}
+
+		// Split wallet addresses into a slice, keeping each address as written for the wallet label
+		var walletList []WalletConfig
+		for j, wallet := range strings.Split(wallets, ",") {
+			address, err := NormalizeAddress(wallet)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wallet %d in %s: %v", j+1, segment, err)
+			}
+			walletList = append(walletList, WalletConfig{Address: address, parsed: common.HexToAddress(address)})
+		}
+		rpcWalletMapping[rpcURL] = walletList
+	}
+
+	return rpcWalletMapping, nil
+}
+
+// isPort reports whether value is a port number, which no wallet address is.
+func isPort(value string) bool {
+	port, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	return err == nil && port > 0
+}
+
+// isRPCURL reports whether rawURL uses a scheme supported for RPC connections.
+func isRPCURL(rawURL string) bool {
+	for _, scheme := range []string{"http://", "https://", "ws://", "wss://"} {
+		if strings.HasPrefix(rawURL, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeAddress validates a wallet address given with or without the 0x prefix and returns it 0x-prefixed.
+func NormalizeAddress(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	hexAddress := address
+	if strings.HasPrefix(hexAddress, "0x") || strings.HasPrefix(hexAddress, "0X") {
+		hexAddress = hexAddress[2:]
+	}
+
+	if !common.IsHexAddress(hexAddress) {
+		return "", fmt.Errorf("invalid wallet address: %s (must be 40 hex characters, optionally prefixed with 0x)", address)
+	}
+	return "0x" + hexAddress, nil
+}
+
+// MetricsHandler serves all metrics of registry, or only those of the wallets named by wallet query
+// parameters (e.g. /metrics?wallet=0xabc&wallet=0xdef). Filtered scrapes only query the named wallets.
+func MetricsHandler(registry *prometheus.Registry, collector *WalletBalanceCollector) http.Handler {
+	handler := promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wallets := r.URL.Query()["wallet"]
+		if len(wallets) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		filter := make(map[string]bool)
+		for _, wallet := range wallets {
+			address, err := NormalizeAddress(wallet)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter[strings.ToLower(address)] = true
+		}
+
+		filtered := prometheus.NewRegistry()
+		filtered.MustRegister(&walletFilterCollector{ctx: r.Context(), collector: collector, wallets: filter})
+		promhttp.HandlerFor(filtered, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
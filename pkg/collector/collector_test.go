@@ -0,0 +1,486 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestWeiToETHRounding(t *testing.T) {
+	balanceWei, _ := new(big.Int).SetString("1234567891234567891", 10)
+
+	tests := []struct {
+		name      string
+		precision uint
+		mode      big.RoundingMode
+		want      float64
+	}{
+		{"default precision, to nearest even", 0, big.ToNearestEven, 1.234567891234568},
+		{"default precision, to zero", 0, big.ToZero, 1.2345678912345677},
+		{"default precision, away from zero", 0, big.AwayFromZero, 1.234567891234568},
+		{"8 bits, to zero", 8, big.ToZero, 1.234375},
+		{"8 bits, away from zero", 8, big.AwayFromZero, 1.2421875},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, exact := weiToETH(balanceWei, 18, test.precision, test.mode)
+			if got != test.want {
+				t.Errorf("weiToETH() = %v, want %v", got, test.want)
+			}
+			if exact {
+				t.Errorf("weiToETH() reports an exact conversion of %s Wei", balanceWei)
+			}
+		})
+	}
+}
+
+func TestWeiToETHRoundingModesDiffer(t *testing.T) {
+	balanceWei, _ := new(big.Int).SetString("1234567891234567891", 10)
+
+	for _, precision := range []uint{0, 8, 32} {
+		toZero, _ := weiToETH(balanceWei, 18, precision, big.ToZero)
+		awayFromZero, _ := weiToETH(balanceWei, 18, precision, big.AwayFromZero)
+		if toZero >= awayFromZero {
+			t.Errorf("precision %d: ToZero gives %v, not below AwayFromZero's %v", precision, toZero, awayFromZero)
+		}
+	}
+}
+
+func TestWeiToETHExact(t *testing.T) {
+	for _, mode := range []big.RoundingMode{big.ToZero, big.AwayFromZero} {
+		got, exact := weiToETH(big.NewInt(1500000000000000000), 18, 0, mode)
+		if got != 1.5 || !exact {
+			t.Errorf("%v: weiToETH() = %v, %v, want 1.5, true", mode, got, exact)
+		}
+	}
+}
+
+func TestParseRPCMappingAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		wallet  string
+		want    string
+		wantErr bool
+	}{
+		{"bare hex", "742d35Cc6634C0532925a3b844Bc454e4438f44e", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", false},
+		{"0x-prefixed", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", false},
+		{"0X-prefixed", "0X742d35Cc6634C0532925a3b844Bc454e4438f44e", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", false},
+		{"surrounding spaces", " 0x742d35Cc6634C0532925a3b844Bc454e4438f44e ", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", false},
+		{"too short", "0x742d35Cc6634C0532925a3b844Bc454e4438f4", "", true},
+		{"too long", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e00", "", true},
+		{"bare too short", "742d35Cc6634C0532925a3b844Bc454e4438f4", "", true},
+		{"non-hex", "0x742d35Cc6634C0532925a3b844Bc454e4438f44g", "", true},
+		{"empty", "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapping, err := ParseRPCMapping("http://localhost:8545:" + test.wallet)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRPCMapping() accepted wallet %q", test.wallet)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRPCMapping() error = %v", err)
+			}
+			wallets := mapping["http://localhost:8545"]
+			if len(wallets) != 1 || wallets[0].Address != test.want {
+				t.Fatalf("ParseRPCMapping() wallets = %+v, want address %s", wallets, test.want)
+			}
+			if wallets[0].hexAddress().Hex() != "0x742d35Cc6634C0532925a3b844Bc454e4438f44e" {
+				t.Errorf("wallet parsed as %s", wallets[0].hexAddress().Hex())
+			}
+		})
+	}
+}
+
+func TestParseRPCMappingErrors(t *testing.T) {
+	wallet := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	tests := []struct {
+		name    string
+		mapping string
+		want    []string
+	}{
+		{
+			"empty segment",
+			"http://a:8545:" + wallet + "||http://b:8545:" + wallet,
+			[]string{"segment 2 of 3 is empty", "stray or doubled |"},
+		},
+		{
+			"no colon",
+			"http://a:8545:" + wallet + "|" + wallet,
+			[]string{"segment 2 of 2", "missing colon or wallets", "expected RPC_URL:wallet1,wallet2"},
+		},
+		{
+			"trailing colon",
+			"http://a:8545:",
+			[]string{"segment 1 of 1", "missing colon or wallets", "expected RPC_URL:wallet1,wallet2"},
+		},
+		{
+			"URL with port and no wallets",
+			"http://a:8545:" + wallet + "|http://localhost:8545",
+			[]string{"segment 2 of 2", "missing wallets after the RPC URL", "expected RPC_URL:wallet1,wallet2"},
+		},
+		{
+			"unsupported scheme",
+			"ftp://a:" + wallet,
+			[]string{"invalid RPC URL in RPC_URL_MAPPING segment 1 of 1", "must start with http://, https://, ws:// or wss://"},
+		},
+		{
+			"malformed wallet",
+			"http://a:8545:" + wallet + "|http://b:8545:" + wallet + ",0x1234",
+			[]string{"invalid wallet 2 in RPC_URL_MAPPING segment 2 of 2", "must be 40 hex characters"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRPCMapping(test.mapping)
+			if err == nil {
+				t.Fatalf("ParseRPCMapping(%q) succeeded", test.mapping)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("ParseRPCMapping() error = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// newStubRPC starts a JSON-RPC server answering each method with the raw JSON result in results, or
+// with a method-not-found error.
+func newStubRPC(t testing.TB, results map[string]string) *httptest.Server {
+	return newStubRPCFunc(t, func(method string, _ []json.RawMessage) (string, error) {
+		result, ok := results[method]
+		if !ok {
+			return "", errors.New("method not found")
+		}
+		return result, nil
+	})
+}
+
+// newStubRPCFunc starts a JSON-RPC server answering each call, single or in a batch, with the raw JSON
+// result returned by answer for its method and parameters, or with the error returned.
+func newStubRPCFunc(t testing.TB, answer func(method string, params []json.RawMessage) (string, error)) *httptest.Server {
+	server := httptest.NewServer(stubRPCHandler(answer))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// stubRPCHandler is the handler of newStubRPCFunc.
+func stubRPCHandler(answer func(method string, params []json.RawMessage) (string, error)) http.Handler {
+	type request struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	respond := func(request request) string {
+		result, err := answer(request.Method, request.Params)
+		if err != nil {
+			message, _ := json.Marshal(err.Error())
+			return `{"jsonrpc":"2.0","id":` + string(request.ID) + `,"error":{"code":-32601,"message":` + string(message) + `}}`
+		}
+		return `{"jsonrpc":"2.0","id":` + string(request.ID) + `,"result":` + result + `}`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+
+		var batch []request
+		if err := json.Unmarshal(body, &batch); err == nil {
+			responses := make([]string, len(batch))
+			for i, request := range batch {
+				responses[i] = respond(request)
+			}
+			_, _ = w.Write([]byte("[" + strings.Join(responses, ",") + "]"))
+			return
+		}
+		var single request
+		if err := json.Unmarshal(body, &single); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(respond(single)))
+	})
+}
+
+// TestFetchWalletNullBalance checks that a provider answering a balance query with a null result
+// yields a failed fetch rather than a panic, whether the balance is queried with eth_getBalance or with
+// a balance call.
+func TestFetchWalletNullBalance(t *testing.T) {
+	tests := []struct {
+		name        string
+		balanceCall *BalanceCallConfig
+	}{
+		{"eth_getBalance", nil},
+		{"balance call", &BalanceCallConfig{Contract: "0xcA11bde05977b3631167028862bE2a173976CA11", Method: "balanceOf(address)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newStubRPC(t, map[string]string{
+				"eth_getBalance": "null",
+				"eth_call":       "null",
+				"eth_getCode":    `"0x"`,
+			})
+			client, err := ethclient.Dial(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+			wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+			endpoint := EndpointConfig{Name: "stub", URL: server.URL, Wallets: []WalletConfig{wallet}, BalanceCall: test.balanceCall}
+			c := New([]EndpointConfig{endpoint}, CollectorConfig{})
+
+			result := c.fetchWallet(context.Background(), endpoint, client, wallet, nil)
+			if result.err == nil {
+				t.Fatalf("fetchWallet() exported balance %v for a null result, want an error", result.balance)
+			}
+			if result.balance != 0 {
+				t.Errorf("fetchWallet() = balance %v, want none", result.balance)
+			}
+		})
+	}
+}
+
+// TestReplaceEndpointsUnifiedAssets checks that a reloaded configuration with a token colliding with the
+// native balances in wallet_balance is rejected and the monitored endpoints are kept.
+func TestReplaceEndpointsUnifiedAssets(t *testing.T) {
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	endpoint := EndpointConfig{Name: "stub", URL: "http://localhost:8545", Wallets: []WalletConfig{wallet}}
+	c := New([]EndpointConfig{endpoint}, CollectorConfig{UnifiedBalanceMetric: true})
+
+	reloaded := endpoint
+	reloaded.Tokens = []TokenConfig{{Symbol: nativeAsset, Address: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"}}
+	err := c.ReplaceEndpoints([]EndpointConfig{reloaded})
+	if err == nil || !strings.Contains(err.Error(), "cannot be exported in wallet_balance") {
+		t.Fatalf("ReplaceEndpoints() error = %v, want a collision with the native asset", err)
+	}
+	if endpoints := c.getEndpoints(); len(endpoints) != 1 || len(endpoints[0].Tokens) != 0 {
+		t.Errorf("ReplaceEndpoints() swapped in the rejected endpoints: %+v", endpoints)
+	}
+}
+
+// TestLogEndpointErrorSampling checks that errors of a provider are sampled by their reason, whatever
+// their message, and separately for each provider and reason.
+func TestLogEndpointErrorSampling(t *testing.T) {
+	var output strings.Builder
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	c := New(nil, CollectorConfig{LogSampleWindow: time.Hour})
+	alchemy := EndpointConfig{Name: "alchemy"}
+	infura := EndpointConfig{Name: "infura"}
+	format := "Error retrieving balance from provider %s: %v"
+	c.logEndpointError(alchemy, errors.New("dial tcp 10.0.0.1:443: connect: connection refused"), format, alchemy.Name, "refused")
+	c.logEndpointError(alchemy, errors.New("dial tcp 10.0.0.2:443: connect: connection reset by peer"), format, alchemy.Name, "reset")
+	c.logEndpointError(alchemy, context.DeadlineExceeded, format, alchemy.Name, "timeout")
+	c.logEndpointError(infura, errors.New("dial tcp 10.0.0.3:443: connect: connection refused"), format, infura.Name, "refused")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("logged %d messages, want 3:\n%s", len(lines), output.String())
+	}
+	for i, want := range []string{"alchemy: refused", "alchemy: timeout", "infura: refused"} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("message %d = %q, want it to end with %q", i, lines[i], want)
+		}
+	}
+}
+
+// TestFetchWalletNativeDecimals checks that balances of a native token with native_decimals other than
+// 18 are divided by its own decimals, so that 1e8 base units of an 8-decimal token are 1.0.
+func TestFetchWalletNativeDecimals(t *testing.T) {
+	tests := []struct {
+		balance string
+		want    float64
+	}{
+		{`"0x5f5e100"`, 1},
+		{`"0x8f0d180"`, 1.5},
+		{`"0x1"`, 1e-8},
+	}
+	for _, test := range tests {
+		t.Run(test.balance, func(t *testing.T) {
+			server := newStubRPC(t, map[string]string{"eth_getBalance": test.balance})
+			client, err := ethclient.Dial(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			decimals := uint8(8)
+			address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+			wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+			endpoint := EndpointConfig{Name: "stub", URL: server.URL, Wallets: []WalletConfig{wallet}, NativeDecimals: &decimals}
+			c := New([]EndpointConfig{endpoint}, CollectorConfig{})
+
+			result := c.fetchWallet(context.Background(), endpoint, client, wallet, nil)
+			if result.err != nil {
+				t.Fatalf("fetchWallet() error = %v", result.err)
+			}
+			if result.balance != test.want {
+				t.Errorf("fetchWallet() = balance %v, want %v", result.balance, test.want)
+			}
+		})
+	}
+}
+
+// TestParseConfigRoutesByExpectedChainID checks that top-level EIP-3770 wallets are routed to endpoints
+// whose chain is set only by expected_chain_id.
+func TestParseConfigRoutesByExpectedChainID(t *testing.T) {
+	config := `
+endpoints:
+  - name: mainnet
+    url: http://localhost:8545
+    expected_chain_id: "1"
+  - name: optimism
+    url: http://localhost:9545
+    chain: "10"
+wallets:
+  - eth:0x742d35Cc6634C0532925a3b844Bc454e4438f44e
+  - oeth:0x742d35Cc6634C0532925a3b844Bc454e4438f44e
+`
+	endpoints, err := parseConfig([]byte(config), "test config")
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+	for _, endpoint := range endpoints {
+		if len(endpoint.Wallets) != 1 || endpoint.Wallets[0].Address != "0x742d35Cc6634C0532925a3b844Bc454e4438f44e" {
+			t.Errorf("endpoint %s has wallets %+v, want the routed wallet", endpoint.Name, endpoint.Wallets)
+		}
+	}
+	if endpoints[0].Chain != "1" {
+		t.Errorf("endpoint mainnet has chain %q, want 1 from expected_chain_id", endpoints[0].Chain)
+	}
+}
+
+// BenchmarkCollect measures a collection of 512 wallets from a stub provider, whose round trips are
+// included, so the per-wallet allocations are best compared with -benchmem.
+func BenchmarkCollect(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	server := newStubRPC(b, map[string]string{
+		"eth_chainId":    `"0x1"`,
+		"eth_getBalance": `"0x112210f47de98115"`,
+		"eth_getCode":    `"0x"`,
+	})
+	wallets := make([]string, 512)
+	for i := range wallets {
+		wallets[i] = fmt.Sprintf("0x%040x", i+1)
+	}
+	mapping, err := ParseRPCMapping(server.URL + ":" + strings.Join(wallets, ","))
+	if err != nil {
+		b.Fatal(err)
+	}
+	endpoints := EndpointsFromMapping(mapping)
+	if err := AssignProviderNames(endpoints); err != nil {
+		b.Fatal(err)
+	}
+	c := New(endpoints, CollectorConfig{})
+
+	b.ReportAllocs()
+	for b.Loop() {
+		ch := make(chan prometheus.Metric, 4*len(wallets))
+		go func() {
+			c.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+}
+
+// TestRPCCallsCountBatchElements checks that rpc_calls_total counts each call of a batch.
+func TestRPCCallsCountBatchElements(t *testing.T) {
+	server := newStubRPC(t, map[string]string{
+		"eth_chainId":    `"0x1"`,
+		"eth_getBalance": `"0x1"`,
+	})
+	endpoint := EndpointConfig{Name: "stub", URL: server.URL}
+	c := New([]EndpointConfig{endpoint}, CollectorConfig{})
+	client, err := c.getClient(context.Background(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.ChainID(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	batch := make([]rpc.BatchElem, 3)
+	for i := range batch {
+		batch[i] = rpc.BatchElem{Method: "eth_getBalance", Args: []any{common.Address{}, "latest"}, Result: new(hexutil.Big)}
+	}
+	if err := client.Client().BatchCallContext(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls dto.Metric
+	if err := c.rpcCalls.WithLabelValues(endpoint.Name).Write(&calls); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.GetCounter().GetValue(); got != 4 {
+		t.Errorf("rpc_calls_total = %v, want 4", got)
+	}
+}
+
+// TestRPCDurationByMethod checks that rpc_request_duration_seconds records eth_getBalance and eth_chainId
+// calls in separate series, including the calls of a batch mixing them.
+func TestRPCDurationByMethod(t *testing.T) {
+	server := newStubRPC(t, map[string]string{
+		"eth_chainId":    `"0x1"`,
+		"eth_getBalance": `"0x1"`,
+	})
+	endpoint := EndpointConfig{Name: "stub", URL: server.URL}
+	c := New([]EndpointConfig{endpoint}, CollectorConfig{})
+	client, err := c.getClient(context.Background(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.ChainID(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.BalanceAt(context.Background(), common.Address{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	batch := []rpc.BatchElem{
+		{Method: "eth_getBalance", Args: []any{common.Address{}, "latest"}, Result: new(hexutil.Big)},
+		{Method: "eth_getBalance", Args: []any{common.Address{}, "latest"}, Result: new(hexutil.Big)},
+		{Method: "eth_chainId", Result: new(hexutil.Big)},
+	}
+	if err := client.Client().BatchCallContext(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	for method, want := range map[string]uint64{"eth_chainId": 2, "eth_getBalance": 2, "batch": 0} {
+		var duration dto.Metric
+		if err := c.rpcDuration.WithLabelValues(endpoint.Name, method).(prometheus.Histogram).Write(&duration); err != nil {
+			t.Fatal(err)
+		}
+		if got := duration.GetHistogram().GetSampleCount(); got != want {
+			t.Errorf("rpc_request_duration_seconds{method=%q} has %d samples, want %d", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseConcurrency parses the global limit of concurrent wallet fetches. An empty value returns zero,
+// which leaves fetches limited only per endpoint.
+func ParseConcurrency(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	concurrency, err := strconv.Atoi(value)
+	if err != nil || concurrency < 1 {
+		return 0, fmt.Errorf("invalid concurrency: %s (must be an integer of at least 1)", value)
+	}
+	return concurrency, nil
+}
+
+// endpointSemaphore returns the semaphore limiting concurrent wallet fetches on the endpoint's URL,
+// creating it on first use.
+func (c *WalletBalanceCollector) endpointSemaphore(endpoint EndpointConfig) chan struct{} {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	semaphore, exists := c.semaphores[endpoint.URL]
+	if !exists {
+		semaphore = make(chan struct{}, max(endpoint.Concurrency, 1))
+		c.semaphores[endpoint.URL] = semaphore
+	}
+	return semaphore
+}
+
+// acquire takes a slot of the endpoint semaphore and, if configured, of the global one. It returns
+// false without holding any slot if ctx is done first.
+func (c *WalletBalanceCollector) acquire(ctx context.Context, semaphore chan struct{}) bool {
+	select {
+	case semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	if c.concurrency == nil {
+		return true
+	}
+
+	select {
+	case c.concurrency <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		<-semaphore
+		return false
+	}
+}
+
+// release returns the slots taken by acquire.
+func (c *WalletBalanceCollector) release(semaphore chan struct{}) {
+	if c.concurrency != nil {
+		<-c.concurrency
+	}
+	<-semaphore
+}
@@ -0,0 +1,372 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.yaml.in/yaml/v2"
+)
+
+// EndpointConfig describes an RPC endpoint and the wallets monitored through it.
+type EndpointConfig struct {
+	// Name identifies the endpoint in logs and in the provider label of endpoint-level metrics,
+	// so that the URL, which often embeds an API key, is never exposed.
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Chain is the chain_id label used when the chain ID cannot be queried from the endpoint.
+	Chain string `yaml:"chain"`
+	// SkipChainID disables the eth_chainId query for proxies that do not support it.
+	SkipChainID bool `yaml:"skip_chain_id"`
+	// ExpectedChainID is the chain ID the endpoint must report. If it reports another one, its balances
+	// are not exported. Empty disables the check.
+	ExpectedChainID string `yaml:"expected_chain_id"`
+	// Concurrency is the number of wallets fetched from the endpoint at a time. Zero selects 1.
+	Concurrency int `yaml:"concurrency"`
+	// BatchSize sends the wallets' balance queries as JSON-RPC batches of up to this many calls. Zero
+	// queries every wallet with its own request.
+	BatchSize int            `yaml:"batch_size"`
+	Wallets   []WalletConfig `yaml:"wallets"`
+	// BalanceCall resolves balances with a contract call instead of the account balance.
+	BalanceCall *BalanceCallConfig `yaml:"balance_call"`
+	// VerifyProofs fetches balances with eth_getProof and verifies them against the state root of
+	// their block before exporting them.
+	VerifyProofs bool `yaml:"verify_proofs"`
+	// XPubs are extended public keys whose derived addresses are monitored in addition to Wallets.
+	XPubs []XPubConfig `yaml:"xpubs"`
+	// Tokens are ERC-20 tokens whose balances are monitored for every wallet.
+	Tokens []TokenConfig `yaml:"tokens"`
+	// NFTs are ERC-721 and ERC-1155 collections whose tokens held by every wallet are counted.
+	NFTs []NFTConfig `yaml:"nfts"`
+	// Multicall overrides the registry's Multicall3 address used to batch token and NFT balance queries.
+	Multicall string `yaml:"multicall"`
+	// Labels are static labels, such as region or tier, added to the endpoint's metrics.
+	Labels map[string]string `yaml:"labels"`
+	// Confirmations reads balances this many blocks behind the chain head, so that they are not
+	// affected by reorgs. Zero reads the latest block.
+	Confirmations uint64 `yaml:"confirmations"`
+	// Aggregate exports the sum of the wallets' balances per group instead of a series per wallet.
+	Aggregate bool `yaml:"aggregate"`
+	// CrossCheck names a group of endpoints on the same chain whose balances of shared wallets are
+	// compared, exporting each wallet's agreed balance once. Empty exports the endpoint's own balances.
+	CrossCheck string `yaml:"cross_check"`
+	// NativeDecimals is the number of decimals of the chain's native token, by which the balances
+	// returned by the provider are divided. Nil selects 18, as for ETH.
+	NativeDecimals *uint8 `yaml:"native_decimals"`
+	// PriceID is the coin ID of the chain's native token at the price source, such as ethereum. Empty
+	// selects the built-in one for the chain, if any.
+	PriceID string `yaml:"price_id"`
+
+	// urlTemplate is URL as configured, with the Vault placeholders that were replaced in URL.
+	urlTemplate string
+}
+
+// nativeDecimals returns the number of decimals of the endpoint's native token.
+func (e EndpointConfig) nativeDecimals() uint8 {
+	if e.NativeDecimals == nil {
+		return 18
+	}
+	return *e.NativeDecimals
+}
+
+// XPubConfig describes a range of addresses derived from a BIP-32 extended public key.
+type XPubConfig struct {
+	XPub string `yaml:"xpub"`
+	// Start is the first derivation index and Count the number of addresses derived.
+	Start uint32 `yaml:"start"`
+	Count uint32 `yaml:"count"`
+	// Name is an optional human-readable name given to every derived wallet.
+	Name string `yaml:"name"`
+	// Group is the group every derived wallet is summed into on aggregated endpoints.
+	Group string `yaml:"group"`
+	// Tags are given to every derived wallet.
+	Tags map[string]string `yaml:"tags"`
+	// RefreshInterval is the refresh interval of every derived wallet.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// WalletConfig describes a monitored wallet.
+type WalletConfig struct {
+	Address string `yaml:"address"`
+	// ENS is an ENS name, such as vitalik.eth, monitored at the address it resolves to instead of a
+	// fixed Address. The name is resolved again every ENS_INTERVAL.
+	ENS string `yaml:"ens"`
+	// Name is an optional human-readable name for the wallet.
+	Name string `yaml:"name"`
+	// Group is the group the wallet's balance is summed into on aggregated endpoints.
+	Group string `yaml:"group"`
+	// Factor scales the wallet's balance in ETH, e.g. to count a wrapped asset at a ratio. Zero
+	// selects 1, leaving the balance unscaled.
+	Factor float64 `yaml:"factor"`
+	// TokenThresholds maps token symbols of the endpoint's tokens to the minimum balance in whole
+	// tokens the wallet should hold. Falling below it is exported as wallet_token_below_threshold.
+	TokenThresholds map[string]float64 `yaml:"token_thresholds"`
+	// Tags are arbitrary key=value pairs, each exported as a label of the wallet's metrics.
+	Tags map[string]string `yaml:"tags"`
+	// Target is the balance in ETH the wallet should hold. The balance relative to it is exported as
+	// wallet_balance_ratio. Zero exports no ratio.
+	Target float64 `yaml:"target"`
+	// RefreshInterval is how often background refreshes fetch the wallet's balances, such as 30s for a
+	// hot wallet or 6h for a cold one. Zero selects REFRESH_INTERVAL.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+	// Derived is set for wallets derived from an xpub, at DerivationIndex below it.
+	Derived         bool   `yaml:"-"`
+	DerivationIndex uint32 `yaml:"-"`
+
+	// parsed is Address, parsed when the configuration is loaded so that it is not parsed again for
+	// every query.
+	parsed common.Address
+}
+
+// hexAddress returns the wallet's address, parsed when the configuration was loaded or else now.
+func (w WalletConfig) hexAddress() common.Address {
+	if w.parsed == (common.Address{}) {
+		return common.HexToAddress(w.Address)
+	}
+	return w.parsed
+}
+
+// derivationLabel returns the derivation_index label value: the derivation index of a derived
+// wallet, or empty for a configured one.
+func (w WalletConfig) derivationLabel() string {
+	if !w.Derived {
+		return ""
+	}
+	return strconv.FormatUint(uint64(w.DerivationIndex), 10)
+}
+
+// factor returns the multiplier applied to the wallet's balance.
+func (w WalletConfig) factor() float64 {
+	if w.Factor == 0 {
+		return 1
+	}
+	return w.Factor
+}
+
+// UnmarshalYAML accepts either a bare address or a mapping with address and name.
+func (w *WalletConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&w.Address); err == nil {
+		return nil
+	}
+
+	type plain WalletConfig
+	return unmarshal((*plain)(w))
+}
+
+// fileConfig is the layout of the file referenced by CONFIG_FILE.
+type fileConfig struct {
+	Endpoints []EndpointConfig `yaml:"endpoints"`
+	// Wallets are EIP-3770 addresses, such as eth:0xabc, monitored on every endpoint of their chain.
+	Wallets []WalletConfig `yaml:"wallets"`
+	// Relabel are rules that keep or drop exported series, loaded by LoadRelabelRules.
+	Relabel []RelabelRule `yaml:"relabel"`
+}
+
+// LoadConfigFile reads the endpoint configuration from a YAML file.
+func LoadConfigFile(path string) ([]EndpointConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data, "config file "+path)
+}
+
+// parseConfig parses and validates an endpoint configuration in the CONFIG_FILE format. The source
+// describes where the configuration was read from in errors.
+func parseConfig(data []byte, source string) ([]EndpointConfig, error) {
+	var config fileConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", source, err)
+	}
+
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("invalid %s: no endpoints configured", source)
+	}
+	// The expected chain ID is also the chain_id label, and so the chain that top-level wallets are
+	// routed by
+	for i, endpoint := range config.Endpoints {
+		if endpoint.ExpectedChainID == "" {
+			continue
+		}
+		chainID, ok := new(big.Int).SetString(endpoint.ExpectedChainID, 10)
+		if !ok || chainID.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid expected_chain_id for endpoint %d: %s (must be a positive integer)", i, endpoint.ExpectedChainID)
+		}
+		if endpoint.SkipChainID {
+			return nil, fmt.Errorf("invalid config for endpoint %d: expected_chain_id cannot be combined with skip_chain_id", i)
+		}
+		if endpoint.Chain != "" && endpoint.Chain != chainID.String() {
+			return nil, fmt.Errorf("invalid config for endpoint %d: chain %s differs from expected_chain_id %s", i, endpoint.Chain, chainID)
+		}
+		config.Endpoints[i].ExpectedChainID, config.Endpoints[i].Chain = chainID.String(), chainID.String()
+	}
+	if err := routeChainWallets(config.Endpoints, config.Wallets); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", source, err)
+	}
+
+	var err error
+
+	for i, endpoint := range config.Endpoints {
+		if !isRPCURL(endpoint.URL) {
+			return nil, fmt.Errorf("invalid RPC URL for endpoint %d: %s (must start with http://, https://, ws:// or wss://)", i, endpoint.URL)
+		}
+		if endpoint.BalanceCall != nil {
+			if err := endpoint.BalanceCall.validate(); err != nil {
+				return nil, fmt.Errorf("invalid config for endpoint %d: %v", i, err)
+			}
+		}
+		for j := range endpoint.Tokens {
+			if err := config.Endpoints[i].Tokens[j].validate(); err != nil {
+				return nil, fmt.Errorf("invalid config for endpoint %d: %v", i, err)
+			}
+		}
+		if err := validateNFTs(config.Endpoints[i].NFTs); err != nil {
+			return nil, fmt.Errorf("invalid config for endpoint %d: %v", i, err)
+		}
+		if endpoint.Multicall != "" {
+			if config.Endpoints[i].Multicall, err = NormalizeAddress(endpoint.Multicall); err != nil {
+				return nil, fmt.Errorf("invalid multicall for endpoint %d: %v", i, err)
+			}
+		}
+		if err := validateEndpointLabels(endpoint.Labels); err != nil {
+			return nil, fmt.Errorf("invalid labels for endpoint %d: %v", i, err)
+		}
+		if endpoint.CrossCheck != "" && endpoint.Aggregate {
+			return nil, fmt.Errorf("invalid config for endpoint %d: cross_check cannot be combined with aggregate", i)
+		}
+		if endpoint.Concurrency < 0 {
+			return nil, fmt.Errorf("invalid concurrency for endpoint %d: %d (must be at least 1)", i, endpoint.Concurrency)
+		}
+		if endpoint.BatchSize < 0 {
+			return nil, fmt.Errorf("invalid batch_size for endpoint %d: %d (must be at least 1)", i, endpoint.BatchSize)
+		}
+		if endpoint.BatchSize > 0 && endpoint.BalanceCall != nil {
+			return nil, fmt.Errorf("invalid config for endpoint %d: batch_size cannot be combined with balance_call", i)
+		}
+		if endpoint.VerifyProofs && (endpoint.BatchSize > 0 || endpoint.BalanceCall != nil) {
+			return nil, fmt.Errorf("invalid config for endpoint %d: verify_proofs cannot be combined with batch_size or balance_call", i)
+		}
+		if len(endpoint.Wallets) == 0 && len(endpoint.XPubs) == 0 {
+			return nil, fmt.Errorf("invalid config for endpoint %d: no wallets or xpubs configured", i)
+		}
+		for j, wallet := range endpoint.Wallets {
+			if wallet.ENS != "" {
+				if wallet.Address != "" {
+					return nil, fmt.Errorf("invalid config for endpoint %d: wallet %s has both an address and an ENS name", i, wallet.ENS)
+				}
+				if config.Endpoints[i].Wallets[j].ENS, err = normalizeENSName(wallet.ENS); err != nil {
+					return nil, fmt.Errorf("invalid config for endpoint %d: %v", i, err)
+				}
+				// Identify the wallet by its name in the errors below
+				wallet.Address = wallet.ENS
+			} else {
+				chainID, address, err := splitChainAddress(wallet.Address)
+				if err != nil {
+					return nil, fmt.Errorf("invalid config for endpoint %d: %v", i, err)
+				}
+				if chainID != "" && endpoint.Chain != "" && chainID != endpoint.Chain {
+					return nil, fmt.Errorf("invalid config for endpoint %d: wallet %s is on chain %s, not on the endpoint's chain %s", i, wallet.Address, chainID, endpoint.Chain)
+				}
+				if config.Endpoints[i].Wallets[j].Address, err = NormalizeAddress(address); err != nil {
+					return nil, fmt.Errorf("invalid config for endpoint %d: %v", i, err)
+				}
+				config.Endpoints[i].Wallets[j].parsed = common.HexToAddress(address)
+			}
+			if wallet.Factor < 0 || math.IsInf(wallet.Factor, 0) || math.IsNaN(wallet.Factor) {
+				return nil, fmt.Errorf("invalid factor of wallet %s for endpoint %d: %v (must not be negative)", wallet.Address, i, wallet.Factor)
+			}
+			if wallet.Target < 0 || math.IsInf(wallet.Target, 0) || math.IsNaN(wallet.Target) {
+				return nil, fmt.Errorf("invalid target of wallet %s for endpoint %d: %v (must not be negative)", wallet.Address, i, wallet.Target)
+			}
+			if wallet.RefreshInterval < 0 {
+				return nil, fmt.Errorf("invalid refresh interval of wallet %s for endpoint %d: %v (must not be negative)", wallet.Address, i, wallet.RefreshInterval)
+			}
+			if err := config.Endpoints[i].Wallets[j].validateTokenThresholds(config.Endpoints[i].Tokens); err != nil {
+				return nil, fmt.Errorf("invalid token thresholds of wallet %s for endpoint %d: %v", wallet.Address, i, err)
+			}
+			if err := validateWalletTags(wallet.Tags); err != nil {
+				return nil, fmt.Errorf("invalid tags of wallet %s for endpoint %d: %v", wallet.Address, i, err)
+			}
+		}
+		for j, xpub := range endpoint.XPubs {
+			if err := validateWalletTags(xpub.Tags); err != nil {
+				return nil, fmt.Errorf("invalid tags of xpub %d for endpoint %d: %v", j+1, i, err)
+			}
+			if xpub.RefreshInterval < 0 {
+				return nil, fmt.Errorf("invalid refresh interval of xpub %d for endpoint %d: %v (must not be negative)", j+1, i, xpub.RefreshInterval)
+			}
+			wallets, err := deriveWallets(xpub)
+			if err != nil {
+				return nil, fmt.Errorf("invalid xpub %d for endpoint %d: %v", j+1, i, err)
+			}
+			config.Endpoints[i].Wallets = append(config.Endpoints[i].Wallets, wallets...)
+		}
+	}
+
+	return config.Endpoints, nil
+}
+
+// EndpointsFromMapping converts a parsed RPC_URL_MAPPING into endpoint configs, ordered by URL.
+func EndpointsFromMapping(rpcWalletMapping map[string][]WalletConfig) []EndpointConfig {
+	endpoints := make([]EndpointConfig, 0, len(rpcWalletMapping))
+	for rpcURL, wallets := range rpcWalletMapping {
+		endpoints = append(endpoints, EndpointConfig{URL: rpcURL, Wallets: wallets})
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].URL < endpoints[j].URL
+	})
+	return endpoints
+}
+
+// AssignProviderNames fills in missing endpoint names and rejects duplicates. An unnamed endpoint is
+// named after the host of its URL, with a numeric suffix when several endpoints share a host.
+func AssignProviderNames(endpoints []EndpointConfig) error {
+	seen := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		if endpoint.Name == "" {
+			continue
+		}
+		if seen[endpoint.Name] {
+			return fmt.Errorf("duplicate endpoint name: %s", endpoint.Name)
+		}
+		seen[endpoint.Name] = true
+	}
+
+	for i := range endpoints {
+		if endpoints[i].Name != "" {
+			continue
+		}
+
+		parsed, err := url.Parse(endpoints[i].URL)
+		if err != nil {
+			return fmt.Errorf("invalid RPC URL for endpoint %d: %v", i, err)
+		}
+
+		name := parsed.Host
+		for suffix := 2; seen[name]; suffix++ {
+			name = fmt.Sprintf("%s-%d", parsed.Host, suffix)
+		}
+		endpoints[i].Name = name
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// redactURL reduces an RPC URL to its scheme and host, dropping credentials, paths and query
+// parameters that commonly carry API keys.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "redacted"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,243 @@
+package collector
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// configView is the running configuration served at /config. RPC URLs are reduced to their scheme and
+// host, and the proxy settings are omitted, so that no credentials are exposed.
+type configView struct {
+	Endpoints []endpointView `json:"endpoints"`
+	Collector collectorView  `json:"collector"`
+}
+
+// endpointView is an endpoint of the configuration served at /config.
+type endpointView struct {
+	Name          string             `json:"name"`
+	URL           string             `json:"url"`
+	Chain         string             `json:"chain,omitempty"`
+	SkipChainID   bool               `json:"skip_chain_id"`
+	Expected      string             `json:"expected_chain_id,omitempty"`
+	Concurrency   int                `json:"concurrency"`
+	BatchSize     int                `json:"batch_size,omitempty"`
+	VerifyProofs  bool               `json:"verify_proofs"`
+	Aggregate     bool               `json:"aggregate"`
+	CrossCheck    string             `json:"cross_check,omitempty"`
+	PriceID       string             `json:"price_id,omitempty"`
+	Decimals      uint8              `json:"native_decimals"`
+	Confirmations uint64             `json:"confirmations"`
+	Labels        map[string]string  `json:"labels,omitempty"`
+	BalanceCall   *BalanceCallConfig `json:"balance_call,omitempty"`
+	Tokens        []tokenView        `json:"tokens,omitempty"`
+	NFTs          []nftView          `json:"nfts,omitempty"`
+	Multicall     string             `json:"multicall,omitempty"`
+	Wallets       []walletView       `json:"wallets"`
+}
+
+// nftView is a configured NFT collection. TokenID is omitted for ERC-721 collections.
+type nftView struct {
+	Collection string `json:"collection"`
+	Address    string `json:"address"`
+	TokenID    string `json:"token_id,omitempty"`
+}
+
+// tokenView is a configured token. Decimals is omitted for tokens that use the registry's.
+type tokenView struct {
+	Symbol   string `json:"symbol"`
+	Address  string `json:"address,omitempty"`
+	Decimals *uint8 `json:"decimals,omitempty"`
+}
+
+// walletView is a monitored wallet, including wallets derived from xpubs and added through the admin API.
+type walletView struct {
+	Address         string             `json:"address"`
+	ENS             string             `json:"ens,omitempty"`
+	Name            string             `json:"name,omitempty"`
+	Group           string             `json:"group,omitempty"`
+	Factor          float64            `json:"factor"`
+	Target          float64            `json:"target,omitempty"`
+	TokenThresholds map[string]float64 `json:"token_thresholds,omitempty"`
+	DerivationIndex *uint32            `json:"derivation_index,omitempty"`
+	Tags            map[string]string  `json:"tags,omitempty"`
+	RefreshInterval string             `json:"refresh_interval,omitempty"`
+}
+
+// collectorView is the CollectorConfig, with durations as strings such as 30s and defaults applied.
+type collectorView struct {
+	StaleBehavior     StaleBehavior     `json:"stale_behavior"`
+	Duplicates        DuplicateWallets  `json:"duplicate_wallets"`
+	AddressCase       AddressCase       `json:"label_address_case"`
+	Precision         uint              `json:"precision"`
+	RoundingMode      string            `json:"rounding_mode"`
+	CollectTimeout    string            `json:"collect_timeout"`
+	RPCTimeout        string            `json:"rpc_timeout"`
+	LockTimeout       string            `json:"lock_timeout"`
+	RetryAttempts     int               `json:"retry_attempts"`
+	RetryBaseDelay    string            `json:"retry_base_delay"`
+	CircuitFailures   int               `json:"circuit_breaker_failures"`
+	CircuitCooldown   string            `json:"circuit_breaker_cooldown"`
+	StartupAttempts   int               `json:"startup_attempts"`
+	StartupDelay      string            `json:"startup_retry_delay"`
+	RefreshInterval   string            `json:"refresh_interval"`
+	RefreshJitter     string            `json:"refresh_jitter"`
+	Timestamps        TimestampMode     `json:"balance_timestamps"`
+	MaxBalanceAge     string            `json:"max_balance_age"`
+	LogSampleWindow   string            `json:"log_sample_window"`
+	ChainIDTTL        string            `json:"chain_id_ttl"`
+	ChainIDChange     ChainIDChange     `json:"chain_id_change"`
+	DNSCacheTTL       string            `json:"dns_cache_ttl"`
+	NamingServiceURL  string            `json:"naming_service_url,omitempty"`
+	FiatCurrencies    []string          `json:"fiat_currencies,omitempty"`
+	PriceURL          string            `json:"price_url,omitempty"`
+	EtherscanURL      string            `json:"etherscan_url,omitempty"`
+	VaultPath         string            `json:"vault_secret_path,omitempty"`
+	MaxConcurrency    int               `json:"max_concurrency"`
+	TotalAcrossChains bool              `json:"total_across_chains"`
+	FetchDuration     bool              `json:"wallet_fetch_duration"`
+	UnifiedMetric     bool              `json:"unified_balance_metric"`
+	Tolerance         float64           `json:"cross_check_tolerance"`
+	HideZeroBalances  bool              `json:"hide_zero_balances"`
+	EmitOnChange      bool              `json:"emit_on_change"`
+	GweiMetric        bool              `json:"balance_gwei"`
+	DisplayDecimals   int               `json:"display_decimals"`
+	DurationBuckets   []float64         `json:"duration_buckets"`
+	LabelTemplates    map[string]string `json:"label_templates,omitempty"`
+	Relabel           []RelabelRule     `json:"relabel,omitempty"`
+	ErrorReasons      []ErrorReason     `json:"error_reasons,omitempty"`
+	GraphiteAddress   string            `json:"graphite_address,omitempty"`
+	GraphitePrefix    string            `json:"graphite_prefix"`
+}
+
+// runningConfig returns the configuration the collector is running with.
+func (c *WalletBalanceCollector) runningConfig() configView {
+	view := configView{Endpoints: []endpointView{}}
+	for _, endpoint := range c.getEndpoints() {
+		endpointView := endpointView{
+			Name:          endpoint.Name,
+			URL:           redactURL(endpoint.URL),
+			Chain:         endpoint.Chain,
+			SkipChainID:   endpoint.SkipChainID,
+			Expected:      endpoint.ExpectedChainID,
+			Concurrency:   max(endpoint.Concurrency, 1),
+			BatchSize:     endpoint.BatchSize,
+			VerifyProofs:  endpoint.VerifyProofs,
+			Aggregate:     endpoint.Aggregate,
+			CrossCheck:    endpoint.CrossCheck,
+			PriceID:       endpoint.PriceID,
+			Decimals:      endpoint.nativeDecimals(),
+			Confirmations: endpoint.Confirmations,
+			Labels:        endpoint.Labels,
+			BalanceCall:   endpoint.BalanceCall,
+			Multicall:     endpoint.Multicall,
+			Wallets:       []walletView{},
+		}
+		for _, token := range endpoint.Tokens {
+			endpointView.Tokens = append(endpointView.Tokens, tokenView(token))
+		}
+		for _, nft := range endpoint.NFTs {
+			endpointView.NFTs = append(endpointView.NFTs, nftView(nft))
+		}
+		for _, wallet := range endpoint.Wallets {
+			walletView := walletView{
+				Address:         wallet.Address,
+				ENS:             wallet.ENS,
+				Name:            wallet.Name,
+				Group:           wallet.Group,
+				Factor:          wallet.factor(),
+				Target:          wallet.Target,
+				TokenThresholds: wallet.TokenThresholds,
+				Tags:            wallet.Tags,
+			}
+			if wallet.Derived {
+				walletView.DerivationIndex = &wallet.DerivationIndex
+			}
+			if wallet.RefreshInterval > 0 {
+				walletView.RefreshInterval = wallet.RefreshInterval.String()
+			}
+			endpointView.Wallets = append(endpointView.Wallets, walletView)
+		}
+		view.Endpoints = append(view.Endpoints, endpointView)
+	}
+
+	config := c.config
+	view.Collector = collectorView{
+		StaleBehavior:     config.StaleBehavior,
+		Duplicates:        config.DuplicateWallets,
+		AddressCase:       config.AddressCase,
+		Precision:         config.Precision,
+		RoundingMode:      config.RoundingMode.String(),
+		CollectTimeout:    config.CollectTimeout.String(),
+		RPCTimeout:        config.RPCTimeout.String(),
+		LockTimeout:       config.LockTimeout.String(),
+		RetryAttempts:     max(config.RetryAttempts, 1),
+		RetryBaseDelay:    config.RetryBaseDelay.String(),
+		CircuitFailures:   config.CircuitFailures,
+		CircuitCooldown:   config.CircuitCooldown.String(),
+		StartupAttempts:   max(config.StartupAttempts, 1),
+		StartupDelay:      config.StartupRetryDelay.String(),
+		RefreshInterval:   config.RefreshInterval.String(),
+		RefreshJitter:     config.RefreshJitter.String(),
+		Timestamps:        config.Timestamps,
+		MaxBalanceAge:     config.MaxBalanceAge.String(),
+		LogSampleWindow:   config.LogSampleWindow.String(),
+		ChainIDTTL:        config.ChainIDTTL.String(),
+		ChainIDChange:     config.ChainIDChange,
+		DNSCacheTTL:       config.DNSCacheTTL.String(),
+		MaxConcurrency:    config.MaxConcurrency,
+		TotalAcrossChains: config.TotalAcrossChains,
+		FetchDuration:     config.WalletFetchDuration,
+		UnifiedMetric:     config.UnifiedBalanceMetric,
+		Tolerance:         config.CrossCheckTolerance,
+		HideZeroBalances:  config.HideZeroBalances,
+		EmitOnChange:      config.EmitOnChange,
+		GweiMetric:        config.GweiMetric,
+		DisplayDecimals:   config.DisplayDecimals,
+		DurationBuckets:   config.DurationBuckets,
+		Relabel:           config.Relabel,
+		ErrorReasons:      config.ErrorReasons,
+		GraphiteAddress:   config.GraphiteAddress,
+		GraphitePrefix:    config.GraphitePrefix,
+	}
+	if config.NamingServiceURL != "" {
+		view.Collector.NamingServiceURL = redactURL(config.NamingServiceURL)
+	}
+	if len(config.FiatCurrencies) > 0 {
+		view.Collector.FiatCurrencies = config.FiatCurrencies
+		view.Collector.PriceURL = redactURL(config.PriceURL)
+	}
+	if config.EtherscanURL != "" {
+		view.Collector.EtherscanURL = redactURL(config.EtherscanURL)
+	}
+	if config.Vault != nil {
+		view.Collector.VaultPath = config.Vault.path
+	}
+	for _, label := range config.LabelTemplates {
+		if view.Collector.LabelTemplates == nil {
+			view.Collector.LabelTemplates = make(map[string]string)
+		}
+		view.Collector.LabelTemplates[label.Name] = label.Template.Root.String()
+	}
+	return view
+}
+
+// ConfigHandler serves the running configuration as JSON at /config, so that deployments can verify
+// what the exporter loaded. It requires the token as a bearer token in the Authorization header.
+func (c *WalletBalanceCollector) ConfigHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c.runningConfig()); err != nil {
+			log.Printf("Error writing config: %v", err)
+		}
+	})
+}
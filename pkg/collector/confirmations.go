@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// confirmedBlock returns the block the endpoint's balances are read at, its configured number of
+// confirmations behind the chain head, and records it for balance_block_number.
+func (c *WalletBalanceCollector) confirmedBlock(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client) (*big.Int, error) {
+	ctx, span := startRPCSpan(ctx, "eth_blockNumber", endpoint)
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("block", int64(head)))
+	endSpan(span, nil)
+
+	if head < endpoint.Confirmations {
+		return nil, fmt.Errorf("chain head %d is below the %d confirmations", head, endpoint.Confirmations)
+	}
+	block := head - endpoint.Confirmations
+
+	c.cacheMutex.Lock()
+	c.confirmedBlocks[endpoint.Name] = block
+	c.cacheMutex.Unlock()
+	return new(big.Int).SetUint64(block), nil
+}
+
+// collectConfirmedBlocks emits the block that the balances of each endpoint with confirmations were
+// last read at.
+func (c *WalletBalanceCollector) collectConfirmedBlocks(ch chan<- prometheus.Metric, endpoints []EndpointConfig) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	for _, endpoint := range endpoints {
+		if block, ok := c.confirmedBlocks[endpoint.Name]; ok && endpoint.Confirmations > 0 {
+			ch <- prometheus.MustNewConstMetric(c.blockMetric, prometheus.GaugeValue, float64(block), c.endpointLabelValues(endpoint, endpoint.Name)...)
+		}
+	}
+}
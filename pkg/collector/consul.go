@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// consulTimeout bounds each request to Consul.
+const consulTimeout = 30 * time.Second
+
+// DefaultConsulAddress is the address of the local Consul agent, used when CONSUL_HTTP_ADDR is not set.
+const DefaultConsulAddress = "http://127.0.0.1:8500"
+
+// DefaultConsulInterval is the interval between reads of the Consul key used when CONSUL_INTERVAL is
+// not set.
+const DefaultConsulInterval = time.Minute
+
+// ConsulSource reads the configuration, in the CONFIG_FILE format, from a key of the Consul KV store.
+type ConsulSource struct {
+	address string
+	key     string
+	token   string
+	client  *http.Client
+
+	// last is the value last read, whether it was applied or rejected, so that an unchanged value is
+	// neither applied nor rejected again.
+	last []byte
+}
+
+// NewConsulSource creates a source that reads key from the Consul agent at address, such as
+// http://consul:8500, authenticating with the ACL token if it is not empty. As with the Consul CLI, an
+// address without a scheme uses http, and an empty address selects DefaultConsulAddress.
+func NewConsulSource(address, key, token string) (*ConsulSource, error) {
+	address = strings.TrimRight(strings.TrimSpace(address), "/")
+	if address == "" {
+		address = DefaultConsulAddress
+	} else if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		return nil, fmt.Errorf("invalid Consul address: %s (must start with http:// or https://)", redactURL(address))
+	}
+	key = strings.Trim(strings.TrimSpace(key), "/")
+	if key == "" {
+		return nil, errors.New("no Consul key configured")
+	}
+
+	return &ConsulSource{
+		address: address,
+		key:     key,
+		token:   token,
+		client:  &http.Client{Timeout: consulTimeout},
+	}, nil
+}
+
+// Load reads the configuration from Consul and returns its endpoints, which still need to be named
+// with AssignProviderNames, and its relabel rules.
+func (s *ConsulSource) Load() ([]EndpointConfig, []RelabelRule, error) {
+	data, err := s.fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source := "Consul key " + s.key
+	endpoints, err := parseConfig(data, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules, err := parseRelabelRules(data, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.last = data
+	return endpoints, rules, nil
+}
+
+// fetch returns the raw value of the key.
+func (s *ConsulSource) fetch() ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, s.address+"/v1/kv/"+s.key+"?raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		request.Header.Set("X-Consul-Token", s.token)
+	}
+
+	response, err := doHTTP(s.client, request, "Consul")
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Consul key %s does not exist", s.key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return io.ReadAll(response.Body)
+}
+
+// RunConsul reads the configuration from Consul, waiting the interval between reads, and replaces the
+// monitored endpoints whenever the key's value changes. Errors reading the key and invalid values are
+// logged and the current configuration is kept; an invalid value is logged once. Relabel rules are
+// only applied at startup. It never returns.
+func (c *WalletBalanceCollector) RunConsul(source *ConsulSource, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		data, err := source.fetch()
+		if err != nil {
+			log.Printf("Error reading configuration from Consul: %v", err)
+			continue
+		}
+		if bytes.Equal(data, source.last) {
+			continue
+		}
+		source.last = data
+
+		if err := c.reloadConfig(data, "Consul key "+source.key); err != nil {
+			log.Printf("Error applying configuration from Consul, keeping the current configuration: %v", err)
+		}
+	}
+}
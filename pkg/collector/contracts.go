@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"log"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DetectContracts determines for every wallet whether it is a contract or an externally owned account.
+// It is run once at startup; wallets whose endpoint cannot be reached are retried by later collections.
+func (c *WalletBalanceCollector) DetectContracts(ctx context.Context) {
+	for _, endpoint := range c.getEndpoints() {
+		if c.waitForStart(ctx, endpoint) != nil {
+			return
+		}
+		client, err := c.getClient(ctx, endpoint)
+		if err != nil {
+			log.Printf("Error connecting to provider %s for contract detection: %v", endpoint.Name, err)
+			continue
+		}
+
+		for _, wallet := range endpoint.Wallets {
+			c.detectContract(ctx, endpoint, client, wallet)
+		}
+	}
+}
+
+// detectContract records whether the wallet has code deployed, unless that is already known or the
+// endpoint is aggregated.
+func (c *WalletBalanceCollector) detectContract(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig) {
+	if endpoint.Aggregate {
+		return
+	}
+	key := balanceKey(endpoint.URL, wallet.Address)
+
+	c.cacheMutex.Lock()
+	_, known := c.contracts[key]
+	c.cacheMutex.Unlock()
+	if known {
+		return
+	}
+
+	code, err := client.CodeAt(ctx, wallet.hexAddress(), nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.countRPCError(endpoint, err)
+			err = wrapRPCError(err)
+			c.logEndpointError(endpoint, err, "Error retrieving code for wallet %s from provider %s: %v", wallet.Address, endpoint.Name, err)
+		}
+		return
+	}
+
+	c.cacheMutex.Lock()
+	c.contracts[key] = len(code) > 0
+	c.cacheMutex.Unlock()
+}
+
+// collectContracts emits whether each wallet on the endpoints is a contract, for wallets where this is known.
+func (c *WalletBalanceCollector) collectContracts(ch chan<- prometheus.Metric, endpoints []EndpointConfig) {
+	secondary := crossCheckSecondary(endpoints)
+	for _, endpoint := range endpoints {
+		chainID := c.chainLabel(endpoint)
+		for _, wallet := range endpoint.Wallets {
+			c.cacheMutex.Lock()
+			isContract, known := c.contracts[balanceKey(endpoint.URL, wallet.Address)]
+			c.cacheMutex.Unlock()
+			if !known || secondary[balanceKey(endpoint.URL, wallet.Address)] {
+				continue
+			}
+
+			value := 0.0
+			if isContract {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.contractMetric,
+				prometheus.GaugeValue,
+				value,
+				c.walletLabelValues(endpoint, wallet, chainID, c.addressLabel(wallet.Address), chainID)...,
+			)
+		}
+	}
+}
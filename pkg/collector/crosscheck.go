@@ -0,0 +1,169 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ParseCrossCheckTolerance parses the CROSS_CHECK_TOLERANCE environment variable: the largest
+// difference in ETH between the balances that cross-check peers report for a wallet that still counts
+// as agreement. An empty value returns 0, which requires identical balances.
+func ParseCrossCheckTolerance(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	tolerance, err := strconv.ParseFloat(value, 64)
+	if err != nil || tolerance < 0 || math.IsInf(tolerance, 0) || math.IsNaN(tolerance) {
+		return 0, fmt.Errorf("invalid tolerance: %s (must be a non-negative number of ETH, e.g. 0.001)", value)
+	}
+	return tolerance, nil
+}
+
+// crossCheckKey identifies a wallet within a cross-check group.
+func crossCheckKey(group, walletAddress string) string {
+	return group + "|" + strings.ToLower(walletAddress)
+}
+
+// crossCheckPeers returns the indexes of the results of each wallet on cross-check endpoints, keyed by
+// crossCheckKey, in the order of the results. Wallets on endpoints without cross_check are omitted.
+func crossCheckPeers(results []fetchResult) map[string][]int {
+	peers := make(map[string][]int)
+	for i, result := range results {
+		if group := result.endpoint.CrossCheck; group != "" {
+			key := crossCheckKey(group, result.wallet.Address)
+			peers[key] = append(peers[key], i)
+		}
+	}
+	return peers
+}
+
+// agreedResult returns the index of the result whose balance the most peers agree with within the
+// tolerance, preferring the earliest on a tie, and whether the successful peers disagree. If every
+// peer failed, the first is returned so that the wallet is exported according to STALE_BEHAVIOR.
+func (c *WalletBalanceCollector) agreedResult(results []fetchResult, peers []int) (int, bool) {
+	agreed, votes, mismatch := peers[0], 0, false
+	for _, i := range peers {
+		if results[i].err != nil {
+			continue
+		}
+
+		count := 0
+		for _, j := range peers {
+			if results[j].err != nil {
+				continue
+			}
+			if math.Abs(results[i].balance-results[j].balance) <= c.config.CrossCheckTolerance {
+				count++
+			} else {
+				mismatch = true
+			}
+		}
+		if count > votes {
+			agreed, votes = i, count
+		}
+	}
+	return agreed, mismatch
+}
+
+// crossCheck compares the balances that cross-check peers fetched for each of their wallets, counting
+// and logging each wallet whose peers disagree beyond the tolerance in balance_mismatch_total.
+func (c *WalletBalanceCollector) crossCheck(results []fetchResult) {
+	for _, peers := range crossCheckPeers(results) {
+		if len(peers) < 2 {
+			continue
+		}
+		if _, mismatch := c.agreedResult(results, peers); !mismatch {
+			continue
+		}
+
+		wallet := results[peers[0]].wallet.Address
+		group := results[peers[0]].endpoint.CrossCheck
+		var balances []string
+		for _, i := range peers {
+			if results[i].err == nil {
+				balances = append(balances, fmt.Sprintf("%s=%v", results[i].endpoint.Name, results[i].balance))
+			}
+		}
+		log.Printf("Balances of wallet %s disagree across cross-check group %s: %s", wallet, group, strings.Join(balances, ", "))
+		c.mismatches.WithLabelValues(c.addressLabel(wallet), group).Inc()
+	}
+}
+
+// collectPeerSpreads emits, for each wallet on cross-check endpoints with at least two peers that
+// fetched its balance over RPC, the difference between the highest and the lowest of their balances in
+// Wei. Unlike balance_mismatch_total, it shows differences within the tolerance too.
+func (c *WalletBalanceCollector) collectPeerSpreads(ch chan<- prometheus.Metric, results []fetchResult) {
+	for _, peers := range crossCheckPeers(results) {
+		var lowest, highest *big.Int
+		fetched := 0
+		for _, i := range peers {
+			balanceWei := results[i].balanceWei
+			if results[i].err != nil || balanceWei == nil {
+				continue
+			}
+			fetched++
+			if lowest == nil || balanceWei.Cmp(lowest) < 0 {
+				lowest = balanceWei
+			}
+			if highest == nil || balanceWei.Cmp(highest) > 0 {
+				highest = balanceWei
+			}
+		}
+		if fetched < 2 {
+			continue
+		}
+
+		spread, _ := new(big.Float).SetInt(new(big.Int).Sub(highest, lowest)).Float64()
+		ch <- prometheus.MustNewConstMetric(
+			c.peerSpread,
+			prometheus.GaugeValue,
+			spread,
+			c.addressLabel(results[peers[0]].wallet.Address),
+			results[peers[0]].endpoint.CrossCheck,
+		)
+	}
+}
+
+// crossCheckSkipped returns the indexes of the results that are not exported because another peer's
+// result for the same wallet is exported instead.
+func (c *WalletBalanceCollector) crossCheckSkipped(results []fetchResult) map[int]bool {
+	skipped := make(map[int]bool)
+	for _, peers := range crossCheckPeers(results) {
+		agreed, _ := c.agreedResult(results, peers)
+		for _, i := range peers {
+			if i != agreed {
+				skipped[i] = true
+			}
+		}
+	}
+	return skipped
+}
+
+// crossCheckSecondary returns the wallets, keyed by balanceKey, whose per-wallet metrics other than
+// the balance, such as wallet_is_contract, are exported by an earlier cross-check peer, so that each
+// wallet is exported once per cross-check group.
+func crossCheckSecondary(endpoints []EndpointConfig) map[string]bool {
+	seen := make(map[string]bool)
+	secondary := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		if endpoint.CrossCheck == "" {
+			continue
+		}
+		for _, wallet := range endpoint.Wallets {
+			key := crossCheckKey(endpoint.CrossCheck, wallet.Address)
+			if seen[key] {
+				secondary[balanceKey(endpoint.URL, wallet.Address)] = true
+			}
+			seen[key] = true
+		}
+	}
+	return secondary
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAgreedResult(t *testing.T) {
+	failed := -1.0
+	tests := []struct {
+		name      string
+		balances  []float64
+		tolerance float64
+		agreed    int
+		mismatch  bool
+	}{
+		{"all agree", []float64{1, 1, 1}, 0, 0, false},
+		{"2 of 3 agree", []float64{1, 2, 2}, 0, 1, true},
+		{"tie", []float64{1, 2}, 0, 0, true},
+		{"three-way tie", []float64{3, 2, 1}, 0, 0, true},
+		{"within the tolerance", []float64{1, 1.0005}, 0.001, 0, false},
+		{"out of the tolerance", []float64{1, 1.0005, 1.2}, 0.001, 0, true},
+		{"agreeing with both neighbours", []float64{1, 1.0008, 1.0016}, 0.001, 1, true},
+		{"failed peer", []float64{failed, 2, 2}, 0, 1, false},
+		{"all failed", []float64{failed, failed}, 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New(nil, CollectorConfig{CrossCheckTolerance: test.tolerance})
+			var (
+				results []fetchResult
+				peers   []int
+			)
+			for i, balance := range test.balances {
+				result := fetchResult{balance: balance}
+				if balance == failed {
+					result = fetchResult{err: errors.New("rate limited")}
+				}
+				results = append(results, result)
+				peers = append(peers, i)
+			}
+			agreed, mismatch := c.agreedResult(results, peers)
+			if agreed != test.agreed || mismatch != test.mismatch {
+				t.Errorf("agreedResult(%v) = %d, %v, want %d, %v", test.balances, agreed, mismatch, test.agreed, test.mismatch)
+			}
+		})
+	}
+}
+
+// TestCrossCheckCollect checks the balance exported for a wallet on three cross-check peers, two of which
+// agree, and the mismatch and spread series with the difference out of and within the tolerance.
+func TestCrossCheckCollect(t *testing.T) {
+	tests := []struct {
+		name       string
+		tolerance  float64
+		balance    float64
+		mismatches float64
+	}{
+		{"out of the tolerance", 0, 2, 1},
+		{"within the tolerance", 1.5, 1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+			wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+			var endpoints []EndpointConfig
+			for i, balanceWei := range []string{"0xde0b6b3a7640000", "0x1bc16d674ec80000", "0x1bc16d674ec80000"} {
+				server := newStubRPC(t, map[string]string{
+					"eth_chainId":    `"0x1"`,
+					"eth_getBalance": `"` + balanceWei + `"`,
+					"eth_getCode":    `"0x"`,
+				})
+				endpoints = append(endpoints, EndpointConfig{Name: fmt.Sprintf("peer%d", i), URL: server.URL, CrossCheck: "mainnet", Wallets: []WalletConfig{wallet}})
+			}
+
+			registry := prometheus.NewPedanticRegistry()
+			if _, err := Register(registry, endpoints, CollectorConfig{CrossCheckTolerance: test.tolerance}); err != nil {
+				t.Fatal(err)
+			}
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values := make(map[string][]float64)
+			for _, family := range families {
+				for _, metric := range family.GetMetric() {
+					switch family.GetName() {
+					case "wallet_balance_eth", "balance_peer_spread_wei":
+						values[family.GetName()] = append(values[family.GetName()], metric.GetGauge().GetValue())
+					case "balance_mismatch_total":
+						values[family.GetName()] = append(values[family.GetName()], metric.GetCounter().GetValue())
+					}
+				}
+			}
+			if balances := values["wallet_balance_eth"]; len(balances) != 1 || balances[0] != test.balance {
+				t.Errorf("wallet_balance_eth = %v, want a single series of %v", balances, test.balance)
+			}
+			if spreads := values["balance_peer_spread_wei"]; len(spreads) != 1 || spreads[0] != 1e18 {
+				t.Errorf("balance_peer_spread_wei = %v, want a single series of 1e18", spreads)
+			}
+			mismatches := 0.0
+			for _, value := range values["balance_mismatch_total"] {
+				mismatches += value
+			}
+			if mismatches != test.mismatches {
+				t.Errorf("balance_mismatch_total = %v, want %v", mismatches, test.mismatches)
+			}
+		})
+	}
+}
+
+// TestCollectPeerSpreads checks that the spread is exported only for wallets with at least two peers that
+// fetched their balance over RPC.
+func TestCollectPeerSpreads(t *testing.T) {
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	peer := func(name string) EndpointConfig {
+		return EndpointConfig{Name: name, URL: "http://" + name + ".invalid", CrossCheck: "mainnet", Wallets: []WalletConfig{wallet}}
+	}
+	c := New([]EndpointConfig{peer("first"), peer("second"), peer("third")}, CollectorConfig{})
+
+	spreads := func(results []fetchResult) int {
+		ch := make(chan prometheus.Metric, len(results))
+		c.collectPeerSpreads(ch, results)
+		close(ch)
+		return len(ch)
+	}
+	tests := []struct {
+		name    string
+		results []fetchResult
+		want    int
+	}{
+		{"two fetched", []fetchResult{
+			{endpoint: peer("first"), wallet: wallet, balanceWei: common.Big1},
+			{endpoint: peer("second"), wallet: wallet, balanceWei: common.Big2},
+			{endpoint: peer("third"), wallet: wallet, err: errors.New("rate limited")},
+		}, 1},
+		{"one fetched", []fetchResult{
+			{endpoint: peer("first"), wallet: wallet, balanceWei: common.Big1},
+			{endpoint: peer("second"), wallet: wallet, err: errors.New("rate limited")},
+		}, 0},
+		{"one fetched over RPC", []fetchResult{
+			{endpoint: peer("first"), wallet: wallet, balanceWei: common.Big1},
+			{endpoint: peer("second"), wallet: wallet, balance: 1, source: sourceEtherscan},
+		}, 0},
+	}
+	for _, test := range tests {
+		if got := spreads(test.results); got != test.want {
+			t.Errorf("%s: %d spreads exported, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCache resolves host names for RPC connections and caches the addresses for a fixed TTL, so that
+// new connections to a provider do not wait for DNS.
+type dnsCache struct {
+	ttl    time.Duration
+	dialer *net.Dialer
+
+	mutex   sync.Mutex
+	entries map[string]dnsEntry
+}
+
+// dnsEntry is the resolved addresses of a host and when they expire.
+type dnsEntry struct {
+	addresses []string
+	expires   time.Time
+}
+
+// newDNSCache creates a DNS cache that keeps resolved addresses for ttl. Connections are dialed with
+// the same timeouts as http.DefaultTransport.
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		ttl:     ttl,
+		dialer:  &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		entries: make(map[string]dnsEntry),
+	}
+}
+
+// DialContext connects to the address, resolving its host through the cache and trying each
+// resolved address in turn. If none of the cached addresses can be reached, the entry is dropped so
+// that the next dial resolves the host again.
+func (d *dnsCache) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	addresses, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resolved := range addresses {
+		var conn net.Conn
+		conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(resolved, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	d.mutex.Lock()
+	delete(d.entries, host)
+	d.mutex.Unlock()
+	return nil, err
+}
+
+// lookup returns the cached addresses of the host, resolving it if they are missing or expired.
+// Failed lookups are not cached.
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	d.mutex.Lock()
+	entry, exists := d.entries[host]
+	d.mutex.Unlock()
+	if exists && time.Now().Before(entry.expires) {
+		return entry.addresses, nil
+	}
+
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.entries[host] = dnsEntry{addresses: addresses, expires: time.Now().Add(d.ttl)}
+	d.mutex.Unlock()
+	return addresses, nil
+}
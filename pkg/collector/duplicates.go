@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DuplicateWallets selects how wallets listed under several endpoints of the same chain are exported.
+type DuplicateWallets string
+
+const (
+	// DuplicateDrop exports the series of the first such endpoint in the configuration and drops the
+	// identical series of the others.
+	DuplicateDrop DuplicateWallets = "drop"
+	// DuplicateProvider adds a provider label to the wallet metrics, so that every endpoint's series
+	// are distinct.
+	DuplicateProvider DuplicateWallets = "provider"
+)
+
+// ParseDuplicateWallets parses the DUPLICATE_WALLETS environment variable. An empty value selects
+// DuplicateDrop.
+func ParseDuplicateWallets(value string) (DuplicateWallets, error) {
+	switch handling := DuplicateWallets(strings.ToLower(strings.TrimSpace(value))); handling {
+	case "":
+		return DuplicateDrop, nil
+	case DuplicateDrop, DuplicateProvider:
+		return handling, nil
+	default:
+		return "", fmt.Errorf("invalid DUPLICATE_WALLETS: %s (must be one of drop, provider)", value)
+	}
+}
+
+// withProviderLabel adds a provider label with the endpoint name to the label templates.
+func withProviderLabel(templates []LabelTemplate) []LabelTemplate {
+	tmpl := template.Must(template.New("provider").Parse("{{.Provider}}"))
+	return append(slices.Clone(templates), LabelTemplate{Name: "provider", Template: tmpl})
+}
+
+// dropDuplicates wraps ch to drop series whose name and label values were already collected in the
+// scrape, logging a warning, as the registry fails the whole scrape on duplicate series. It returns
+// the channel to collect to and a function that must be called when the collection is done.
+func (c *WalletBalanceCollector) dropDuplicates(ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	deduplicated := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		seen := make(map[string]bool)
+		for metric := range deduplicated {
+			var written dto.Metric
+			if err := metric.Write(&written); err != nil {
+				// Let the registry report the broken metric
+				ch <- metric
+				continue
+			}
+
+			var key strings.Builder
+			key.WriteString(metric.Desc().String())
+			for _, pair := range written.GetLabel() {
+				fmt.Fprintf(&key, "\xff%s=%s", pair.GetName(), pair.GetValue())
+			}
+			if seen[key.String()] {
+				series := seriesName(metric, &written)
+				c.logs.printf(series, "WARNING: Dropping duplicate series %s, such as of a wallet listed under several endpoints of the same chain", series)
+				continue
+			}
+			seen[key.String()] = true
+			ch <- metric
+		}
+	}()
+	return deduplicated, func() {
+		close(deduplicated)
+		<-done
+	}
+}
+
+// seriesName formats the name and labels of a written metric in the exposition format.
+func seriesName(metric prometheus.Metric, written *dto.Metric) string {
+	// The descriptor holds the name only in its string form
+	name, _, _ := strings.Cut(strings.TrimPrefix(metric.Desc().String(), `Desc{fqName: "`), `"`)
+	labels := make([]string, 0, len(written.GetLabel()))
+	for _, pair := range written.GetLabel() {
+		labels = append(labels, fmt.Sprintf("%s=%q", pair.GetName(), pair.GetValue()))
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
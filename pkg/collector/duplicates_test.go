@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestDuplicateWallets checks that a wallet listed under two endpoints of the same chain is exported once
+// with DUPLICATE_WALLETS=drop, from the first endpoint, and once per endpoint with provider.
+func TestDuplicateWallets(t *testing.T) {
+	tests := []struct {
+		handling  DuplicateWallets
+		providers []string
+	}{
+		{DuplicateDrop, []string{""}},
+		{DuplicateProvider, []string{"first", "second"}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.handling), func(t *testing.T) {
+			address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+			wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+			var endpoints []EndpointConfig
+			for _, name := range []string{"first", "second"} {
+				server := newStubRPC(t, map[string]string{
+					"eth_chainId":    `"0x1"`,
+					"eth_getBalance": `"0xde0b6b3a7640000"`,
+					"eth_getCode":    `"0x"`,
+				})
+				endpoints = append(endpoints, EndpointConfig{Name: name, URL: server.URL, Wallets: []WalletConfig{wallet}})
+			}
+
+			registry := prometheus.NewPedanticRegistry()
+			if _, err := Register(registry, endpoints, CollectorConfig{DuplicateWallets: test.handling}); err != nil {
+				t.Fatal(err)
+			}
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+
+			var providers []string
+			for _, family := range families {
+				if family.GetName() != "wallet_balance_eth" {
+					continue
+				}
+				for _, metric := range family.GetMetric() {
+					provider := ""
+					for _, pair := range metric.GetLabel() {
+						if pair.GetName() == "provider" {
+							provider = pair.GetValue()
+						}
+					}
+					providers = append(providers, provider)
+				}
+			}
+			if !slices.Equal(providers, test.providers) {
+				t.Errorf("wallet_balance_eth exported for providers %q, want %q", providers, test.providers)
+			}
+		})
+	}
+}
+
+// TestReservedWalletLabels checks that the provider and name labels, added by DUPLICATE_WALLETS=provider
+// and the naming service, cannot be taken by a label template or a wallet tag.
+func TestReservedWalletLabels(t *testing.T) {
+	for _, name := range []string{"provider", "name"} {
+		if _, err := ParseLabelTemplates(name + "={{.Provider}}"); err == nil {
+			t.Errorf("ParseLabelTemplates() accepted a %s label", name)
+		}
+		if err := validateWalletTags(map[string]string{name: "treasury"}); err == nil {
+			t.Errorf("validateWalletTags() accepted a %s tag", name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// chainShortNames maps the EIP-3770 short names of common chains, as registered in the
+// ethereum-lists chain registry, to their chain IDs.
+var chainShortNames = map[string]string{
+	"eth":   "1",
+	"oeth":  "10",
+	"bnb":   "56",
+	"gno":   "100",
+	"matic": "137",
+	"pol":   "137",
+	"base":  "8453",
+	"arb1":  "42161",
+	"avax":  "43114",
+	"linea": "59144",
+	"sep":   "11155111",
+}
+
+// splitChainAddress splits an EIP-3770 chain-specific address such as eth:0xabc into the chain ID of
+// its short name and the plain address. An address without a prefix is returned with an empty chain ID.
+func splitChainAddress(address string) (string, string, error) {
+	shortName, plain, found := strings.Cut(strings.TrimSpace(address), ":")
+	if !found {
+		return "", address, nil
+	}
+
+	chainID, ok := chainShortNames[strings.ToLower(shortName)]
+	if !ok {
+		known := make([]string, 0, len(chainShortNames))
+		for name := range chainShortNames {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return "", "", fmt.Errorf("unknown chain short name %q in %s (known: %s)", shortName, address, strings.Join(known, ", "))
+	}
+	return chainID, plain, nil
+}
+
+// routeChainWallets adds each wallet of a flat list of EIP-3770 addresses to every endpoint whose
+// chain is the wallet's chain.
+func routeChainWallets(endpoints []EndpointConfig, wallets []WalletConfig) error {
+	for _, wallet := range wallets {
+		chainID, address, err := splitChainAddress(wallet.Address)
+		if err != nil {
+			return err
+		}
+		if chainID == "" {
+			return fmt.Errorf("wallet %s has no chain prefix (top-level wallets must be EIP-3770 addresses, e.g. eth:0x...)", wallet.Address)
+		}
+
+		routed := false
+		for i := range endpoints {
+			if endpoints[i].Chain == chainID {
+				routedWallet := wallet
+				routedWallet.Address = address
+				endpoints[i].Wallets = append(endpoints[i].Wallets, routedWallet)
+				routed = true
+			}
+		}
+		if !routed {
+			return fmt.Errorf("no endpoint with chain %s for wallet %s (set chain or expected_chain_id on the endpoint)", chainID, wallet.Address)
+		}
+	}
+	return nil
+}
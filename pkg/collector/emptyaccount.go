@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// DefaultEmptyAccountErrors are the error messages, matched case-insensitively as substrings, with
+// which providers answer eth_getBalance for an account that has never been used, used when
+// EMPTY_ACCOUNT_ERRORS is not set.
+var DefaultEmptyAccountErrors = []string{"account not found", "unknown account", "account does not exist"}
+
+// ParseEmptyAccountErrors parses the EMPTY_ACCOUNT_ERRORS environment variable: comma-separated
+// error messages that mean the account does not exist yet. An empty value returns nil, which selects
+// DefaultEmptyAccountErrors, and none returns an empty list, which treats every error as a failure.
+func ParseEmptyAccountErrors(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(value, "none") {
+		return []string{}, nil
+	}
+
+	var messages []string
+	for _, message := range strings.Split(value, ",") {
+		if message = strings.ToLower(strings.TrimSpace(message)); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// isEmptyAccount reports whether err is a JSON-RPC error with which the provider reported that the
+// account does not exist, rather than a failure. HTTP and network errors never are.
+func (c *WalletBalanceCollector) isEmptyAccount(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+
+	message := strings.ToLower(rpcErr.Error())
+	for _, pattern := range c.config.EmptyAccountErrors {
+		if strings.Contains(message, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
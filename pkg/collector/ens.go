@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DefaultENSInterval is the interval between resolutions of ENS names used when ENS_INTERVAL is not set.
+const DefaultENSInterval = time.Hour
+
+// ensRegistry is the address of the ENS registry, which is the same on Ethereum mainnet and its testnets.
+var ensRegistry = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+
+// Selectors of the registry's resolver(bytes32) and the resolver's addr(bytes32) methods.
+var (
+	ensResolverSelector = common.FromHex("0x0178b8bf")
+	ensAddrSelector     = common.FromHex("0x3b3b57de")
+)
+
+// normalizeENSName lowercases an ENS name such as vitalik.eth and checks that it has a label before
+// its top-level domain. Names are not normalized beyond that, so they must be configured in their
+// normalized form.
+func normalizeENSName(name string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	labels := strings.Split(normalized, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("invalid ENS name: %s (expected e.g. vitalik.eth)", name)
+	}
+	for _, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("invalid ENS name: %s (expected e.g. vitalik.eth)", name)
+		}
+	}
+	return normalized, nil
+}
+
+// namehash returns the ENS node of a normalized name (EIP-137).
+func namehash(name string) common.Hash {
+	var node common.Hash
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = crypto.Keccak256Hash(node.Bytes(), crypto.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
+
+// ensResolver holds the addresses ENS names of wallets were last resolved to, keyed by endpoint URL and
+// name, as the name is resolved on each endpoint's chain.
+type ensResolver struct {
+	mutex     sync.Mutex
+	addresses map[string]common.Address
+}
+
+// address returns the address the name was last resolved to on the endpoint, if it was resolved.
+func (r *ensResolver) address(endpoint EndpointConfig, name string) (common.Address, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	address, ok := r.addresses[balanceKey(endpoint.URL, name)]
+	return address, ok
+}
+
+// withENSAddresses returns the endpoints with the wallets configured by ENS name monitored at the
+// address their name was last resolved to. Wallets whose name has not been resolved yet are left out.
+// The endpoints are returned unchanged if they have no such wallets.
+func (c *WalletBalanceCollector) withENSAddresses(endpoints []EndpointConfig) []EndpointConfig {
+	hasENS := func(wallet WalletConfig) bool { return wallet.ENS != "" }
+
+	var resolved []EndpointConfig
+	for i, endpoint := range endpoints {
+		if !slices.ContainsFunc(endpoint.Wallets, hasENS) {
+			continue
+		}
+		if resolved == nil {
+			resolved = slices.Clone(endpoints)
+		}
+
+		wallets := make([]WalletConfig, 0, len(endpoint.Wallets))
+		for _, wallet := range endpoint.Wallets {
+			if wallet.ENS != "" {
+				address, ok := c.ens.address(endpoint, wallet.ENS)
+				if !ok {
+					continue
+				}
+				wallet.Address, wallet.parsed = address.Hex(), address
+			}
+			wallets = append(wallets, wallet)
+		}
+		resolved[i].Wallets = wallets
+	}
+	if resolved == nil {
+		return endpoints
+	}
+	return resolved
+}
+
+// resolveENS resolves an ENS name to an address through the endpoint, with the registry at the chain
+// head. It fails if the name has no resolver or no address.
+func (c *WalletBalanceCollector) resolveENS(ctx context.Context, endpoint EndpointConfig, name string) (common.Address, error) {
+	client, err := c.getClient(ctx, endpoint)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	node := namehash(name)
+	result, err := client.CallContract(ctx, ethereum.CallMsg{To: &ensRegistry, Data: append(append([]byte{}, ensResolverSelector...), node.Bytes()...)}, nil)
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		return common.Address{}, wrapRPCError(err)
+	}
+	if len(result) < 32 || common.BytesToAddress(result[:32]) == (common.Address{}) {
+		return common.Address{}, errors.New("no resolver set (is the endpoint on a chain with the ENS registry?)")
+	}
+	resolver := common.BytesToAddress(result[:32])
+
+	result, err = client.CallContract(ctx, ethereum.CallMsg{To: &resolver, Data: append(append([]byte{}, ensAddrSelector...), node.Bytes()...)}, nil)
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		return common.Address{}, wrapRPCError(err)
+	}
+	if len(result) < 32 || common.BytesToAddress(result[:32]) == (common.Address{}) {
+		return common.Address{}, errors.New("no address set")
+	}
+	return common.BytesToAddress(result[:32]), nil
+}
+
+// RunENS resolves the ENS names of the wallets configured by name, waiting the interval between
+// resolutions so that a name pointed at a new address is picked up without a restart, as are names
+// added by a reload. A wallet whose name cannot be resolved keeps the address it was last resolved to.
+// When a name changes address, the change is logged and counted, and the balances cached for the
+// previous address are dropped. It never returns.
+func (c *WalletBalanceCollector) RunENS(interval time.Duration) {
+	for {
+		c.resolveENSNames()
+		time.Sleep(interval)
+	}
+}
+
+// resolveENSNames resolves the ENS names of all endpoints' wallets once, one after the other. Each name
+// is bounded by CollectTimeout, so that a slow endpoint does not keep the names after it from resolving.
+func (c *WalletBalanceCollector) resolveENSNames() {
+	c.endpointsMutex.Lock()
+	endpoints := c.endpoints
+	c.endpointsMutex.Unlock()
+
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			if wallet.ENS == "" {
+				continue
+			}
+			previous, resolved := c.ens.address(endpoint, wallet.ENS)
+			ctx, cancel := context.WithTimeout(context.Background(), c.config.CollectTimeout)
+			address, err := c.resolveENS(ctx, endpoint, wallet.ENS)
+			cancel()
+			if err != nil {
+				log.Printf("Error resolving ENS name %s on provider %s: %v", wallet.ENS, endpoint.Name, err)
+				continue
+			}
+			if resolved && address == previous {
+				continue
+			}
+
+			c.ens.mutex.Lock()
+			c.ens.addresses[balanceKey(endpoint.URL, wallet.ENS)] = address
+			c.ens.mutex.Unlock()
+			if !resolved {
+				log.Printf("Resolved ENS name %s on provider %s to %s", wallet.ENS, endpoint.Name, address.Hex())
+				continue
+			}
+			log.Printf("ENS name %s on provider %s changed from %s to %s", wallet.ENS, endpoint.Name, previous.Hex(), address.Hex())
+			c.ensChanges.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name, wallet.ENS)...).Inc()
+			c.forgetWallet(endpoint, previous.Hex())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ensResolverAddress is the resolver the stub ENS registry of ensAnswer returns for every name.
+var ensResolverAddress = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+// ensAnswer answers as an ENS deployment resolving every name to the address held by resolved, waiting
+// delay before each eth_call. Balances are 1 ETH.
+func ensAnswer(resolved *atomic.Value, delay time.Duration) func(method string, params []json.RawMessage) (string, error) {
+	return func(method string, params []json.RawMessage) (string, error) {
+		switch method {
+		case "eth_chainId":
+			return `"0x1"`, nil
+		case "eth_call":
+			time.Sleep(delay)
+			var call struct {
+				To common.Address `json:"to"`
+			}
+			if err := json.Unmarshal(params[0], &call); err != nil {
+				return "", err
+			}
+			result := ensResolverAddress
+			if call.To != ensRegistry {
+				result = resolved.Load().(common.Address)
+			}
+			return fmt.Sprintf(`"%s"`, common.BytesToHash(result.Bytes()).Hex()), nil
+		}
+		return balanceAnswer(method, params)
+	}
+}
+
+// TestResolveENSNamesChange checks that a name resolving to a new address is counted as a change once,
+// and that the balances cached for its previous address are dropped.
+func TestResolveENSNamesChange(t *testing.T) {
+	previous := common.HexToAddress("0x742d35Cc6634C0532925a3b844Bc454e4438f44e")
+	current := common.HexToAddress("0x000d836201318ec6899a67540690382780743280")
+	var resolved atomic.Value
+	resolved.Store(previous)
+
+	server := newStubRPCFunc(t, ensAnswer(&resolved, 0))
+	endpoint := EndpointConfig{Name: "stub", URL: server.URL, Wallets: []WalletConfig{{ENS: "treasury.eth"}}}
+	registry := prometheus.NewPedanticRegistry()
+	c, err := Register(registry, []EndpointConfig{endpoint}, CollectorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := func() float64 {
+		var metric dto.Metric
+		if err := c.ensChanges.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name, "treasury.eth")...).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetCounter().GetValue()
+	}
+	cached := func(address common.Address) bool {
+		c.cacheMutex.Lock()
+		defer c.cacheMutex.Unlock()
+		_, ok := c.lastBalances[balanceKey(endpoint.URL, address.Hex())]
+		return ok
+	}
+
+	c.resolveENSNames()
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if !cached(previous) {
+		t.Fatalf("balance of %s not cached", previous.Hex())
+	}
+
+	resolved.Store(current)
+	for range 2 {
+		c.resolveENSNames()
+	}
+	if address, _ := c.ens.address(endpoint, "treasury.eth"); address != current {
+		t.Errorf("treasury.eth resolved to %s, want %s", address.Hex(), current.Hex())
+	}
+	if got := changes(); got != 1 {
+		t.Errorf("ens_resolution_changes_total = %v, want 1", got)
+	}
+	if cached(previous) {
+		t.Errorf("balance of the previous address %s still cached", previous.Hex())
+	}
+}
+
+// TestResolveENSNamesTimeout checks that each name has its own timeout, so that names resolved after
+// slow ones are not cut short.
+func TestResolveENSNamesTimeout(t *testing.T) {
+	var resolved atomic.Value
+	resolved.Store(common.HexToAddress("0x742d35Cc6634C0532925a3b844Bc454e4438f44e"))
+
+	// Each name takes two calls of 50ms: 100ms per name, 400ms for all of them
+	server := newStubRPCFunc(t, ensAnswer(&resolved, 50*time.Millisecond))
+	names := []string{"a.eth", "b.eth", "c.eth", "d.eth"}
+	var wallets []WalletConfig
+	for _, name := range names {
+		wallets = append(wallets, WalletConfig{ENS: name})
+	}
+	endpoint := EndpointConfig{Name: "stub", URL: server.URL, Wallets: wallets}
+	c := New([]EndpointConfig{endpoint}, CollectorConfig{CollectTimeout: 300 * time.Millisecond})
+
+	c.resolveENSNames()
+	for _, name := range names {
+		if _, ok := c.ens.address(endpoint, name); !ok {
+			t.Errorf("%s not resolved", name)
+		}
+	}
+}
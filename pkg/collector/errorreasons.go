@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrorReason maps the errors whose message matches Pattern to Reason in the reason label of
+// rpc_endpoint_status and balance_fetch_errors_total, so that providers phrasing the same condition
+// differently report the same reason.
+type ErrorReason struct {
+	Reason  string         `json:"reason"`
+	Pattern *regexp.Regexp `json:"pattern"`
+}
+
+// defaultErrorReasons are matched after ERROR_REASONS, covering the phrasings of common providers.
+var defaultErrorReasons = []ErrorReason{
+	{Reason: "rate_limited", Pattern: regexp.MustCompile(`(?i)rate.?limit|too many requests|\b429\b|capacity|quota|limit (exceeded|reached)|(request count|rate) exceeded`)},
+	{Reason: "unauthorized", Pattern: regexp.MustCompile(`(?i)unauthori[sz]ed|forbidden|\b40[13]\b|invalid (api key|project id|token)|authenticat`)},
+	{Reason: "state_unavailable", Pattern: regexp.MustCompile(`(?i)missing trie node|header not found|unknown block|historical state|state (is )?not available`)},
+}
+
+// ParseErrorReasons parses the ERROR_REASONS environment variable: semicolon-separated reason=regexp
+// pairs such as rate_limited=(?i)throttled;unauthorized=bad key. The rules are matched in order, before
+// the defaults, against the error message.
+func ParseErrorReasons(value string) ([]ErrorReason, error) {
+	var reasons []ErrorReason
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		reason, pattern, ok := strings.Cut(pair, "=")
+		reason = strings.TrimSpace(reason)
+		if !ok || !labelNamePattern.MatchString(reason) || reason == reasonOK {
+			return nil, fmt.Errorf("invalid error reason: %s (expected reason=regexp with a reason other than ok made of letters, digits and underscores)", pair)
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for error reason %s: %v", reason, err)
+		}
+		reasons = append(reasons, ErrorReason{Reason: reason, Pattern: compiled})
+	}
+	return reasons, nil
+}
+
+// matchErrorReason returns the reason of the first of the configured rules, followed by the defaults,
+// whose pattern matches the error's message.
+func (c *WalletBalanceCollector) matchErrorReason(err error) (string, bool) {
+	message := err.Error()
+	for _, rule := range slices.Concat(c.config.ErrorReasons, defaultErrorReasons) {
+		if rule.Pattern.MatchString(message) {
+			return rule.Reason, true
+		}
+	}
+	return "", false
+}
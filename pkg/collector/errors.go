@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// fetchError is the last failed fetch of a wallet.
+type fetchError struct {
+	err string
+	at  time.Time
+}
+
+// walletError is a row of the /errors endpoint.
+type walletError struct {
+	Provider string    `json:"provider"`
+	ChainID  string    `json:"chain_id"`
+	Wallet   string    `json:"wallet"`
+	Name     string    `json:"name"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// errorMessage returns the message of a fetch error without the request URL that HTTP client errors
+// include, as it may contain an API key.
+func errorMessage(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err.Error()
+}
+
+// recordFetchError records the error of a failed fetch, or clears the wallet's error after a
+// successful one. It must be called with cacheMutex held.
+func (c *WalletBalanceCollector) recordFetchError(result fetchResult) {
+	key := balanceKey(result.endpoint.URL, result.wallet.Address)
+	if result.err == nil {
+		delete(c.lastErrors, key)
+		return
+	}
+	c.lastErrors[key] = fetchError{err: errorMessage(result.err), at: time.Now()}
+}
+
+// lastFetchErrors returns the last error of each wallet whose latest fetch failed, in config order.
+func (c *WalletBalanceCollector) lastFetchErrors() []walletError {
+	endpoints := c.getEndpoints()
+
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	walletErrors := []walletError{}
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			fetchErr, ok := c.lastErrors[balanceKey(endpoint.URL, wallet.Address)]
+			if !ok {
+				continue
+			}
+
+			walletErrors = append(walletErrors, walletError{
+				Provider: endpoint.Name,
+				ChainID:  c.chainLabel(endpoint),
+				Wallet:   wallet.Address,
+				Name:     wallet.Name,
+				Error:    fetchErr.err,
+				Time:     fetchErr.at.UTC(),
+			})
+		}
+	}
+	return walletErrors
+}
+
+// ServeErrors serves the last fetch error of each wallet whose latest fetch failed as JSON. Wallets
+// are removed from the list once a fetch succeeds again.
+func (c *WalletBalanceCollector) ServeErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.lastFetchErrors()); err != nil {
+		log.Printf("Error writing wallet errors: %v", err)
+	}
+}
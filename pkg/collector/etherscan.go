@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Balance sources of the source label, added to the native balance metrics with ETHERSCAN_URL.
+const (
+	sourceRPC       = "rpc"
+	sourceEtherscan = "etherscan"
+)
+
+// ParseEtherscanURL parses the ETHERSCAN_URL environment variable. An empty value disables the
+// Etherscan fallback.
+func ParseEtherscanURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid Etherscan API URL: %s (expected e.g. https://api.etherscan.io/v2/api)", redactURL(value))
+	}
+	return value, nil
+}
+
+// etherscanSource fetches account balances from an API compatible with Etherscan's account balance
+// action, such as Etherscan or Blockscout, for wallets whose balance could not be fetched over RPC.
+type etherscanSource struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// newEtherscanSource creates an Etherscan API client for url, authenticating with apiKey if it is
+// not empty.
+func newEtherscanSource(url, apiKey string, transport http.RoundTripper) *etherscanSource {
+	return &etherscanSource{url: url, apiKey: apiKey, client: &http.Client{Transport: transport}}
+}
+
+// etherscanResponse is the response of the Etherscan API. Result holds the balance in Wei on success
+// and the error message otherwise.
+type etherscanResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Result  string `json:"result"`
+}
+
+// balance fetches the balance in Wei of the address at the latest block on the chain. The chain ID
+// selects the chain on multichain APIs and is omitted if it is not known.
+func (e *etherscanSource) balance(ctx context.Context, chainID, address string) (*big.Int, error) {
+	requestURL, err := url.Parse(e.url)
+	if err != nil {
+		return nil, err
+	}
+	query := requestURL.Query()
+	if chainID != "" {
+		query.Set("chainid", chainID)
+	}
+	query.Set("module", "account")
+	query.Set("action", "balance")
+	query.Set("address", address)
+	query.Set("tag", "latest")
+	if e.apiKey != "" {
+		query.Set("apikey", e.apiKey)
+	}
+	requestURL.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := doHTTP(e.client, request, "Etherscan API")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var result etherscanResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid Etherscan API response: %v", err)
+	}
+	if result.Status != "1" {
+		return nil, fmt.Errorf("Etherscan API error: %s: %s", result.Message, result.Result)
+	}
+	balance, ok := new(big.Int).SetString(result.Result, 10)
+	if !ok || balance.Sign() < 0 {
+		return nil, fmt.Errorf("invalid balance in Etherscan API response: %q", result.Result)
+	}
+	return balance, nil
+}
+
+// fetchFallback fetches the balance in ETH of a wallet whose balance could not be fetched over RPC from
+// the Etherscan API, if configured. Endpoints that resolve balances with a contract call, or read them
+// behind the chain head, have no equivalent there and are not covered.
+func (c *WalletBalanceCollector) fetchFallback(ctx context.Context, endpoint EndpointConfig, wallet WalletConfig) (float64, bool) {
+	if c.etherscan == nil || endpoint.BalanceCall != nil || endpoint.Confirmations > 0 || ctx.Err() != nil {
+		return 0, false
+	}
+
+	balanceWei, err := c.etherscan.balance(ctx, c.chainLabel(endpoint), wallet.Address)
+	if err != nil {
+		c.logEndpointError(endpoint, err, "Error retrieving balance for wallet %s of provider %s from Etherscan API: %v", wallet.Address, endpoint.Name, err)
+		return 0, false
+	}
+	balance, _ := weiToETH(balanceWei, endpoint.nativeDecimals(), c.config.Precision, c.config.RoundingMode)
+	return balance, true
+}
+
+// fallbackResult returns the result of a wallet whose balance could not be fetched over RPC because of
+// err: its balance from the Etherscan API if that succeeds, or else the error.
+func (c *WalletBalanceCollector) fallbackResult(ctx context.Context, endpoint EndpointConfig, wallet WalletConfig, err error) fetchResult {
+	balance, ok := c.fetchFallback(ctx, endpoint, wallet)
+	if !ok {
+		return fetchResult{endpoint: endpoint, wallet: wallet, err: err}
+	}
+	return fetchResult{endpoint: endpoint, wallet: wallet, balance: balance * wallet.factor(), source: sourceEtherscan, fetchedAt: time.Now()}
+}
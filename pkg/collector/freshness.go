@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimestampMode controls which balances carry the time they were fetched as their sample timestamp.
+type TimestampMode string
+
+const (
+	// TimestampRefresh timestamps balances when they come from background refreshes.
+	TimestampRefresh TimestampMode = "refresh"
+	// TimestampAlways timestamps every balance, including those held with STALE_BEHAVIOR=hold.
+	TimestampAlways TimestampMode = "always"
+	// TimestampNever exports every balance with the scrape time.
+	TimestampNever TimestampMode = "never"
+)
+
+// ParseTimestampMode parses the BALANCE_TIMESTAMPS environment variable. An empty value selects
+// TimestampRefresh.
+func ParseTimestampMode(value string) (TimestampMode, error) {
+	switch mode := TimestampMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "":
+		return TimestampRefresh, nil
+	case TimestampRefresh, TimestampAlways, TimestampNever:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid BALANCE_TIMESTAMPS: %s (must be one of refresh, always, never)", value)
+	}
+}
+
+// expired reports whether a balance fetched at fetchedAt is older than MaxBalanceAge and must no
+// longer be exported.
+func (c *WalletBalanceCollector) expired(fetchedAt time.Time) bool {
+	return c.config.MaxBalanceAge > 0 && !fetchedAt.IsZero() && time.Since(fetchedAt) > c.config.MaxBalanceAge
+}
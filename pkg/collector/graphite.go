@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphiteTimeout bounds connecting and sending balances to Graphite.
+const graphiteTimeout = 10 * time.Second
+
+// DefaultGraphitePrefix is the metric path prefix used when GRAPHITE_PREFIX is not set.
+const DefaultGraphitePrefix = "eth_balance_exporter"
+
+// graphiteUnsafe matches characters that are not safe in a Graphite metric path component.
+var graphiteUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ParseGraphiteAddress parses the GRAPHITE_ADDRESS environment variable. An empty value disables Graphite output.
+func ParseGraphiteAddress(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	if _, port, err := net.SplitHostPort(value); err != nil || port == "" {
+		return "", fmt.Errorf("invalid Graphite address: %s (must be host:port, e.g. graphite:2003)", value)
+	}
+	return value, nil
+}
+
+// graphitePath returns the metric path of a wallet's balance on a provider:
+// <prefix>.<chain_id>.<provider>.<wallet>.balance_eth.
+func graphitePath(prefix, chainID, provider, walletAddress string) string {
+	if chainID == "" {
+		chainID = "unknown"
+	}
+	return strings.Join([]string{
+		prefix,
+		graphiteUnsafe.ReplaceAllString(chainID, "_"),
+		graphiteUnsafe.ReplaceAllString(provider, "_"),
+		graphiteUnsafe.ReplaceAllString(strings.ToLower(walletAddress), "_"),
+		"balance_eth",
+	}, ".")
+}
+
+// sendGraphite sends the successfully fetched balances to Graphite using the plaintext protocol.
+// Failed fetches are skipped, leaving a gap in the Graphite series. Sends run one at a time.
+func (c *WalletBalanceCollector) sendGraphite(results []fetchResult, at time.Time) {
+	c.graphiteMutex.Lock()
+	defer c.graphiteMutex.Unlock()
+
+	conn, err := net.DialTimeout("tcp", c.config.GraphiteAddress, graphiteTimeout)
+	if err != nil {
+		log.Printf("Error connecting to Graphite at %s: %v", c.config.GraphiteAddress, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(graphiteTimeout))
+
+	w := bufio.NewWriter(conn)
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		path := graphitePath(c.config.GraphitePrefix, c.chainLabel(result.endpoint), result.endpoint.Name, result.wallet.Address)
+		fmt.Fprintf(w, "%s %s %s\n", path, strconv.FormatFloat(result.balance, 'g', -1, 64), timestamp)
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("Error sending balances to Graphite at %s: %v", c.config.GraphiteAddress, err)
+	}
+}
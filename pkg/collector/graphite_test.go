@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestGraphitePath(t *testing.T) {
+	tests := []struct {
+		chainID, provider, wallet string
+		want                      string
+	}{
+		{"1", "alchemy-mainnet", "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", "prefix.1.alchemy-mainnet.0x742d35cc6634c0532925a3b844bc454e4438f44e.balance_eth"},
+		{"", "infura", "0xAB", "prefix.unknown.infura.0xab.balance_eth"},
+		{"137", "my provider.eu", "0xab", "prefix.137.my_provider_eu.0xab.balance_eth"},
+	}
+	for _, test := range tests {
+		if got := graphitePath("prefix", test.chainID, test.provider, test.wallet); got != test.want {
+			t.Errorf("graphitePath(%q, %q, %q) = %s, want %s", test.chainID, test.provider, test.wallet, got, test.want)
+		}
+	}
+}
+
+// TestSendGraphite checks that a wallet monitored on two endpoints of a chain is sent once per endpoint,
+// and that failed fetches are left out.
+func TestSendGraphite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	first := EndpointConfig{Name: "first", URL: "http://first.invalid", ExpectedChainID: "1", Wallets: []WalletConfig{wallet}}
+	second := EndpointConfig{Name: "second", URL: "http://second.invalid", ExpectedChainID: "1", Wallets: []WalletConfig{wallet}}
+	failed := EndpointConfig{Name: "failed", URL: "http://failed.invalid", ExpectedChainID: "1", Wallets: []WalletConfig{wallet}}
+	c := New([]EndpointConfig{first, second, failed}, CollectorConfig{GraphiteAddress: listener.Addr().String()})
+
+	c.sendGraphite([]fetchResult{
+		{endpoint: first, wallet: wallet, balance: 1.5},
+		{endpoint: second, wallet: wallet, balance: 2},
+		{endpoint: failed, wallet: wallet, err: errors.New("rate limited")},
+	}, time.Unix(1767225600, 0))
+
+	var lines []string
+	select {
+	case data := <-received:
+		lines = strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+	want := []string{
+		"eth_balance_exporter.1.first.0x742d35cc6634c0532925a3b844bc454e4438f44e.balance_eth 1.5 1767225600",
+		"eth_balance_exporter.1.second.0x742d35cc6634c0532925a3b844bc454e4438f44e.balance_eth 2 1767225600",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("sent %q, want %q", lines, want)
+	}
+}
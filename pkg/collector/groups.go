@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultGroup is the group of wallets on an aggregated endpoint that do not name one.
+const defaultGroup = "ungrouped"
+
+// groupKey identifies a group total. Groups of the same name on different chains are kept apart.
+type groupKey struct {
+	group   string
+	chainID string
+}
+
+// groupTotal is the sum of the balances of a group's wallets, and the number of wallets whose fetch failed.
+type groupTotal struct {
+	balance  float64
+	failures int
+}
+
+// groupTotals sums the balances of wallets on aggregated endpoints per group and chain.
+type groupTotals map[groupKey]groupTotal
+
+// walletGroup returns the group that the wallet's balance is summed into.
+func walletGroup(wallet WalletConfig) string {
+	if wallet.Group == "" {
+		return defaultGroup
+	}
+	return wallet.Group
+}
+
+// addGroupResult adds a fetched wallet to its group total. A failed fetch counts as a failure and adds
+// what STALE_BEHAVIOR would have exported for the wallet: its last known balance unless older than
+// MAX_BALANCE_AGE, NaN, or nothing.
+func (c *WalletBalanceCollector) addGroupResult(totals groupTotals, result fetchResult) {
+	key := groupKey{group: walletGroup(result.wallet), chainID: c.chainLabel(result.endpoint)}
+	total := totals[key]
+	if result.err == nil {
+		total.balance += result.balance
+	} else {
+		total.failures++
+		switch c.config.StaleBehavior {
+		case StaleHold:
+			c.cacheMutex.Lock()
+			last, ok := c.lastBalances[balanceKey(result.endpoint.URL, result.wallet.Address)]
+			c.cacheMutex.Unlock()
+			if ok && !c.expired(last.fetchedAt) {
+				total.balance += last.balance
+			}
+		case StaleNaN:
+			total.balance = math.NaN()
+		}
+	}
+	totals[key] = total
+}
+
+// collectGroups emits the balance and the number of failed fetches of each group.
+func (c *WalletBalanceCollector) collectGroups(ch chan<- prometheus.Metric, totals groupTotals) {
+	for key, total := range totals {
+		ch <- prometheus.MustNewConstMetric(c.groupMetric, prometheus.GaugeValue, total.balance, key.group, key.chainID)
+		ch <- prometheus.MustNewConstMetric(c.groupFailures, prometheus.GaugeValue, float64(total.failures), key.group, key.chainID)
+	}
+}
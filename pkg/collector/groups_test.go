@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAddGroupResultStaleHold checks that with STALE_BEHAVIOR=hold, a failed fetch adds the wallet's last
+// known balance to its group total unless the balance is older than MAX_BALANCE_AGE, as for wallet_balance_eth.
+func TestAddGroupResultStaleHold(t *testing.T) {
+	tests := []struct {
+		name string
+		age  time.Duration
+		want float64
+	}{
+		{"fresh balance", time.Minute, 3},
+		{"expired balance", 2 * time.Hour, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := EndpointConfig{Name: "stub", URL: "http://localhost:8545", Aggregate: true}
+			c := New([]EndpointConfig{endpoint}, CollectorConfig{StaleBehavior: StaleHold, MaxBalanceAge: time.Hour})
+
+			failing := WalletConfig{Address: "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", Group: "treasury"}
+			c.lastBalances[balanceKey(endpoint.URL, failing.Address)] = fetchResult{
+				endpoint:  endpoint,
+				wallet:    failing,
+				balance:   2,
+				fetchedAt: time.Now().Add(-test.age),
+			}
+
+			totals := make(groupTotals)
+			c.addGroupResult(totals, fetchResult{endpoint: endpoint, wallet: WalletConfig{Address: "0x0000000000000000000000000000000000000001", Group: "treasury"}, balance: 1})
+			c.addGroupResult(totals, fetchResult{endpoint: endpoint, wallet: failing, err: errors.New("timeout")})
+
+			total := totals[groupKey{group: "treasury", chainID: c.chainLabel(endpoint)}]
+			if total.balance != test.want || total.failures != 1 {
+				t.Errorf("group total = %v with %d failures, want %v with 1", total.balance, total.failures, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ParseGRPCHealthPort parses the GRPC_HEALTH_PORT environment variable. An empty value disables the
+// gRPC health service and returns 0.
+func ParseGRPCHealthPort(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid gRPC health port: %s (must be between 1 and 65535)", value)
+	}
+	return port, nil
+}
+
+// GRPCHealthServer returns a gRPC server with the standard health service (grpc.health.v1), which
+// reports the collector's health for the empty service name: NOT_SERVING until a balance has been
+// fetched, then SERVING, and NOT_SERVING again while the latest fetch reached no endpoint.
+func (c *WalletBalanceCollector) GRPCHealthServer() *grpc.Server {
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, c.health)
+	return server
+}
+
+// newHealthServer creates the health service, NOT_SERVING until the first balance is fetched.
+func newHealthServer() *health.Server {
+	server := health.NewServer()
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return server
+}
+
+// updateHealth sets the health status from the results of a fetch: SERVING if any balance was fetched,
+// and NOT_SERVING if every wallet failed, as then every endpoint is down.
+func (c *WalletBalanceCollector) updateHealth(results []fetchResult) {
+	if len(results) == 0 {
+		return
+	}
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	for _, result := range results {
+		if result.err == nil {
+			status = healthpb.HealthCheckResponse_SERVING
+			break
+		}
+	}
+	c.health.SetServingStatus("", status)
+}
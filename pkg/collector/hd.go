@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// maxDerivedWallets caps the addresses derived from a single xpub, as each one is queried on every collection.
+const maxDerivedWallets = 100000
+
+// base58Alphabet is the Bitcoin base58 alphabet used to encode extended keys.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// extendedKey is a BIP-32 extended public key.
+type extendedKey struct {
+	publicKey *ecdsa.PublicKey
+	chainCode []byte
+}
+
+// deriveWallets returns the wallets derived from the xpub at indexes Start to Start+Count-1.
+func deriveWallets(config XPubConfig) ([]WalletConfig, error) {
+	if config.Count == 0 || config.Count > maxDerivedWallets {
+		return nil, fmt.Errorf("invalid count: %d (must be between 1 and %d)", config.Count, maxDerivedWallets)
+	}
+	if uint64(config.Start)+uint64(config.Count) > 1<<31 {
+		return nil, fmt.Errorf("invalid range: %d addresses from index %d exceed the non-hardened indexes", config.Count, config.Start)
+	}
+
+	key, err := parseXPub(config.XPub)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := make([]WalletConfig, 0, config.Count)
+	for index := config.Start; index < config.Start+config.Count; index++ {
+		publicKey, err := key.child(index)
+		if err != nil {
+			// BIP-32 skips indexes that yield an invalid key; this happens with probability below 2^-127
+			continue
+		}
+		address := crypto.PubkeyToAddress(*publicKey)
+		wallets = append(wallets, WalletConfig{
+			Address:         address.Hex(),
+			parsed:          address,
+			Name:            config.Name,
+			Group:           config.Group,
+			Tags:            config.Tags,
+			RefreshInterval: config.RefreshInterval,
+			Derived:         true,
+			DerivationIndex: index,
+		})
+	}
+	return wallets, nil
+}
+
+// parseXPub decodes a base58check-encoded BIP-32 extended public key.
+func parseXPub(xpub string) (*extendedKey, error) {
+	data, err := decodeBase58Check(strings.TrimSpace(xpub))
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpub: %v", err)
+	}
+	// version (4) | depth (1) | parent fingerprint (4) | child number (4) | chain code (32) | key (33)
+	if len(data) != 78 {
+		return nil, fmt.Errorf("invalid xpub: %d bytes (expected 78)", len(data))
+	}
+	if data[45] != 0x02 && data[45] != 0x03 {
+		return nil, errors.New("invalid xpub: not an extended public key (never configure an extended private key)")
+	}
+
+	publicKey, err := crypto.DecompressPubkey(data[45:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpub: %v", err)
+	}
+	return &extendedKey{publicKey: publicKey, chainCode: data[13:45]}, nil
+}
+
+// child derives the non-hardened child public key at index (BIP-32 CKDpub).
+func (k *extendedKey) child(index uint32) (*ecdsa.PublicKey, error) {
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(crypto.CompressPubkey(k.publicKey))
+	mac.Write(binary.BigEndian.AppendUint32(nil, index))
+	sum := mac.Sum(nil)
+
+	curve := crypto.S256()
+	if new(big.Int).SetBytes(sum[:32]).Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+
+	x, y := curve.ScalarBaseMult(sum[:32])
+	x, y = curve.Add(x, y, k.publicKey.X, k.publicKey.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// decodeBase58Check decodes base58 data and verifies and strips its 4-byte double SHA-256 checksum.
+func decodeBase58Check(encoded string) ([]byte, error) {
+	value := new(big.Int)
+	for _, r := range encoded {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		value.Mul(value, big.NewInt(58)).Add(value, big.NewInt(int64(digit)))
+	}
+
+	// Leading zero bytes are encoded as leading '1' characters
+	zeros := len(encoded) - len(strings.TrimLeft(encoded, "1"))
+	data := append(make([]byte, zeros), value.Bytes()...)
+	if len(data) < 4 {
+		return nil, errors.New("too short")
+	}
+
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return payload, nil
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Extended public keys of BIP-32 test vector 1, by derivation path.
+const (
+	vector1Hardened        = "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw" // m/0H
+	vector1Child           = "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ" // m/0H/1
+	vector1Grandchild      = "xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5" // m/0H/1/2H
+	vector1GreatGrandchild = "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV" // m/0H/1/2H/2
+	vector1Last            = "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy" // m/0H/1/2H/2/1000000000
+)
+
+// encodeBase58Check is the inverse of decodeBase58Check.
+func encodeBase58Check(payload []byte) string {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	data := append(append([]byte{}, payload...), second[:4]...)
+
+	var encoded []byte
+	value := new(big.Int).SetBytes(data)
+	for value.Sign() > 0 {
+		digit := new(big.Int)
+		value.DivMod(value, big.NewInt(58), digit)
+		encoded = append([]byte{base58Alphabet[digit.Int64()]}, encoded...)
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append([]byte{'1'}, encoded...)
+	}
+	return string(encoded)
+}
+
+// xpubAddress returns the address of the public key of an extended public key.
+func xpubAddress(t *testing.T, xpub string) common.Address {
+	t.Helper()
+	key, err := parseXPub(xpub)
+	if err != nil {
+		t.Fatalf("parseXPub(%s) error = %v", xpub, err)
+	}
+	return crypto.PubkeyToAddress(*key.publicKey)
+}
+
+// TestDeriveWalletsVector1 derives the non-hardened children of BIP-32 test vector 1 and compares their
+// addresses with those of the vector's extended public keys.
+func TestDeriveWalletsVector1(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+		index  uint32
+		child  string
+	}{
+		{"m/0H/1", vector1Hardened, 1, vector1Child},
+		{"m/0H/1/2H/2", vector1Grandchild, 2, vector1GreatGrandchild},
+		{"m/0H/1/2H/2/1000000000", vector1GreatGrandchild, 1000000000, vector1Last},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wallets, err := deriveWallets(XPubConfig{XPub: test.parent, Start: test.index, Count: 1, Name: "cold"})
+			if err != nil {
+				t.Fatalf("deriveWallets() error = %v", err)
+			}
+			if len(wallets) != 1 {
+				t.Fatalf("deriveWallets() returned %d wallets, want 1", len(wallets))
+			}
+			want := xpubAddress(t, test.child)
+			wallet := wallets[0]
+			if wallet.Address != want.Hex() || wallet.parsed != want {
+				t.Errorf("derived %s, want %s", wallet.Address, want.Hex())
+			}
+			if !wallet.Derived || wallet.DerivationIndex != test.index || wallet.Name != "cold" {
+				t.Errorf("derived wallet %+v, want derived at index %d and named cold", wallet, test.index)
+			}
+		})
+	}
+}
+
+func TestParseXPubRejected(t *testing.T) {
+	payload, err := decodeBase58Check(vector1Hardened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The extended private key of the same node has the version 0x0488ade4 and a 0x00-prefixed private key
+	xprv := append(common.FromHex("0x0488ade4"), payload[4:45]...)
+	xprv = append(append(xprv, 0x00), common.FromHex("0xedb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea")...)
+
+	last := vector1Hardened[len(vector1Hardened)-1:]
+	replacement := "x"
+	if last == replacement {
+		replacement = "y"
+	}
+
+	tests := []struct {
+		name string
+		xpub string
+		want string
+	}{
+		{"checksum mismatch", strings.TrimSuffix(vector1Hardened, last) + replacement, "checksum mismatch"},
+		{"invalid character", "0" + vector1Hardened[1:], "invalid base58 character"},
+		{"extended private key", encodeBase58Check(xprv), "never configure an extended private key"},
+		{"wrong length", encodeBase58Check(payload[:77]), "77 bytes"},
+		{"too short", "1", "too short"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseXPub(test.xpub)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseXPub() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBase58CheckLeadingZeros(t *testing.T) {
+	payload := []byte{0, 0, 1, 2, 3}
+	encoded := encodeBase58Check(payload)
+	if !strings.HasPrefix(encoded, "11") {
+		t.Fatalf("encodeBase58Check(%x) = %s, want two leading 1s", payload, encoded)
+	}
+	decoded, err := decodeBase58Check(encoded)
+	if err != nil {
+		t.Fatalf("decodeBase58Check(%s) error = %v", encoded, err)
+	}
+	if string(decoded) != string(payload) {
+		t.Errorf("decodeBase58Check(%s) = %x, want %x", encoded, decoded, payload)
+	}
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// httpStatusError is the error of an HTTP API answering with a status other than 2xx.
+type httpStatusError struct {
+	service    string
+	status     string
+	statusCode int
+	// body is the start of the response body, which usually explains the error.
+	body string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s returned HTTP %s: %s", e.service, e.status, e.body)
+}
+
+// doHTTP sends a request to the HTTP API of service, such as Vault, and returns the response if its
+// status is 2xx. Otherwise the response is closed and an *httpStatusError is returned. Errors sending
+// the request do not include the request URL, as it may contain credentials.
+func doHTTP(client *http.Client, request *http.Request, service string) (*http.Response, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+
+	if response.StatusCode/100 != 2 {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return nil, &httpStatusError{
+			service:    service,
+			status:     response.Status,
+			statusCode: response.StatusCode,
+			body:       strings.TrimSpace(string(body)),
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			_, _ = w.Write([]byte("balances"))
+		case "/long":
+			http.Error(w, strings.Repeat("x", 1000), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "permission denied", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	get := func(path string) (*http.Response, error) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doHTTP(server.Client(), request, "Vault")
+	}
+
+	response, err := get("/ok")
+	if err != nil {
+		t.Fatalf("doHTTP() error = %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "balances" {
+		t.Errorf("doHTTP() body = %q, want balances", body)
+	}
+
+	_, err = get("/denied")
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.statusCode != http.StatusForbidden {
+		t.Fatalf("doHTTP() error = %v, want an HTTP 403 status error", err)
+	}
+	if want := "Vault returned HTTP 403 Forbidden: permission denied"; err.Error() != want {
+		t.Errorf("doHTTP() error = %q, want %q", err, want)
+	}
+
+	if _, err = get("/long"); !errors.As(err, &statusErr) || len(statusErr.body) != 512 {
+		t.Errorf("doHTTP() error = %v, want the first 512 bytes of the body", err)
+	}
+}
+
+// TestDoHTTPDropsURL checks that an error sending a request does not include the request URL.
+func TestDoHTTPDropsURL(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	address := server.URL
+	server.Close()
+
+	request, err := http.NewRequest(http.MethodGet, address+"/v1/secret?token=s3cret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doHTTP(http.DefaultClient, request, "Vault")
+	if err == nil {
+		t.Fatal("doHTTP() succeeded on a closed server")
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("doHTTP() error %q includes the request URL", err)
+	}
+}
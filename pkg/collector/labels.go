@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// labelNamePattern matches valid Prometheus label names. Names starting with __ are reserved.
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// builtinWalletLabels are the labels of wallet metrics that templated labels and wallet tags may not
+// replace. They include the provider and name labels added with DUPLICATE_WALLETS=provider and the
+// naming service, which a label of the same name would otherwise silently take the place of.
+var builtinWalletLabels = []string{"wallet", "chain_id", "date", "derivation_index", "token", "asset", "currency", "source", "verified", "collection", "symbol", "provider", "name"}
+
+// AddressCase selects how wallet addresses are rendered in the wallet label.
+type AddressCase string
+
+const (
+	// AddressChecksum renders addresses with their EIP-55 checksum casing.
+	AddressChecksum AddressCase = "checksum"
+	// AddressLower renders addresses in lowercase.
+	AddressLower AddressCase = "lower"
+	// AddressOriginal renders addresses as they were entered in the configuration, prefixed with 0x.
+	AddressOriginal AddressCase = "original"
+)
+
+// ParseAddressCase parses the LABEL_ADDRESS_CASE environment variable. An empty value selects
+// AddressChecksum.
+func ParseAddressCase(value string) (AddressCase, error) {
+	switch addressCase := AddressCase(strings.ToLower(strings.TrimSpace(value))); addressCase {
+	case "":
+		return AddressChecksum, nil
+	case AddressChecksum, AddressLower, AddressOriginal:
+		return addressCase, nil
+	default:
+		return "", fmt.Errorf("invalid LABEL_ADDRESS_CASE: %s (must be one of checksum, lower, original)", value)
+	}
+}
+
+// addressLabel renders a wallet address as the value of the wallet label, according to AddressCase.
+func (c *WalletBalanceCollector) addressLabel(address string) string {
+	switch c.config.AddressCase {
+	case AddressLower:
+		return strings.ToLower(address)
+	case AddressOriginal:
+		return address
+	default:
+		return common.HexToAddress(address).Hex()
+	}
+}
+
+// LabelTemplate is an additional label of wallet metrics whose value is rendered from a template.
+type LabelTemplate struct {
+	Name     string
+	Template *template.Template
+}
+
+// LabelData is the data a label template is executed with.
+type LabelData struct {
+	Wallet          string
+	Name            string
+	ChainID         string
+	Provider        string
+	DerivationIndex string
+	Tags            map[string]string
+}
+
+// ParseLabelTemplates parses the WALLET_LABELS environment variable: semicolon-separated label=template
+// pairs such as team={{.Name}};network={{.ChainID}}-{{.Provider}}. Each template is executed once with
+// empty data, so that references to unknown fields fail at startup.
+func ParseLabelTemplates(value string) ([]LabelTemplate, error) {
+	var templates []LabelTemplate
+	seen := make(map[string]bool)
+	for _, builtin := range builtinWalletLabels {
+		seen[builtin] = true
+	}
+
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		name, text, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label template: %s (expected label=template with a valid label name)", pair)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid label template: %s (label %s is already used)", pair, name)
+		}
+		seen[name] = true
+
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for label %s: %v", name, err)
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, LabelData{}); err != nil {
+			return nil, fmt.Errorf("invalid template for label %s: %v", name, err)
+		}
+		templates = append(templates, LabelTemplate{Name: name, Template: tmpl})
+	}
+	return templates, nil
+}
+
+// walletLabelNames returns the labels of a wallet metric: its builtin labels followed by the templated ones.
+func walletLabelNames(templates []LabelTemplate, builtin ...string) []string {
+	for _, label := range templates {
+		builtin = append(builtin, label.Name)
+	}
+	return builtin
+}
+
+// walletLabelValues returns the label values of a wallet metric: the builtin values followed by the
+// templated ones rendered for the wallet. A template that fails to execute yields an empty value.
+func (c *WalletBalanceCollector) walletLabelValues(endpoint EndpointConfig, wallet WalletConfig, chainID string, builtin ...string) []string {
+	data := LabelData{
+		Wallet:          c.addressLabel(wallet.Address),
+		Name:            c.walletName(wallet),
+		ChainID:         chainID,
+		Provider:        endpoint.Name,
+		DerivationIndex: wallet.derivationLabel(),
+		Tags:            wallet.Tags,
+	}
+
+	for _, label := range c.config.LabelTemplates {
+		var value bytes.Buffer
+		if err := label.Template.Execute(&value, data); err != nil {
+			log.Printf("Error rendering label %s for wallet %s: %v", label.Name, wallet.Address, err)
+			value.Reset()
+		}
+		builtin = append(builtin, value.String())
+	}
+	return builtin
+}
+
+// validateWalletTags checks the names of a wallet's tags, which become labels of its metrics.
+func validateWalletTags(tags map[string]string) error {
+	for name := range tags {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid tag name: %s (must be a valid label name)", name)
+		}
+		if slices.Contains(builtinWalletLabels, name) {
+			return fmt.Errorf("invalid tag name: %s (the label is already used)", name)
+		}
+	}
+	return nil
+}
+
+// walletTagNames returns the sorted names of the tags of all wallets. Every wallet metric carries all
+// of them, so that the label set is the same for every wallet.
+func walletTagNames(endpoints []EndpointConfig) []string {
+	var names []string
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			for name := range wallet.Tags {
+				if !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// withTagLabels adds a label rendering each wallet's tag to the label templates for each of the tag
+// names, unless WALLET_LABELS already defines the label. Wallets without the tag get an empty value.
+func withTagLabels(templates []LabelTemplate, names []string) []LabelTemplate {
+	templates = slices.Clone(templates)
+	for _, name := range names {
+		if slices.ContainsFunc(templates, func(label LabelTemplate) bool { return label.Name == name }) {
+			continue
+		}
+		tmpl := template.Must(template.New(name).Parse(fmt.Sprintf("{{index .Tags %q}}", name)))
+		templates = append(templates, LabelTemplate{Name: name, Template: tmpl})
+	}
+	return templates
+}
+
+// builtinEndpointLabels are the labels of endpoint metrics that static endpoint labels may not replace.
+var builtinEndpointLabels = []string{"provider", "reason", "method"}
+
+// validateEndpointLabels checks the names of an endpoint's static labels.
+func validateEndpointLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name: %s", name)
+		}
+		if slices.Contains(builtinEndpointLabels, name) {
+			return fmt.Errorf("invalid label name: %s (the label is already used)", name)
+		}
+	}
+	return nil
+}
+
+// endpointLabelNames returns the sorted names of the static labels of all endpoints. Every endpoint
+// metric carries all of them, so that the label set is the same for every endpoint.
+func endpointLabelNames(endpoints []EndpointConfig) []string {
+	var names []string
+	for _, endpoint := range endpoints {
+		for name := range endpoint.Labels {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// endpointLabelValues returns the label values of an endpoint metric: the builtin values followed by
+// the endpoint's static labels. Labels that only other endpoints set are empty.
+func (c *WalletBalanceCollector) endpointLabelValues(endpoint EndpointConfig, builtin ...string) []string {
+	for _, name := range c.endpointLabels {
+		builtin = append(builtin, endpoint.Labels[name])
+	}
+	return builtin
+}
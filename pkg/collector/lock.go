@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// errFetchLocked is the error of wallets that a collection did not fetch because another fetch held
+// the fetch lock for longer than the lock timeout.
+var errFetchLocked = errors.New("balances not fetched: a previous fetch is still in progress")
+
+// lockFetches takes the fetch lock, waiting at most LockTimeout for a fetch in progress to finish. It
+// returns false without holding the lock, and counts the timeout in collect_lock_timeout_total, if
+// the wait times out or ctx is done first, so that a stuck fetch never blocks scrapes indefinitely.
+func (c *WalletBalanceCollector) lockFetches(ctx context.Context) bool {
+	timer := time.NewTimer(c.config.LockTimeout)
+	defer timer.Stop()
+
+	select {
+	case c.fetchLock <- struct{}{}:
+		return true
+	case <-timer.C:
+		log.Printf("Skipping fetch, as a previous fetch is still in progress after %s", c.config.LockTimeout)
+		c.lockTimeouts.Inc()
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// unlockFetches releases the fetch lock.
+func (c *WalletBalanceCollector) unlockFetches() {
+	<-c.fetchLock
+}
+
+// lockedResults reports every wallet on the endpoints as failed with errFetchLocked, so that they are
+// exported according to STALE_BEHAVIOR.
+func lockedResults(endpoints []EndpointConfig) []fetchResult {
+	var results []fetchResult
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			results = append(results, fetchResult{endpoint: endpoint, wallet: wallet, err: errFetchLocked})
+		}
+	}
+	return results
+}
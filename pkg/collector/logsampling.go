@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// logSampler collapses repeated log messages, such as the same error for every wallet of a provider
+// that is down, into one message and a count of the similar messages suppressed within a window.
+type logSampler struct {
+	window time.Duration
+
+	mutex      sync.Mutex
+	suppressed map[string]int
+}
+
+// newLogSampler creates a sampler with the window. A zero window logs every message.
+func newLogSampler(window time.Duration) *logSampler {
+	return &logSampler{window: window, suppressed: make(map[string]int)}
+}
+
+// printf logs the message unless a message with the same format and key was logged within the window,
+// in which case it is counted instead. The key is a stable identifier of the source of the message, such
+// as the provider's name and the class of its error, so that messages differing only in details, such
+// as the wallet address or the text of the error, are similar. When the window of the logged message
+// ends, the number of similar messages suppressed is logged with it, and the next one is logged again.
+func (s *logSampler) printf(key, format string, args ...any) {
+	if s.window <= 0 {
+		log.Printf(format, args...)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key = format + "\xff" + key
+	if _, sampled := s.suppressed[key]; sampled {
+		s.suppressed[key]++
+		return
+	}
+	s.suppressed[key] = 0
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+
+	time.AfterFunc(s.window, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if count := s.suppressed[key]; count > 0 {
+			log.Printf("%d similar messages suppressed in the last %s: %s", count, s.window, message)
+		}
+		delete(s.suppressed, key)
+	})
+}
+
+// logEndpointError logs an error of the endpoint, sampled by the endpoint's name and the error's reason,
+// as reported by endpointStatusReason.
+func (c *WalletBalanceCollector) logEndpointError(endpoint EndpointConfig, err error, format string, args ...any) {
+	c.logs.printf(endpoint.Name+"\xff"+c.endpointStatusReason(err), format, args...)
+}
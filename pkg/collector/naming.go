@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// namingTimeout bounds each request to the naming service.
+const namingTimeout = 30 * time.Second
+
+// DefaultNamingServiceInterval is the interval between name lookups used when NAMING_SERVICE_INTERVAL
+// is not set.
+const DefaultNamingServiceInterval = 10 * time.Minute
+
+// ParseNamingServiceURL parses the NAMING_SERVICE_URL environment variable. An empty value disables
+// the naming service.
+func ParseNamingServiceURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return "", fmt.Errorf("invalid naming service URL: %s (must start with http:// or https://)", redactURL(value))
+	}
+	return value, nil
+}
+
+// namingService resolves friendly names for wallet addresses from an external HTTP service and
+// caches them, so that collections never wait for the service.
+type namingService struct {
+	url    string
+	client *http.Client
+
+	// names holds the last resolved name per lower-case address. It is guarded by mutex.
+	mutex sync.Mutex
+	names map[string]string
+}
+
+// newNamingService creates a naming service client for url.
+func newNamingService(url string) *namingService {
+	return &namingService{
+		url:    url,
+		client: &http.Client{Timeout: namingTimeout},
+		names:  make(map[string]string),
+	}
+}
+
+// name returns the cached name of the address, if the service has resolved one.
+func (n *namingService) name(address string) (string, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	name, ok := n.names[strings.ToLower(address)]
+	return name, ok
+}
+
+// resolve posts the addresses to the service as a JSON array and replaces the cached names with the
+// JSON object of address to name it returns. Addresses missing from the response lose their name. On
+// error the cache is left unchanged, so that names survive an outage of the service.
+func (n *namingService) resolve(addresses []string) error {
+	body, err := json.Marshal(addresses)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := doHTTP(n.client, request, "naming service")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var resolved map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&resolved); err != nil {
+		return fmt.Errorf("invalid naming service response: %v", err)
+	}
+
+	names := make(map[string]string, len(resolved))
+	for address, name := range resolved {
+		if name = strings.TrimSpace(name); name != "" {
+			names[strings.ToLower(address)] = name
+		}
+	}
+
+	n.mutex.Lock()
+	n.names = names
+	n.mutex.Unlock()
+	return nil
+}
+
+// RunNamingService resolves the names of all monitored wallets from the naming service, waiting the
+// interval between lookups so that names changed in the registry and wallets added through the admin
+// API are picked up. It requires CollectorConfig.NamingServiceURL to be set and never returns.
+func (c *WalletBalanceCollector) RunNamingService(interval time.Duration) {
+	for {
+		var addresses []string
+		seen := make(map[string]bool)
+		for _, endpoint := range c.getEndpoints() {
+			for _, wallet := range endpoint.Wallets {
+				if address := strings.ToLower(wallet.Address); !seen[address] {
+					seen[address] = true
+					addresses = append(addresses, wallet.Address)
+				}
+			}
+		}
+
+		if err := c.names.resolve(addresses); err != nil {
+			log.Printf("Error resolving wallet names from %s: %v", redactURL(c.names.url), err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// walletName returns the name of a wallet: its configured name, or else the name resolved by the
+// naming service. With a naming service configured, wallets it has no name for are named by their
+// address, so that the name label is never empty.
+func (c *WalletBalanceCollector) walletName(wallet WalletConfig) string {
+	if wallet.Name != "" || c.names == nil {
+		return wallet.Name
+	}
+	if name, ok := c.names.name(wallet.Address); ok {
+		return name
+	}
+	return c.addressLabel(wallet.Address)
+}
+
+// withNameLabel adds a name label rendering each wallet's name to the label templates.
+func withNameLabel(templates []LabelTemplate) []LabelTemplate {
+	tmpl := template.Must(template.New("name").Parse("{{.Name}}"))
+	return append(slices.Clone(templates), LabelTemplate{Name: "name", Template: tmpl})
+}
@@ -0,0 +1,130 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// erc1155BalanceOfSelector is the selector of the ERC-1155 balanceOf(address,uint256) method. ERC-721
+// collections share balanceOf(address) with ERC-20 tokens.
+var erc1155BalanceOfSelector = common.FromHex("0x00fdd58e")
+
+// NFTConfig describes an NFT collection whose tokens held by every wallet of an endpoint are counted.
+type NFTConfig struct {
+	// Collection is the collection label value.
+	Collection string `yaml:"collection"`
+	Address    string `yaml:"address"`
+	// TokenID selects a token of an ERC-1155 contract, whose balance is counted. Empty counts the tokens
+	// of an ERC-721 collection.
+	TokenID string `yaml:"token_id"`
+}
+
+// validate normalizes the collection's address and checks its token ID.
+func (n *NFTConfig) validate() error {
+	if n.Collection == "" {
+		return fmt.Errorf("NFT collection without a name")
+	}
+	address, err := NormalizeAddress(n.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address of NFT collection %s: %v", n.Collection, err)
+	}
+	n.Address = address
+	if n.TokenID != "" {
+		tokenID, ok := new(big.Int).SetString(n.TokenID, 10)
+		if !ok || tokenID.Sign() < 0 || tokenID.BitLen() > 256 {
+			return fmt.Errorf("invalid token_id of NFT collection %s: %s (must be a non-negative integer)", n.Collection, n.TokenID)
+		}
+		n.TokenID = tokenID.String()
+	}
+	return nil
+}
+
+// validateNFTs validates the NFT collections of an endpoint, whose names must be unique as they tell the
+// collections apart in the collection label.
+func validateNFTs(nfts []NFTConfig) error {
+	seen := make(map[string]bool)
+	for i := range nfts {
+		if err := nfts[i].validate(); err != nil {
+			return err
+		}
+		if seen[nfts[i].Collection] {
+			return fmt.Errorf("duplicate NFT collection: %s", nfts[i].Collection)
+		}
+		seen[nfts[i].Collection] = true
+	}
+	return nil
+}
+
+// callData returns the balanceOf call of the collection for the wallet.
+func (n NFTConfig) callData(wallet common.Address) []byte {
+	if n.TokenID == "" {
+		return append(append([]byte{}, balanceOfSelector...), common.LeftPadBytes(wallet.Bytes(), 32)...)
+	}
+	tokenID, _ := new(big.Int).SetString(n.TokenID, 10)
+	data := append(append([]byte{}, erc1155BalanceOfSelector...), common.LeftPadBytes(wallet.Bytes(), 32)...)
+	return append(data, common.LeftPadBytes(tokenID.Bytes(), 32)...)
+}
+
+// nftBalance is the fetched number of tokens of an NFT collection held by a wallet.
+type nftBalance struct {
+	collection string
+	count      float64
+}
+
+// fetchNFTs retrieves the number of tokens of the endpoint's NFT collections held by the wallet at
+// blockNumber, or the latest block if it is nil, in the same way as fetchTokens. Failed collections are
+// logged and omitted.
+func (c *WalletBalanceCollector) fetchNFTs(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig, blockNumber *big.Int) []nftBalance {
+	if len(endpoint.NFTs) == 0 || endpoint.Aggregate {
+		return nil
+	}
+
+	ctx, span := startRPCSpan(ctx, "eth_call", endpoint, attribute.String("wallet", wallet.Address), attribute.Int("nfts", len(endpoint.NFTs)))
+	calls := make([]multicallCall, len(endpoint.NFTs))
+	for i, nft := range endpoint.NFTs {
+		calls[i] = multicallCall{Target: common.HexToAddress(nft.Address), AllowFailure: true, CallData: nft.callData(wallet.hexAddress())}
+	}
+	results, err := callContracts(ctx, client, multicallAddress(endpoint, c.chainLabel(endpoint)), calls, blockNumber)
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		endSpan(span, err)
+		c.logEndpointError(endpoint, err, "Error retrieving NFT balances for wallet %s from provider %s: %v", wallet.Address, endpoint.Name, err)
+		return nil
+	}
+	endSpan(span, nil)
+
+	balances := make([]nftBalance, 0, len(endpoint.NFTs))
+	for i, nft := range endpoint.NFTs {
+		if len(results[i]) < 32 {
+			c.logs.printf(endpoint.Name+"\xff"+nft.Address, "Error retrieving %s balance for wallet %s from provider %s: call to %s failed", nft.Collection, wallet.Address, endpoint.Name, nft.Address)
+			continue
+		}
+		count, _ := new(big.Float).SetInt(new(big.Int).SetBytes(results[i][:32])).Float64()
+		balances = append(balances, nftBalance{collection: nft.Collection, count: count})
+	}
+	return balances
+}
+
+// collectNFTs emits the NFT balances fetched for a wallet.
+func (c *WalletBalanceCollector) collectNFTs(ch chan<- prometheus.Metric, result fetchResult) {
+	if c.expired(result.fetchedAt) {
+		return
+	}
+
+	chainID := c.chainLabel(result.endpoint)
+	for _, nft := range result.nfts {
+		ch <- c.withFetchTime(prometheus.MustNewConstMetric(
+			c.nftMetric,
+			prometheus.GaugeValue,
+			nft.count,
+			c.walletLabelValues(result.endpoint, result.wallet, chainID, c.addressLabel(result.wallet.Address), chainID, nft.collection)...,
+		), result.fetchedAt)
+	}
+}
@@ -0,0 +1,233 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// priceTimeout bounds each request to the price source.
+const priceTimeout = 30 * time.Second
+
+// priceRetryDelay is how often the coin IDs are checked again while the chain of an endpoint is unknown.
+const priceRetryDelay = 10 * time.Second
+
+// DefaultPriceURL is the CoinGecko simple price API, used when PRICE_URL is not set.
+const DefaultPriceURL = "https://api.coingecko.com/api/v3/simple/price"
+
+// DefaultPriceInterval is the interval between price lookups used when PRICE_INTERVAL is not set.
+const DefaultPriceInterval = 5 * time.Minute
+
+// currencyPattern matches ISO 4217 currency codes.
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ParseFiatCurrencies parses the FIAT_CURRENCIES environment variable: comma-separated currency codes
+// such as USD,EUR that balances are converted to. An empty value disables fiat conversion.
+func ParseFiatCurrencies(value string) ([]string, error) {
+	var currencies []string
+	for _, currency := range strings.Split(value, ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currency == "" || slices.Contains(currencies, currency) {
+			continue
+		}
+		if !currencyPattern.MatchString(currency) {
+			return nil, fmt.Errorf("invalid currency: %s (must be a three-letter code, e.g. USD)", currency)
+		}
+		currencies = append(currencies, currency)
+	}
+	return currencies, nil
+}
+
+// ParsePriceURL parses the PRICE_URL environment variable. An empty value selects DefaultPriceURL.
+func ParsePriceURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return DefaultPriceURL, nil
+	}
+
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return "", fmt.Errorf("invalid price URL: %s (must start with http:// or https://)", redactURL(value))
+	}
+	if _, err := url.Parse(value); err != nil {
+		return "", fmt.Errorf("invalid price URL: %s", redactURL(value))
+	}
+	return value, nil
+}
+
+// priceSource fetches the prices of native tokens in the configured currencies from an API compatible
+// with CoinGecko's simple price endpoint and caches them, so that collections never wait for it.
+type priceSource struct {
+	url        string
+	currencies []string
+	client     *http.Client
+
+	// prices holds the last fetched price per coin ID and currency, and updated the time they were
+	// fetched. They are guarded by mutex.
+	mutex   sync.Mutex
+	prices  map[string]map[string]float64
+	updated time.Time
+}
+
+// newPriceSource creates a price source client for url and the currencies.
+func newPriceSource(url string, currencies []string) *priceSource {
+	return &priceSource{
+		url:        url,
+		currencies: currencies,
+		client:     &http.Client{Timeout: priceTimeout},
+		prices:     make(map[string]map[string]float64),
+	}
+}
+
+// price returns the cached price of the coin in the currency, if one was fetched.
+func (p *priceSource) price(coinID, currency string) (float64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	price, ok := p.prices[coinID][currency]
+	return price, ok
+}
+
+// fetch requests the prices of the coins in all currencies at once and replaces the cached prices
+// with them, so that the prices of all currencies are always from the same lookup. On error the cache
+// is left unchanged, so that prices survive an outage of the source.
+func (p *priceSource) fetch(coinIDs []string) error {
+	requestURL, err := url.Parse(p.url)
+	if err != nil {
+		return err
+	}
+	query := requestURL.Query()
+	query.Set("ids", strings.Join(coinIDs, ","))
+	query.Set("vs_currencies", strings.ToLower(strings.Join(p.currencies, ",")))
+	requestURL.RawQuery = query.Encode()
+
+	request, err := http.NewRequest(http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := doHTTP(p.client, request, "price source")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var fetched map[string]map[string]float64
+	if err := json.NewDecoder(response.Body).Decode(&fetched); err != nil {
+		return fmt.Errorf("invalid price source response: %v", err)
+	}
+
+	prices := make(map[string]map[string]float64, len(fetched))
+	for coinID, quotes := range fetched {
+		prices[coinID] = make(map[string]float64, len(quotes))
+		for currency, price := range quotes {
+			prices[coinID][strings.ToUpper(currency)] = price
+		}
+	}
+	for _, coinID := range coinIDs {
+		if _, ok := prices[coinID]; !ok {
+			log.Printf("Price source returned no price for %s", coinID)
+		}
+	}
+
+	p.mutex.Lock()
+	p.prices = prices
+	p.updated = time.Now()
+	p.mutex.Unlock()
+	return nil
+}
+
+// priceID returns the coin ID of the endpoint's native token at the price source: its configured
+// price_id, or else the one the built-in registry knows for its chain. It is empty if neither is known.
+func (c *WalletBalanceCollector) priceID(endpoint EndpointConfig) string {
+	if endpoint.PriceID != "" {
+		return endpoint.PriceID
+	}
+	return knownChains[c.chainLabel(endpoint)].priceID
+}
+
+// RunPrices fetches the prices of the native tokens of all monitored endpoints from the price source,
+// waiting the interval between lookups. While the chain of an endpoint without price_id is not known
+// yet, such as before its first collection, the coin IDs are checked again every priceRetryDelay and
+// the prices fetched as soon as they change. It requires CollectorConfig.FiatCurrencies to be set and
+// never returns.
+func (c *WalletBalanceCollector) RunPrices(interval time.Duration) {
+	var (
+		fetched   []string
+		fetchedAt time.Time
+	)
+	for {
+		var coinIDs []string
+		unresolved := false
+		for _, endpoint := range c.getEndpoints() {
+			coinID := c.priceID(endpoint)
+			if endpoint.PriceID == "" && c.chainLabel(endpoint) == "" {
+				unresolved = true
+			}
+			if coinID != "" && !slices.Contains(coinIDs, coinID) {
+				coinIDs = append(coinIDs, coinID)
+			}
+		}
+
+		if len(coinIDs) > 0 && (!slices.Equal(coinIDs, fetched) || time.Since(fetchedAt) >= interval) {
+			if err := c.prices.fetch(coinIDs); err != nil {
+				log.Printf("Error fetching prices from %s: %v", redactURL(c.prices.url), err)
+			}
+			fetched, fetchedAt = coinIDs, time.Now()
+		}
+
+		if unresolved {
+			time.Sleep(min(interval, priceRetryDelay))
+		} else {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// collectFiat emits a wallet's balance, as exported in wallet_balance_eth, converted to each of the
+// fiat currencies for which the price of the endpoint's native token is known.
+func (c *WalletBalanceCollector) collectFiat(ch chan<- prometheus.Metric, result fetchResult, balance float64) {
+	if c.prices == nil || (balance == 0 && c.config.HideZeroBalances) {
+		return
+	}
+	coinID := c.priceID(result.endpoint)
+	if coinID == "" {
+		return
+	}
+
+	chainID := c.chainLabel(result.endpoint)
+	for _, currency := range c.prices.currencies {
+		price, ok := c.prices.price(coinID, currency)
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.fiatMetric,
+			prometheus.GaugeValue,
+			balance*price,
+			c.walletLabelValues(result.endpoint, result.wallet, chainID, c.addressLabel(result.wallet.Address), chainID, result.wallet.derivationLabel(), currency)...,
+		)
+	}
+}
+
+// collectPriceAge emits the time the prices were last fetched, once they have been.
+func (c *WalletBalanceCollector) collectPriceAge(ch chan<- prometheus.Metric) {
+	if c.prices == nil {
+		return
+	}
+	c.prices.mutex.Lock()
+	updated := c.prices.updated
+	c.prices.mutex.Unlock()
+
+	if !updated.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.priceUpdated, prometheus.GaugeValue, float64(updated.UnixNano())/1e9)
+	}
+}
@@ -0,0 +1,211 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// errInvalidProof is wrapped around the errors of balances whose Merkle proof does not verify against
+// the state root of their block, which means that the provider returned a false balance or proof.
+var errInvalidProof = errors.New("invalid balance proof")
+
+// accountProof is the part of an eth_getProof response (EIP-1186) needed to verify a balance.
+type accountProof struct {
+	Balance      *hexutil.Big    `json:"balance"`
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+}
+
+// hasProofVerification reports whether any endpoint verifies balance proofs, which adds the verified
+// label to the balance metrics.
+func hasProofVerification(endpoints []EndpointConfig) bool {
+	return slices.ContainsFunc(endpoints, func(endpoint EndpointConfig) bool { return endpoint.VerifyProofs })
+}
+
+// verified reports whether a balance was verified against the state root of its block: whether it was
+// fetched over RPC since startup from an endpoint with verify_proofs.
+func verified(endpoint EndpointConfig, source string, restored bool) bool {
+	return endpoint.VerifyProofs && source == sourceRPC && !restored
+}
+
+// getProvenBalance retrieves the balance in Wei of a wallet with eth_getProof at blockNumber, or at the
+// latest block if it is nil, and verifies the account proof against the state root of the block's
+// header. The header is fetched from the same endpoint, so a provider can only report a false balance
+// by forging the header as well.
+func (c *WalletBalanceCollector) getProvenBalance(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig, blockNumber *big.Int) (*big.Int, error) {
+	ctx, span := startRPCSpan(ctx, "eth_getProof", endpoint, attribute.String("wallet", wallet.Address))
+
+	var proof accountProof
+	header, err := client.HeaderByNumber(ctx, blockNumber)
+	if err == nil {
+		span.SetAttributes(attribute.String("block", header.Number.String()))
+		err = client.Client().CallContext(ctx, &proof, "eth_getProof", wallet.hexAddress(), []string{}, hexutil.EncodeBig(header.Number))
+	}
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		endSpan(span, err)
+		return nil, err
+	}
+
+	balanceWei, err := verifyAccountProof(header.Root, wallet.hexAddress(), proof)
+	endSpan(span, err)
+	return balanceWei, err
+}
+
+// verifyAccountProof verifies an account proof against a state root and returns the balance it proves,
+// which must be the balance reported along with it. An account proven absent from the state has a
+// balance of zero.
+func verifyAccountProof(root common.Hash, address common.Address, proof accountProof) (*big.Int, error) {
+	value, err := verifyTrieProof(root, crypto.Keccak256(address.Bytes()), proof.AccountProof)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidProof, err)
+	}
+
+	balanceWei := new(big.Int)
+	if len(value) > 0 {
+		account, err := types.FullAccount(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed account: %v", errInvalidProof, err)
+		}
+		balanceWei = account.Balance.ToBig()
+	}
+	if proof.Balance == nil {
+		return nil, fmt.Errorf("%w: provider reports no balance", errInvalidProof)
+	}
+	if proof.Balance.ToInt().Cmp(balanceWei) != 0 {
+		return nil, fmt.Errorf("%w: provider reports %s Wei, proof shows %s Wei at state root %s", errInvalidProof, proof.Balance.ToInt(), balanceWei, root.Hex())
+	}
+	return balanceWei, nil
+}
+
+// verifyTrieProof walks a Merkle Patricia trie proof, the RLP-encoded nodes on the path from the root to
+// key, and returns the value stored at key, or nil if the proof shows that the key is absent. Each node
+// must hash to the reference held by its parent, starting with the root.
+func verifyTrieProof(root common.Hash, key []byte, proof []hexutil.Bytes) ([]byte, error) {
+	path := make([]byte, 0, 2*len(key))
+	for _, b := range key {
+		path = append(path, b>>4, b&0x0f)
+	}
+
+	wanted := root.Bytes()
+	for i, encoded := range proof {
+		if !bytes.Equal(crypto.Keccak256(encoded), wanted) {
+			return nil, fmt.Errorf("proof node %d does not match the hash referencing it", i)
+		}
+
+		node := []byte(encoded)
+		for {
+			elements, err := splitTrieNode(node)
+			if err != nil {
+				return nil, fmt.Errorf("proof node %d: %v", i, err)
+			}
+
+			var child []byte
+			switch len(elements) {
+			case 17:
+				// Branch node, with a child per nibble and a value
+				if len(path) == 0 {
+					return trieValue(elements[16])
+				}
+				child, path = elements[path[0]], path[1:]
+			case 2:
+				// Extension or leaf node, whose hex-prefix encoded path is shared by the keys below it
+				nodePath, leaf, err := decodeHexPrefix(elements[0])
+				if err != nil {
+					return nil, fmt.Errorf("proof node %d: %v", i, err)
+				}
+				if !bytes.HasPrefix(path, nodePath) || (leaf && len(path) != len(nodePath)) {
+					return nil, nil
+				}
+				if leaf {
+					return trieValue(elements[1])
+				}
+				child, path = elements[1], path[len(nodePath):]
+			default:
+				return nil, fmt.Errorf("proof node %d has %d elements", i, len(elements))
+			}
+
+			kind, content, _, err := rlp.Split(child)
+			switch {
+			case err != nil:
+				return nil, fmt.Errorf("proof node %d: %v", i, err)
+			case kind == rlp.List:
+				// Nodes shorter than a hash are embedded in their parent
+				node = child
+				continue
+			case len(content) == 0:
+				return nil, nil
+			case len(content) != common.HashLength:
+				return nil, fmt.Errorf("proof node %d references a child by %d bytes", i, len(content))
+			}
+			wanted = content
+			break
+		}
+	}
+	return nil, errors.New("proof ends before reaching the key")
+}
+
+// splitTrieNode splits an RLP-encoded trie node into its raw elements.
+func splitTrieNode(node []byte) ([][]byte, error) {
+	content, _, err := rlp.SplitList(node)
+	if err != nil {
+		return nil, err
+	}
+
+	var elements [][]byte
+	for len(content) > 0 {
+		_, _, rest, err := rlp.Split(content)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, content[:len(content)-len(rest)])
+		content = rest
+	}
+	return elements, nil
+}
+
+// trieValue returns the value of a node's RLP-encoded value element, or nil if it is empty.
+func trieValue(element []byte) ([]byte, error) {
+	value, _, err := rlp.SplitString(element)
+	if err != nil || len(value) == 0 {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decodeHexPrefix decodes the RLP-encoded, hex-prefix encoded path of an extension or leaf node into
+// nibbles, reporting whether the node is a leaf.
+func decodeHexPrefix(element []byte) ([]byte, bool, error) {
+	encoded, _, err := rlp.SplitString(element)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(encoded) == 0 {
+		return nil, false, errors.New("empty node path")
+	}
+
+	flags := encoded[0] >> 4
+	if flags > 3 {
+		return nil, false, fmt.Errorf("invalid node path flags %d", flags)
+	}
+	var nibbles []byte
+	if flags&1 == 1 {
+		nibbles = append(nibbles, encoded[0]&0x0f)
+	}
+	for _, b := range encoded[1:] {
+		nibbles = append(nibbles, b>>4, b&0x0f)
+	}
+	return nibbles, flags&2 == 2, nil
+}
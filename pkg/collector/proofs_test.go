@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// genesisProofs is testdata/mainnet_genesis_proofs.json: eth_getProof responses of Ethereum mainnet at
+// block 0, for an account of the genesis allocation and for an account absent from it, along with the
+// block's state root.
+type genesisProofs struct {
+	StateRoot common.Hash `json:"stateRoot"`
+	Proofs    []struct {
+		Address common.Address `json:"address"`
+		accountProof
+	} `json:"proofs"`
+}
+
+func loadGenesisProofs(t *testing.T) genesisProofs {
+	t.Helper()
+	data, err := os.ReadFile("testdata/mainnet_genesis_proofs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proofs genesisProofs
+	if err := json.Unmarshal(data, &proofs); err != nil {
+		t.Fatal(err)
+	}
+	if proofs.StateRoot != common.HexToHash("0xd7f8974fb5ac78d9ac099b9ad5018bedc2ce0a72dad1827a1709da30580f0544") {
+		t.Fatalf("fixture has state root %s, not the one of the mainnet genesis block", proofs.StateRoot)
+	}
+	return proofs
+}
+
+func TestVerifyAccountProofMainnet(t *testing.T) {
+	proofs := loadGenesisProofs(t)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"genesis account", "200000000000000000000"},
+		{"absent account", "0"},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proof := proofs.Proofs[i]
+			balanceWei, err := verifyAccountProof(proofs.StateRoot, proof.Address, proof.accountProof)
+			if err != nil {
+				t.Fatalf("verifyAccountProof() error = %v", err)
+			}
+			if balanceWei.String() != test.want {
+				t.Errorf("verifyAccountProof() = %s Wei, want %s", balanceWei, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAccountProofRejected(t *testing.T) {
+	proofs := loadGenesisProofs(t)
+	included, absent := proofs.Proofs[0], proofs.Proofs[1]
+
+	tampered := slices.Clone(included.AccountProof)
+	tampered[2] = slices.Clone(tampered[2])
+	tampered[2][40] ^= 0x01
+
+	tests := []struct {
+		name    string
+		root    common.Hash
+		address common.Address
+		proof   accountProof
+	}{
+		{"tampered node", proofs.StateRoot, included.Address, accountProof{Balance: included.Balance, AccountProof: tampered}},
+		{"wrong root", common.HexToHash("0x01"), included.Address, included.accountProof},
+		{"proof of another account", proofs.StateRoot, absent.Address, included.accountProof},
+		{"truncated proof", proofs.StateRoot, included.Address, accountProof{Balance: included.Balance, AccountProof: included.AccountProof[:len(included.AccountProof)-1]}},
+		{"mismatched balance", proofs.StateRoot, included.Address, accountProof{Balance: (*hexutil.Big)(big.NewInt(1)), AccountProof: included.AccountProof}},
+		{"balance of an absent account", proofs.StateRoot, absent.Address, accountProof{Balance: included.Balance, AccountProof: absent.AccountProof}},
+		{"no balance", proofs.StateRoot, included.Address, accountProof{AccountProof: included.AccountProof}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			balanceWei, err := verifyAccountProof(test.root, test.address, test.proof)
+			if !errors.Is(err, errInvalidProof) {
+				t.Errorf("verifyAccountProof() = %v, %v, want an invalid proof", balanceWei, err)
+			}
+		})
+	}
+}
+
+// TestVerifyTrieProofEmbedded checks a proof whose leaves, shorter than a hash, are embedded in the
+// root branch node rather than referenced by their hash.
+func TestVerifyTrieProofEmbedded(t *testing.T) {
+	leaf := func(nibble byte, value string) rlp.RawValue {
+		// Leaf with a 1-nibble path, hex-prefix encoded with the odd leaf flag 3
+		encoded, err := rlp.EncodeToBytes([]any{[]byte{0x30 | nibble}, []byte(value)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	branch := make([]any, 17)
+	for i := range branch {
+		branch[i] = []byte{}
+	}
+	branch[1], branch[3] = leaf(2, "a"), leaf(4, "b")
+	root, err := rlp.EncodeToBytes(branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := []hexutil.Bytes{root}
+
+	tests := []struct {
+		key  byte
+		want string
+	}{
+		{0x12, "a"},
+		{0x34, "b"},
+		{0x15, ""},
+		{0x52, ""},
+	}
+	for _, test := range tests {
+		value, err := verifyTrieProof(crypto.Keccak256Hash(root), []byte{test.key}, proof)
+		if err != nil {
+			t.Fatalf("verifyTrieProof(%#x) error = %v", test.key, err)
+		}
+		if string(value) != test.want {
+			t.Errorf("verifyTrieProof(%#x) = %q, want %q", test.key, value, test.want)
+		}
+	}
+}
+
+func TestDecodeHexPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded []byte
+		want    []byte
+		leaf    bool
+		wantErr bool
+	}{
+		{"even extension", []byte{0x00, 0x12}, []byte{1, 2}, false, false},
+		{"odd extension", []byte{0x11, 0x23}, []byte{1, 2, 3}, false, false},
+		{"even leaf", []byte{0x20, 0x12}, []byte{1, 2}, true, false},
+		{"odd leaf", []byte{0x3f}, []byte{0xf}, true, false},
+		{"invalid flags", []byte{0x40, 0x12}, nil, false, true},
+		{"empty", []byte{}, nil, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			element, err := rlp.EncodeToBytes(test.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nibbles, leaf, err := decodeHexPrefix(element)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("decodeHexPrefix(%x) accepted the path", test.encoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeHexPrefix(%x) error = %v", test.encoded, err)
+			}
+			if !slices.Equal(nibbles, test.want) || leaf != test.leaf {
+				t.Errorf("decodeHexPrefix(%x) = %v, %v, want %v, %v", test.encoded, nibbles, leaf, test.want, test.leaf)
+			}
+		})
+	}
+}
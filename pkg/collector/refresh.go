@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"context"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RunRefresh fetches every balance in the background, each wallet at its own refresh interval, waiting
+// until the next wallet is due plus a random jitter between refreshes so that replicas started together
+// spread their requests over time. It requires CollectorConfig.RefreshInterval to be set and never
+// returns.
+func (c *WalletBalanceCollector) RunRefresh() {
+	for {
+		wait := c.refresh()
+		time.Sleep(wait + randomJitter(c.config.RefreshJitter))
+	}
+}
+
+// refresh fetches the balances of the wallets that are due and stores them for collections to export,
+// along with the latest results of the other wallets, sending the fetched balances to Graphite if
+// configured. It returns how long until the next wallet is due.
+func (c *WalletBalanceCollector) refresh() time.Duration {
+	endpoints := c.getEndpoints()
+	c.cacheMutex.Lock()
+	due := c.dueEndpoints(endpoints, time.Now())
+	c.cacheMutex.Unlock()
+
+	c.fetchLock <- struct{}{}
+	results := c.fetchAll(context.Background(), due)
+	c.unlockFetches()
+
+	// Schedule from the end of the fetch, so that slow fetches do not make wallets due right away, and
+	// retry failed fetches no later than the next regular refresh
+	now := time.Now()
+	c.cacheMutex.Lock()
+	for _, result := range results {
+		interval := c.walletRefreshInterval(result.wallet)
+		if result.err != nil {
+			interval = min(interval, c.config.RefreshInterval)
+		}
+		c.refreshDue[balanceKey(result.endpoint.URL, result.wallet.Address)] = now.Add(interval)
+	}
+	c.refreshed = mergeResults(endpoints, c.refreshed, results)
+	wait := c.nextRefresh(now)
+	c.cacheMutex.Unlock()
+
+	// Sent in the background so that a slow Graphite receiver does not delay the next refresh
+	if c.config.GraphiteAddress != "" {
+		go c.sendGraphite(results, now)
+	}
+	return wait
+}
+
+// walletRefreshInterval returns how often background refreshes fetch the wallet's balances.
+func (c *WalletBalanceCollector) walletRefreshInterval(wallet WalletConfig) time.Duration {
+	if wallet.RefreshInterval > 0 {
+		return wallet.RefreshInterval
+	}
+	return c.config.RefreshInterval
+}
+
+// dueEndpoints returns the endpoints restricted to their wallets that are due at now, leaving out
+// endpoints without any. Wallets that were never fetched are due, and those no longer configured are
+// forgotten.
+func (c *WalletBalanceCollector) dueEndpoints(endpoints []EndpointConfig, now time.Time) []EndpointConfig {
+	if c.refreshDue == nil {
+		c.refreshDue = make(map[string]time.Time)
+	}
+
+	configured := make(map[string]bool)
+	var due []EndpointConfig
+	for _, endpoint := range endpoints {
+		var wallets []WalletConfig
+		for _, wallet := range endpoint.Wallets {
+			key := balanceKey(endpoint.URL, wallet.Address)
+			configured[key] = true
+			if next, ok := c.refreshDue[key]; !ok || !now.Before(next) {
+				wallets = append(wallets, wallet)
+			}
+		}
+		if len(wallets) > 0 {
+			endpoint.Wallets = wallets
+			due = append(due, endpoint)
+		}
+	}
+
+	for key := range c.refreshDue {
+		if !configured[key] {
+			delete(c.refreshDue, key)
+		}
+	}
+	return due
+}
+
+// nextRefresh returns how long after now the next wallet is due, but at most the refresh interval, so
+// that wallets added by a reload are fetched within it.
+func (c *WalletBalanceCollector) nextRefresh(now time.Time) time.Duration {
+	wait := c.config.RefreshInterval
+	for _, next := range c.refreshDue {
+		wait = min(wait, next.Sub(now))
+	}
+	return max(wait, 0)
+}
+
+// mergeResults returns the latest result of every configured wallet, taken from fetched or else from
+// previous, ordered as configured.
+func mergeResults(endpoints []EndpointConfig, previous, fetched []fetchResult) []fetchResult {
+	latest := make(map[string]fetchResult, len(previous)+len(fetched))
+	for _, result := range previous {
+		latest[balanceKey(result.endpoint.URL, result.wallet.Address)] = result
+	}
+	for _, result := range fetched {
+		latest[balanceKey(result.endpoint.URL, result.wallet.Address)] = result
+	}
+
+	results := make([]fetchResult, 0, len(latest))
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			key := balanceKey(endpoint.URL, wallet.Address)
+			if result, ok := latest[key]; ok {
+				results = append(results, result)
+				delete(latest, key)
+			}
+		}
+	}
+	return results
+}
+
+// withFetchTime timestamps a balance with the time it was fetched, so that Prometheus records the true
+// age of the sample rather than the scrape time. By default only balances from background refreshes
+// are timestamped; BALANCE_TIMESTAMPS extends this to every balance or disables it. Fallbacks without
+// a fetch time are returned unchanged.
+func (c *WalletBalanceCollector) withFetchTime(metric prometheus.Metric, fetchedAt time.Time) prometheus.Metric {
+	if fetchedAt.IsZero() || c.config.Timestamps == TimestampNever {
+		return metric
+	}
+	if c.config.RefreshInterval == 0 && c.config.Timestamps != TimestampAlways {
+		return metric
+	}
+	return prometheus.NewMetricWithTimestamp(fetchedAt, metric)
+}
+
+// refreshedResults returns the results of the latest background refresh for the wallets selected by
+// filter. A nil filter selects every wallet.
+func (c *WalletBalanceCollector) refreshedResults(filter map[string]bool) []fetchResult {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	if filter == nil {
+		return c.refreshed
+	}
+
+	var results []fetchResult
+	for _, result := range c.refreshed {
+		if filter[strings.ToLower(result.wallet.Address)] {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// randomJitter returns a random duration in [0, max), or zero if max is not positive.
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.yaml.in/yaml/v2"
+)
+
+// Relabel actions.
+const (
+	relabelKeep = "keep"
+	relabelDrop = "drop"
+)
+
+// RelabelRule keeps or drops exported series by their label values, like a Prometheus
+// metric_relabel_configs entry with the keep or drop action.
+type RelabelRule struct {
+	// SourceLabels are the labels whose values are joined with Separator and matched against Regex.
+	SourceLabels []string `yaml:"source_labels" json:"source_labels"`
+	// Separator joins the source label values. Empty selects ;.
+	Separator string `yaml:"separator" json:"separator,omitempty"`
+	// Regex must match the joined values in full. Empty selects (.*).
+	Regex string `yaml:"regex" json:"regex,omitempty"`
+	// Action is keep, to drop the series that do not match, or drop, to drop those that do.
+	Action string `yaml:"action" json:"action"`
+
+	regexp *regexp.Regexp
+}
+
+// LoadRelabelRules reads the relabel rules of a YAML config file and compiles them. A file without
+// rules returns none.
+func LoadRelabelRules(path string) ([]RelabelRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRelabelRules(data, "config file "+path)
+}
+
+// parseRelabelRules parses and compiles the relabel rules of a configuration in the CONFIG_FILE format.
+// The source describes where the configuration was read from in errors.
+func parseRelabelRules(data []byte, source string) ([]RelabelRule, error) {
+	var config fileConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", source, err)
+	}
+
+	for i := range config.Relabel {
+		if err := config.Relabel[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid relabel rule %d: %v", i, err)
+		}
+	}
+	return config.Relabel, nil
+}
+
+// compile validates the rule, applies its defaults and compiles its regular expression.
+func (r *RelabelRule) compile() error {
+	if r.Action != relabelKeep && r.Action != relabelDrop {
+		return fmt.Errorf("unknown action: %q (must be keep or drop)", r.Action)
+	}
+	if len(r.SourceLabels) == 0 {
+		return fmt.Errorf("no source_labels configured")
+	}
+	for _, label := range r.SourceLabels {
+		if !labelNamePattern.MatchString(label) {
+			return fmt.Errorf("invalid source label: %q", label)
+		}
+	}
+	if r.Separator == "" {
+		r.Separator = ";"
+	}
+	if r.Regex == "" {
+		r.Regex = "(.*)"
+	}
+
+	var err error
+	if r.regexp, err = regexp.Compile("^(?:" + r.Regex + ")$"); err != nil {
+		return fmt.Errorf("invalid regex %q: %v", r.Regex, err)
+	}
+	return nil
+}
+
+// keeps reports whether the rule keeps a series with the labels. Series that lack any of the source
+// labels, such as endpoint metrics for a rule on wallet, are not affected by the rule.
+func (r *RelabelRule) keeps(labels map[string]string) bool {
+	values := make([]string, len(r.SourceLabels))
+	for i, name := range r.SourceLabels {
+		value, ok := labels[name]
+		if !ok {
+			return true
+		}
+		values[i] = value
+	}
+
+	matches := r.regexp.MatchString(strings.Join(values, r.Separator))
+	return matches == (r.Action == relabelKeep)
+}
+
+// relabel returns a channel that forwards the metrics sent to it to ch, dropping those that a relabel
+// rule drops, and a function that closes the channel and waits until every metric is forwarded.
+// Without rules, ch itself is returned.
+func (c *WalletBalanceCollector) relabel(ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	if len(c.config.Relabel) == 0 {
+		return ch, func() {}
+	}
+
+	relabeled := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for metric := range relabeled {
+			if c.keepMetric(metric) {
+				ch <- metric
+			}
+		}
+	}()
+	return relabeled, func() {
+		close(relabeled)
+		<-done
+	}
+}
+
+// keepMetric reports whether every relabel rule keeps the metric.
+func (c *WalletBalanceCollector) keepMetric(metric prometheus.Metric) bool {
+	var written dto.Metric
+	if err := metric.Write(&written); err != nil {
+		// Let the registry report the broken metric
+		return true
+	}
+
+	labels := make(map[string]string, len(written.GetLabel()))
+	for _, pair := range written.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	for i := range c.config.Relabel {
+		if !c.config.Relabel[i].keeps(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRelabelRuleKeeps(t *testing.T) {
+	treasury := map[string]string{"wallet": "0x742d35Cc6634C0532925a3b844Bc454e4438f44e", "provider": "alchemy", "tag": "treasury"}
+	tests := []struct {
+		name   string
+		rule   RelabelRule
+		labels map[string]string
+		want   bool
+	}{
+		{"keep matching", RelabelRule{SourceLabels: []string{"tag"}, Regex: "treasury", Action: relabelKeep}, treasury, true},
+		{"keep not matching", RelabelRule{SourceLabels: []string{"tag"}, Regex: "hot", Action: relabelKeep}, treasury, false},
+		{"drop matching", RelabelRule{SourceLabels: []string{"tag"}, Regex: "treasury", Action: relabelDrop}, treasury, false},
+		{"drop not matching", RelabelRule{SourceLabels: []string{"tag"}, Regex: "hot", Action: relabelDrop}, treasury, true},
+		// The regex must match the whole value, as in Prometheus
+		{"anchored at the start", RelabelRule{SourceLabels: []string{"tag"}, Regex: "reasury", Action: relabelKeep}, treasury, false},
+		{"anchored at the end", RelabelRule{SourceLabels: []string{"tag"}, Regex: "treas", Action: relabelKeep}, treasury, false},
+		{"alternation anchored as a whole", RelabelRule{SourceLabels: []string{"tag"}, Regex: "hot|treas", Action: relabelKeep}, treasury, false},
+		{"prefix pattern", RelabelRule{SourceLabels: []string{"wallet"}, Regex: "0x742d.*", Action: relabelKeep}, treasury, true},
+		{"default regex", RelabelRule{SourceLabels: []string{"tag"}, Action: relabelDrop}, treasury, false},
+		{"joined with the default separator", RelabelRule{SourceLabels: []string{"provider", "tag"}, Regex: "alchemy;treasury", Action: relabelKeep}, treasury, true},
+		{"joined with a separator", RelabelRule{SourceLabels: []string{"provider", "tag"}, Separator: "/", Regex: "alchemy/treasury", Action: relabelKeep}, treasury, true},
+		// Series lacking a source label, such as endpoint metrics for a rule on wallet, are not affected
+		{"keep without the source label", RelabelRule{SourceLabels: []string{"tag"}, Regex: "hot", Action: relabelKeep}, map[string]string{"provider": "alchemy"}, true},
+		{"drop without the source label", RelabelRule{SourceLabels: []string{"tag"}, Action: relabelDrop}, map[string]string{"provider": "alchemy"}, true},
+		{"drop without one of the source labels", RelabelRule{SourceLabels: []string{"provider", "tag"}, Action: relabelDrop}, map[string]string{"provider": "alchemy"}, true},
+		{"empty value", RelabelRule{SourceLabels: []string{"tag"}, Action: relabelDrop}, map[string]string{"tag": ""}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.rule.compile(); err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			if got := test.rule.keeps(test.labels); got != test.want {
+				t.Errorf("keeps(%v) = %v, want %v", test.labels, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRelabelRulesInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want string
+	}{
+		{"unknown action", "{source_labels: [wallet], action: replace}", "unknown action"},
+		{"no source labels", "{action: keep}", "no source_labels"},
+		{"invalid source label", "{source_labels: [1wallet], action: keep}", "invalid source label"},
+		{"invalid regex", "{source_labels: [wallet], regex: '(', action: keep}", "invalid regex"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseRelabelRules([]byte("relabel:\n  - "+test.rule+"\n"), "test")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseRelabelRules() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// TestRelabelCollect checks that a keep rule on wallet drops the balances of the other wallets, but not
+// the series without a wallet label.
+func TestRelabelCollect(t *testing.T) {
+	rules, err := parseRelabelRules([]byte("relabel:\n  - {source_labels: [wallet], regex: '0x742d.*', action: keep}\n"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newStubRPC(t, map[string]string{
+		"eth_chainId":    `"0x1"`,
+		"eth_getBalance": `"0xde0b6b3a7640000"`,
+		"eth_getCode":    `"0x"`,
+	})
+	var wallets []WalletConfig
+	for _, address := range []string{"0x742d35Cc6634C0532925a3b844Bc454e4438f44e", "0x000d836201318ec6899a67540690382780743280"} {
+		wallets = append(wallets, WalletConfig{Address: address, parsed: common.HexToAddress(address)})
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	if _, err := Register(registry, []EndpointConfig{{Name: "stub", URL: server.URL, Wallets: wallets}}, CollectorConfig{Relabel: rules}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var balances []string
+	endpointSeries := 0
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			wallet := ""
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == "wallet" {
+					wallet = pair.GetValue()
+				}
+			}
+			switch {
+			case family.GetName() == "wallet_balance_eth":
+				balances = append(balances, wallet)
+			case wallet == "":
+				endpointSeries++
+			}
+		}
+	}
+	if want := []string{"0x742d35Cc6634C0532925a3b844Bc454e4438f44e"}; !slices.Equal(balances, want) {
+		t.Errorf("wallet_balance_eth exported for %v, want %v", balances, want)
+	}
+	if endpointSeries == 0 {
+		t.Error("series without a wallet label were dropped")
+	}
+}
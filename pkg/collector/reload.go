@@ -0,0 +1,236 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ReplaceEndpoints replaces the monitored endpoints and wallets with a new configuration, such as one
+// reloaded from its source, from the next collection or refresh on. The endpoints must be named, as by
+// AssignProviderNames. Endpoints added through the admin API are kept, and wallets added through it are
+// kept on their endpoints if those are still monitored. Cached balances, errors and clients of wallets
+// and endpoints that are no longer configured are dropped, while an endpoint whose RPC URL changed with
+// a rotated Vault secret keeps its cached state under its new URL.
+//
+// The names of the endpoints' static labels and of the wallets' tags, and the verified label added by
+// verify_proofs, are part of the metric descriptors, so a configuration that changes them is rejected
+// and requires a restart. With UnifiedBalanceMetric, a configuration with a token that would collide
+// with the native balances is rejected as at startup.
+func (c *WalletBalanceCollector) ReplaceEndpoints(endpoints []EndpointConfig) error {
+	if labels := endpointLabelNames(endpoints); !slices.Equal(labels, c.endpointLabels) {
+		return fmt.Errorf("endpoint label names changed from [%s] to [%s], which requires a restart", strings.Join(c.endpointLabels, ","), strings.Join(labels, ","))
+	}
+	if tags := walletTagNames(endpoints); !slices.Equal(tags, c.tagNames) {
+		return fmt.Errorf("wallet tag names changed from [%s] to [%s], which requires a restart", strings.Join(c.tagNames, ","), strings.Join(tags, ","))
+	}
+	if hasProofVerification(endpoints) != c.verifyProofs {
+		return errors.New("verify_proofs was enabled on the first endpoint or disabled on the last one, which adds or removes the verified label and requires a restart")
+	}
+	if c.config.UnifiedBalanceMetric {
+		if err := validateUnifiedAssets(endpoints); err != nil {
+			return err
+		}
+	}
+
+	c.endpointsMutex.Lock()
+	defer c.endpointsMutex.Unlock()
+
+	previous := c.endpoints
+	renamed := renamedEndpoints(previous, endpoints)
+	for from, to := range renamed {
+		c.moveEndpointState(from, to)
+	}
+	c.endpoints = endpoints
+	for _, endpoint := range c.addedEndpoints {
+		if err := c.addEndpoint(endpoint); err != nil && !errors.Is(err, errEndpointExists) {
+			log.Printf("Error restoring endpoint %s added through the admin API: %v", redactURL(endpoint.RPCURL), err)
+		}
+	}
+	for _, wallet := range c.added {
+		if _, err := c.addWallet(wallet); err != nil && !errors.Is(err, errWalletExists) {
+			log.Printf("Error restoring wallet %s added through the admin API: %v", wallet.Address, err)
+		}
+	}
+
+	current := make(map[string]EndpointConfig)
+	wallets := make(map[string]bool)
+	for _, endpoint := range c.endpoints {
+		current[endpoint.URL] = endpoint
+		for _, wallet := range endpoint.Wallets {
+			wallets[balanceKey(endpoint.URL, wallet.Address)] = true
+		}
+	}
+
+	for _, endpoint := range previous {
+		moved := endpoint
+		if to, ok := renamed[endpoint.URL]; ok {
+			moved.URL = to
+		}
+		for _, wallet := range endpoint.Wallets {
+			if !wallets[balanceKey(moved.URL, wallet.Address)] {
+				c.forgetWallet(moved, wallet.Address)
+			}
+		}
+
+		kept, ok := current[moved.URL]
+		switch {
+		case moved.URL != endpoint.URL:
+			// The client is connected to the previous URL
+			c.forgetEndpoint(endpoint, true)
+		case !ok || kept.Concurrency != endpoint.Concurrency:
+			c.forgetEndpoint(endpoint, !ok)
+		}
+	}
+
+	c.staggerStart(c.endpoints)
+	c.lastReload.SetToCurrentTime()
+	log.Printf("Monitoring %d wallets on %d endpoints after reloading the configuration", len(wallets), len(c.endpoints))
+	return nil
+}
+
+// ReloadConfigFile loads the endpoints from the config file at path and replaces the monitored
+// endpoints with them. If the file cannot be loaded or is invalid, the current configuration is kept.
+// Relabel rules are only applied at startup.
+func (c *WalletBalanceCollector) ReloadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.reloadConfig(data, "config file "+path)
+}
+
+// reloadConfig parses a configuration in the CONFIG_FILE format, read from source, and replaces the
+// monitored endpoints with its endpoints.
+func (c *WalletBalanceCollector) reloadConfig(data []byte, source string) error {
+	endpoints, err := parseConfig(data, source)
+	if err != nil {
+		return err
+	}
+	if c.config.Vault != nil {
+		if endpoints, err = c.config.Vault.Resolve(endpoints); err != nil {
+			return err
+		}
+	}
+	if err := AssignProviderNames(endpoints); err != nil {
+		return err
+	}
+	return c.ReplaceEndpoints(endpoints)
+}
+
+// renamedEndpoints returns the new RPC URL of each previous endpoint whose URL only changed because a
+// secret from Vault in its URL was rotated, keyed by the previous URL: an endpoint of the same name
+// resolved from the same URL template to a different URL.
+func renamedEndpoints(previous, current []EndpointConfig) map[string]string {
+	renamed := make(map[string]string)
+	for _, endpoint := range current {
+		if endpoint.urlTemplate == "" {
+			continue
+		}
+		for _, before := range previous {
+			if before.Name == endpoint.Name && before.urlTemplate == endpoint.urlTemplate && before.URL != endpoint.URL {
+				renamed[before.URL] = endpoint.URL
+			}
+		}
+	}
+	return renamed
+}
+
+// moveEndpointState carries the state of an endpoint kept by RPC URL over from its previous URL to its
+// new one: the cached balances, errors, contract flags, emitted balances, refresh schedule, snapshots,
+// ENS resolutions, circuit breaker, pinned and restored chain IDs and start time, so that rotating a
+// secret in the URL does not reset them. Wallets added through the admin API follow the endpoint. Its
+// client is not carried over, as it is connected to the previous URL. It must be called with
+// endpointsMutex held.
+func (c *WalletBalanceCollector) moveEndpointState(from, to string) {
+	c.cacheMutex.Lock()
+	for key, result := range c.lastBalances {
+		if walletAddress, ok := strings.CutPrefix(key, from+"|"); ok {
+			result.endpoint.URL = to
+			delete(c.lastBalances, key)
+			c.lastBalances[balanceKey(to, walletAddress)] = result
+		}
+	}
+	for i := range c.refreshed {
+		if c.refreshed[i].endpoint.URL == from {
+			c.refreshed[i].endpoint.URL = to
+		}
+	}
+	moveWalletKeys(c.lastErrors, from, to)
+	moveWalletKeys(c.contracts, from, to)
+	moveWalletKeys(c.emitted, from, to)
+	moveWalletKeys(c.refreshDue, from, to)
+	c.cacheMutex.Unlock()
+
+	c.snapshotMutex.Lock()
+	moveWalletKeys(c.snapshots, from, to)
+	c.snapshotMutex.Unlock()
+
+	c.ens.mutex.Lock()
+	moveWalletKeys(c.ens.addresses, from, to)
+	c.ens.mutex.Unlock()
+
+	c.circuitMutex.Lock()
+	moveKey(c.circuits, from, to)
+	c.circuitMutex.Unlock()
+
+	c.clientMutex.Lock()
+	moveKey(c.pinnedChainIDs, from, to)
+	moveKey(c.restoredChainIDs, from, to)
+	c.clientMutex.Unlock()
+
+	c.startMutex.Lock()
+	moveKey(c.startAt, from, to)
+	c.startMutex.Unlock()
+
+	moved := false
+	for i, wallet := range c.added {
+		if wallet.RPCURL == from {
+			c.added[i].RPCURL = to
+			moved = true
+		}
+	}
+	if moved {
+		if err := c.saveWalletsFile(); err != nil {
+			log.Printf("Error saving wallets added through the admin API after a Vault secret rotation: %v", err)
+		}
+	}
+}
+
+// moveWalletKeys moves the entries of a map keyed by balanceKey from the RPC URL from to the RPC URL to.
+func moveWalletKeys[V any](m map[string]V, from, to string) {
+	for key, value := range m {
+		if walletAddress, ok := strings.CutPrefix(key, from+"|"); ok {
+			delete(m, key)
+			m[balanceKey(to, walletAddress)] = value
+		}
+	}
+}
+
+// moveKey moves the entry of a map keyed by RPC URL from from to to, if there is one.
+func moveKey[V any](m map[string]V, from, to string) {
+	if value, ok := m[from]; ok {
+		delete(m, from)
+		m[to] = value
+	}
+}
+
+// forgetEndpoint drops the concurrency limit of an endpoint, so that it is created again with the
+// endpoint's current concurrency, and if the endpoint was removed, closes and drops its client.
+func (c *WalletBalanceCollector) forgetEndpoint(endpoint EndpointConfig, removed bool) {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	delete(c.semaphores, endpoint.URL)
+	if !removed {
+		return
+	}
+	if client, exists := c.clientCache[endpoint.URL]; exists {
+		client.Close()
+		delete(c.clientCache, endpoint.URL)
+		delete(c.clientCreated, endpoint.URL)
+	}
+}
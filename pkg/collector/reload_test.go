@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TestReplaceEndpointsKeepsAdminChanges checks that endpoints and wallets added through the admin API
+// survive a reload, while cached balances of wallets removed from the configuration are dropped.
+func TestReplaceEndpointsKeepsAdminChanges(t *testing.T) {
+	treasury := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	hot := "0x000d836201318ec6899a67540690382780743280"
+	configured := func(addresses ...string) []EndpointConfig {
+		var wallets []WalletConfig
+		for _, address := range addresses {
+			wallets = append(wallets, WalletConfig{Address: address, parsed: common.HexToAddress(address)})
+		}
+		return []EndpointConfig{{Name: "configured", URL: "http://configured.invalid", Wallets: wallets}}
+	}
+
+	c := New(configured(treasury), CollectorConfig{})
+	if err := c.LoadWalletsFile(filepath.Join(t.TempDir(), "wallets.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddEndpoint(AdminEndpoint{RPCURL: "http://added.invalid", Name: "added"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, wallet := range []AdminWallet{
+		{RPCURL: "http://added.invalid", Address: hot},
+		{RPCURL: "http://configured.invalid", Address: hot},
+	} {
+		if err := c.AddWallet(wallet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.cacheMutex.Lock()
+	c.lastBalances[balanceKey("http://configured.invalid", treasury)] = fetchResult{balance: 1}
+	c.cacheMutex.Unlock()
+
+	// The reloaded configuration no longer lists the treasury wallet
+	if err := c.ReplaceEndpoints(configured()); err != nil {
+		t.Fatalf("ReplaceEndpoints() error = %v", err)
+	}
+
+	want := map[string][]string{
+		"http://configured.invalid": {hot},
+		"http://added.invalid":      {hot},
+	}
+	endpoints := c.getEndpoints()
+	if len(endpoints) != len(want) {
+		t.Errorf("monitoring %d endpoints after the reload, want %d", len(endpoints), len(want))
+	}
+	for rpcURL, addresses := range want {
+		if wallets, ok := monitoredWallets(c, rpcURL); !ok || !slices.Equal(wallets, addresses) {
+			t.Errorf("endpoint %s after the reload: monitored %v with wallets %v, want wallets %v", rpcURL, ok, wallets, addresses)
+		}
+	}
+	c.cacheMutex.Lock()
+	_, cached := c.lastBalances[balanceKey("http://configured.invalid", treasury)]
+	c.cacheMutex.Unlock()
+	if cached {
+		t.Error("balance of the wallet removed from the configuration still cached")
+	}
+
+	// An added endpoint that the configuration now lists is not monitored twice
+	reloaded := append(configured(), EndpointConfig{Name: "added", URL: "http://added.invalid"})
+	if err := c.ReplaceEndpoints(reloaded); err != nil {
+		t.Fatalf("ReplaceEndpoints() error = %v", err)
+	}
+	if endpoints := c.getEndpoints(); len(endpoints) != 2 {
+		t.Errorf("monitoring %d endpoints after listing the added one in the configuration, want 2", len(endpoints))
+	}
+	if wallets, _ := monitoredWallets(c, "http://added.invalid"); !slices.Equal(wallets, []string{hot}) {
+		t.Errorf("wallets of the added endpoint %v, want %v", wallets, []string{hot})
+	}
+}
@@ -0,0 +1,235 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteTimeout bounds each push to the remote-write endpoint.
+const remoteWriteTimeout = 30 * time.Second
+
+// DefaultRemoteWriteInterval is the interval between pushes used when REMOTE_WRITE_INTERVAL is not set.
+const DefaultRemoteWriteInterval = time.Minute
+
+// remoteWriteJob is the job label of pushed series, as a Prometheus scrape job would set it.
+const remoteWriteJob = "eth-balance-exporter"
+
+// ParseRemoteWriteURL parses the REMOTE_WRITE_URL environment variable. An empty value disables
+// remote write.
+func ParseRemoteWriteURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return "", fmt.Errorf("invalid remote write URL: %s (must start with http:// or https://)", redactURL(value))
+	}
+	return value, nil
+}
+
+// remoteLabel and remoteSeries are the Label and TimeSeries messages of the remote-write protocol,
+// with a single sample per series.
+type remoteLabel struct {
+	name, value string
+}
+
+type remoteSeries struct {
+	labels    []remoteLabel
+	value     float64
+	timestamp int64
+}
+
+// RemoteWriter pushes the metrics of a registry to a Prometheus remote-write endpoint, for exporters
+// that cannot be scraped.
+type RemoteWriter struct {
+	url      string
+	gatherer prometheus.Gatherer
+	instance string
+	client   *http.Client
+}
+
+// NewRemoteWriter creates a RemoteWriter that pushes the metrics gathered from gatherer to url. The
+// series are labeled with job="eth-balance-exporter" and the host name as instance.
+func NewRemoteWriter(url string, gatherer prometheus.Gatherer) *RemoteWriter {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	return &RemoteWriter{
+		url:      url,
+		gatherer: gatherer,
+		instance: instance,
+		client:   &http.Client{Timeout: remoteWriteTimeout},
+	}
+}
+
+// Run gathers and pushes the metrics, waiting the interval between pushes. Gathering collects the
+// balances as a scrape would, so with REFRESH_INTERVAL set the latest refresh is pushed. It never returns.
+func (w *RemoteWriter) Run(interval time.Duration) {
+	for {
+		if err := w.push(); err != nil {
+			log.Printf("Error pushing metrics to %s: %v", redactURL(w.url), err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// push gathers the metrics and sends them in a single snappy-compressed WriteRequest.
+func (w *RemoteWriter) push() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Gather returns whatever it could collect along with the error
+		log.Printf("Error gathering metrics for remote write: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	var series []remoteSeries
+	for _, family := range families {
+		series = append(series, w.familySeries(family, now)...)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(snappy.Encode(nil, encodeWriteRequest(series))))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("User-Agent", remoteWriteJob)
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	response, err := doHTTP(w.client, request, "remote write endpoint")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	return nil
+}
+
+// familySeries converts a metric family to series the way Prometheus stores a scrape of it: histograms
+// and summaries are split into their _bucket or quantile, _sum and _count series. Samples without an
+// explicit timestamp are given now.
+func (w *RemoteWriter) familySeries(family *dto.MetricFamily, now int64) []remoteSeries {
+	var series []remoteSeries
+	for _, metric := range family.GetMetric() {
+		timestamp := now
+		if metric.TimestampMs != nil {
+			timestamp = metric.GetTimestampMs()
+		}
+		add := func(name string, value float64, extra ...remoteLabel) {
+			series = append(series, remoteSeries{
+				labels:    w.seriesLabels(name, metric.GetLabel(), extra...),
+				value:     value,
+				timestamp: timestamp,
+			})
+		}
+
+		name := family.GetName()
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			add(name, metric.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			add(name, metric.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			add(name, metric.GetUntyped().GetValue())
+		case dto.MetricType_HISTOGRAM:
+			histogram := metric.GetHistogram()
+			infinite := false
+			for _, bucket := range histogram.GetBucket() {
+				infinite = infinite || math.IsInf(bucket.GetUpperBound(), 1)
+				add(name+"_bucket", float64(bucket.GetCumulativeCount()), remoteLabel{"le", formatFloat(bucket.GetUpperBound())})
+			}
+			if !infinite {
+				add(name+"_bucket", float64(histogram.GetSampleCount()), remoteLabel{"le", "+Inf"})
+			}
+			add(name+"_sum", histogram.GetSampleSum())
+			add(name+"_count", float64(histogram.GetSampleCount()))
+		case dto.MetricType_SUMMARY:
+			summary := metric.GetSummary()
+			for _, quantile := range summary.GetQuantile() {
+				add(name, quantile.GetValue(), remoteLabel{"quantile", formatFloat(quantile.GetQuantile())})
+			}
+			add(name+"_sum", summary.GetSampleSum())
+			add(name+"_count", float64(summary.GetSampleCount()))
+		}
+	}
+	return series
+}
+
+// seriesLabels returns the non-empty labels of a series sorted by name, as the remote-write protocol
+// requires, with job and instance added unless the metric has them.
+func (w *RemoteWriter) seriesLabels(name string, pairs []*dto.LabelPair, extra ...remoteLabel) []remoteLabel {
+	labels := []remoteLabel{{"__name__", name}}
+	seen := map[string]bool{}
+	for _, pair := range pairs {
+		seen[pair.GetName()] = true
+		// Prometheus treats an empty label as absent and does not store it
+		if pair.GetValue() != "" {
+			labels = append(labels, remoteLabel{pair.GetName(), pair.GetValue()})
+		}
+	}
+	labels = append(labels, extra...)
+	if !seen["job"] {
+		labels = append(labels, remoteLabel{"job", remoteWriteJob})
+	}
+	if !seen["instance"] {
+		labels = append(labels, remoteLabel{"instance", w.instance})
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return labels
+}
+
+// formatFloat formats a bucket bound or quantile as Prometheus does in its le and quantile labels.
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the series as a remote-write WriteRequest protobuf message.
+func encodeWriteRequest(series []remoteSeries) []byte {
+	var request []byte
+	for _, s := range series {
+		var timeSeries []byte
+		for _, label := range s.labels {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label.name)
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label.value)
+			timeSeries = protowire.AppendTag(timeSeries, 1, protowire.BytesType)
+			timeSeries = protowire.AppendBytes(timeSeries, encoded)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		timeSeries = protowire.AppendTag(timeSeries, 2, protowire.BytesType)
+		timeSeries = protowire.AppendBytes(timeSeries, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, timeSeries)
+	}
+	return request
+}
@@ -0,0 +1,194 @@
+package collector
+
+import (
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes a remote-write WriteRequest message, following the prompb schema:
+// WriteRequest{repeated TimeSeries timeseries = 1}, TimeSeries{repeated Label labels = 1; repeated
+// Sample samples = 2}, Label{string name = 1; string value = 2} and Sample{double value = 1; int64
+// timestamp = 2}. Each series must have exactly one sample.
+func decodeWriteRequest(t *testing.T, data []byte) []remoteSeries {
+	t.Helper()
+	// fields calls field with the number, type and content of each field of a message
+	fields := func(data []byte, field func(number protowire.Number, typ protowire.Type, content []byte)) {
+		for len(data) > 0 {
+			number, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+			}
+			data = data[n:]
+			size := protowire.ConsumeFieldValue(number, typ, data)
+			if size < 0 {
+				t.Fatalf("invalid field %d: %v", number, protowire.ParseError(size))
+			}
+			content := data[:size]
+			if typ == protowire.BytesType {
+				content, _ = protowire.ConsumeBytes(content)
+			}
+			field(number, typ, content)
+			data = data[size:]
+		}
+	}
+	expect := func(message string, number protowire.Number, typ, want protowire.Type) {
+		if typ != want {
+			t.Fatalf("%s field %d has wire type %d, want %d", message, number, typ, want)
+		}
+	}
+
+	var series []remoteSeries
+	fields(data, func(number protowire.Number, typ protowire.Type, content []byte) {
+		if number != 1 {
+			t.Fatalf("unexpected WriteRequest field %d", number)
+		}
+		expect("WriteRequest", number, typ, protowire.BytesType)
+
+		var s remoteSeries
+		samples := 0
+		fields(content, func(number protowire.Number, typ protowire.Type, content []byte) {
+			expect("TimeSeries", number, typ, protowire.BytesType)
+			switch number {
+			case 1:
+				var label remoteLabel
+				fields(content, func(number protowire.Number, typ protowire.Type, content []byte) {
+					expect("Label", number, typ, protowire.BytesType)
+					switch number {
+					case 1:
+						label.name = string(content)
+					case 2:
+						label.value = string(content)
+					}
+				})
+				s.labels = append(s.labels, label)
+			case 2:
+				samples++
+				fields(content, func(number protowire.Number, typ protowire.Type, content []byte) {
+					switch number {
+					case 1:
+						expect("Sample", number, typ, protowire.Fixed64Type)
+						bits, _ := protowire.ConsumeFixed64(content)
+						s.value = math.Float64frombits(bits)
+					case 2:
+						expect("Sample", number, typ, protowire.VarintType)
+						timestamp, _ := protowire.ConsumeVarint(content)
+						s.timestamp = int64(timestamp)
+					}
+				})
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", number)
+			}
+		})
+		if samples != 1 {
+			t.Fatalf("series %v has %d samples, want 1", s.labels, samples)
+		}
+		series = append(series, s)
+	})
+	return series
+}
+
+// TestEncodeWriteRequestGolden compares the encoding of a series with the bytes prompb produces for it.
+func TestEncodeWriteRequestGolden(t *testing.T) {
+	encoded := encodeWriteRequest([]remoteSeries{{labels: []remoteLabel{{"__name__", "up"}}, value: 1, timestamp: 1000}})
+	want := "0a1e" + // timeseries, 30 bytes
+		"0a0e" + "0a085f5f6e616d655f5f" + "12027570" + // labels: name "__name__", value "up"
+		"120c" + "09000000000000f03f" + "10e807" // samples: value 1, timestamp 1000
+	if got := hex.EncodeToString(encoded); got != want {
+		t.Errorf("encodeWriteRequest() = %s, want %s", got, want)
+	}
+}
+
+// seriesString formats a series as in the Prometheus text format, for comparisons.
+func seriesString(s remoteSeries) string {
+	var name string
+	var labels []string
+	for _, label := range s.labels {
+		if label.name == "__name__" {
+			name = label.value
+			continue
+		}
+		labels = append(labels, label.name+`="`+label.value+`"`)
+	}
+	return name + "{" + strings.Join(labels, ",") + "} " + formatFloat(s.value)
+}
+
+// TestRemoteWriterPush checks the series pushed for a registry: labels sorted with job and instance
+// added, empty labels removed, and histograms split with a +Inf bucket synthesized.
+func TestRemoteWriterPush(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "wallet_balance_eth", Help: "Balance"}, []string{"wallet", "tag"})
+	gauge.WithLabelValues("0xab", "").Set(1.5)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "fetch_duration_seconds", Help: "Duration", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.25)
+	histogram.Observe(2)
+	registry.MustRegister(gauge, histogram)
+
+	var (
+		headers http.Header
+		body    []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := NewRemoteWriter(server.URL, registry)
+	writer.instance = "host"
+	if err := writer.push(); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+	if headers.Get("Content-Encoding") != "snappy" || headers.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("pushed with headers %v", headers)
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, s := range decodeWriteRequest(t, data) {
+		if !slices.IsSortedFunc(s.labels, func(a, b remoteLabel) int { return strings.Compare(a.name, b.name) }) {
+			t.Errorf("labels of %s not sorted by name", seriesString(s))
+		}
+		if s.timestamp <= 0 {
+			t.Errorf("%s has timestamp %d", seriesString(s), s.timestamp)
+		}
+		got = append(got, seriesString(s))
+	}
+	want := []string{
+		`fetch_duration_seconds_bucket{instance="host",job="eth-balance-exporter",le="0.5"} 1`,
+		`fetch_duration_seconds_bucket{instance="host",job="eth-balance-exporter",le="1"} 1`,
+		`fetch_duration_seconds_bucket{instance="host",job="eth-balance-exporter",le="+Inf"} 2`,
+		`fetch_duration_seconds_sum{instance="host",job="eth-balance-exporter"} 2.25`,
+		`fetch_duration_seconds_count{instance="host",job="eth-balance-exporter"} 2`,
+		`wallet_balance_eth{instance="host",job="eth-balance-exporter",wallet="0xab"} 1.5`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("pushed series:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestRemoteWriterPushError checks that a rejected push fails with the endpoint's response.
+func TestRemoteWriterPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := NewRemoteWriter(server.URL, prometheus.NewPedanticRegistry()).push()
+	if err == nil || !strings.Contains(err.Error(), "HTTP 400") || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("push() error = %v, want the HTTP 400 response", err)
+	}
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryBaseDelay is the delay before the first retry used when RPC_RETRY_BASE_DELAY is not set.
+const DefaultRetryBaseDelay = 500 * time.Millisecond
+
+// ParseRetryAttempts parses the maximum number of attempts of a balance query, including the first.
+// An empty value returns 1, which disables retries.
+func ParseRetryAttempts(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 1, nil
+	}
+
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 1 {
+		return 0, fmt.Errorf("invalid number of attempts: %s (must be an integer of at least 1)", value)
+	}
+	return attempts, nil
+}
+
+// withRetries calls query until it succeeds, the configured attempts are used up or ctx is done,
+// doubling the delay between attempts from RetryBaseDelay. Each retry is counted in rpc_retries_total.
+func (c *WalletBalanceCollector) withRetries(ctx context.Context, endpoint EndpointConfig, query func() error) error {
+	delay := c.config.RetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := query()
+		if err == nil || attempt >= c.config.RetryAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("Retrying request to provider %s in %s (attempt %d of %d): %v", endpoint.Name, delay, attempt+1, c.config.RetryAttempts, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		c.rpcRetries.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name)...).Inc()
+		delay *= 2
+	}
+}
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// snapshotSettleDelay is how long after a scheduled moment a snapshot is taken, so that the
+// block closest to that moment has been produced.
+const snapshotSettleDelay = time.Minute
+
+// snapshot is a wallet balance recorded at the block closest to a scheduled moment.
+type snapshot struct {
+	date    string
+	balance float64
+}
+
+// ParseSnapshotInterval parses the SNAPSHOT_INTERVAL environment variable. An empty value disables snapshots.
+func ParseSnapshotInterval(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Minute {
+		return 0, fmt.Errorf("invalid SNAPSHOT_INTERVAL: %s (must be a duration of at least 1m, e.g. 24h)", value)
+	}
+	return interval, nil
+}
+
+// snapshotDate formats a snapshot moment for the date label. Whole-day intervals are labeled with the
+// calendar date, shorter ones with the full UTC timestamp so that consecutive snapshots stay distinct.
+func snapshotDate(at time.Time, interval time.Duration) string {
+	if interval%(24*time.Hour) == 0 {
+		return at.UTC().Format("2006-01-02")
+	}
+	return at.UTC().Format(time.RFC3339)
+}
+
+// RunSnapshots takes a snapshot at every multiple of interval since the zero time (UTC midnight for
+// whole-day intervals), starting with the most recent one. It never returns.
+func (c *WalletBalanceCollector) RunSnapshots(interval time.Duration) {
+	for {
+		at := time.Now().Truncate(interval)
+		c.takeSnapshots(context.Background(), at, snapshotDate(at, interval))
+		time.Sleep(time.Until(at.Add(interval + snapshotSettleDelay)))
+	}
+}
+
+// takeSnapshots records the balance of every wallet at the block closest to at.
+func (c *WalletBalanceCollector) takeSnapshots(ctx context.Context, at time.Time, date string) {
+	for _, endpoint := range c.getEndpoints() {
+		if endpoint.Aggregate {
+			continue
+		}
+		client, err := c.getClient(ctx, endpoint)
+		if err != nil {
+			log.Printf("Error connecting to provider %s for snapshot: %v", endpoint.Name, err)
+			continue
+		}
+
+		blockNumber, err := blockClosestTo(ctx, client, at)
+		if err != nil {
+			log.Printf("Error finding snapshot block for %s on provider %s: %v", date, endpoint.Name, err)
+			continue
+		}
+
+		for _, wallet := range endpoint.Wallets {
+			balanceWei, err := c.getWalletBalance(ctx, endpoint, client, wallet, blockNumber)
+			if err != nil {
+				log.Printf("Error retrieving snapshot balance for wallet %s from provider %s: %v", wallet.Address, endpoint.Name, err)
+				continue
+			}
+			balance, _ := weiToETH(balanceWei, endpoint.nativeDecimals(), c.config.Precision, c.config.RoundingMode)
+
+			c.snapshotMutex.Lock()
+			c.snapshots[balanceKey(endpoint.URL, wallet.Address)] = snapshot{date: date, balance: balance * wallet.factor()}
+			c.snapshotMutex.Unlock()
+		}
+		log.Printf("Recorded balance snapshot for %s at block %s on provider %s", date, blockNumber, endpoint.Name)
+	}
+}
+
+// blockClosestTo binary searches the chain for the block whose timestamp is closest to at.
+func blockClosestTo(ctx context.Context, client *ethclient.Client, at time.Time) (*big.Int, error) {
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	blockTime := func(number uint64) (int64, error) {
+		header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return 0, err
+		}
+		return int64(header.Time), nil
+	}
+
+	target := at.Unix()
+
+	// Find the last block produced at or before the target.
+	low, high := uint64(0), head
+	for low < high {
+		mid := low + (high-low+1)/2
+		midTime, err := blockTime(mid)
+		if err != nil {
+			return nil, err
+		}
+		if midTime <= target {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	// The block after it may be closer to the target.
+	if low < head {
+		lowTime, err := blockTime(low)
+		if err != nil {
+			return nil, err
+		}
+		nextTime, err := blockTime(low + 1)
+		if err != nil {
+			return nil, err
+		}
+		if nextTime-target < target-lowTime {
+			low++
+		}
+	}
+
+	return new(big.Int).SetUint64(low), nil
+}
@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"context"
+	"time"
+)
+
+// staggerStart gives each endpoint not seen before a random time within the startup stagger before
+// which it is not contacted, so that replicas restarted together, or reloading the same configuration,
+// do not query a shared provider all at once. Endpoints that are no longer monitored are forgotten.
+func (c *WalletBalanceCollector) staggerStart(endpoints []EndpointConfig) {
+	if c.config.StartupStagger == 0 {
+		return
+	}
+
+	c.startMutex.Lock()
+	defer c.startMutex.Unlock()
+	startAt := make(map[string]time.Time, len(endpoints))
+	now := time.Now()
+	for _, endpoint := range endpoints {
+		if at, ok := c.startAt[endpoint.URL]; ok {
+			startAt[endpoint.URL] = at
+		} else {
+			startAt[endpoint.URL] = now.Add(randomJitter(c.config.StartupStagger))
+		}
+	}
+	c.startAt = startAt
+}
+
+// waitForStart waits until the endpoint may be contacted after startup. It returns the context error
+// if ctx is done first.
+func (c *WalletBalanceCollector) waitForStart(ctx context.Context, endpoint EndpointConfig) error {
+	c.startMutex.Lock()
+	wait := time.Until(c.startAt[endpoint.URL])
+	c.startMutex.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
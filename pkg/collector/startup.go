@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultStartupRetryDelay is the delay before checking unreachable endpoints again at startup used
+// when STARTUP_RETRY_DELAY is not set.
+const DefaultStartupRetryDelay = 2 * time.Second
+
+// maxStartupRetryDelay caps the doubling delay between startup connectivity checks.
+const maxStartupRetryDelay = time.Minute
+
+// CheckEndpoints tries to reach every endpoint concurrently, logging the outcome, and returns the
+// number of reachable endpoints. Unreachable endpoints are checked again up to StartupAttempts times in
+// all, doubling the delay between checks from StartupRetryDelay, so that providers that are briefly
+// unavailable, such as during a coordinated deploy, can come up. Each check is bounded by the
+// collection timeout plus the startup stagger.
+func (c *WalletBalanceCollector) CheckEndpoints(ctx context.Context) int {
+	endpoints := c.getEndpoints()
+	unreachable := endpoints
+	delay := c.config.StartupRetryDelay
+	for attempt := 1; ; attempt++ {
+		unreachable = c.checkEndpoints(ctx, unreachable)
+		if len(unreachable) == 0 || attempt >= c.config.StartupAttempts {
+			break
+		}
+
+		log.Printf("%d of %d providers unreachable at startup, checking them again in %s", len(unreachable), len(endpoints), delay)
+		select {
+		case <-ctx.Done():
+			return len(endpoints) - len(unreachable)
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxStartupRetryDelay)
+	}
+
+	count := len(endpoints) - len(unreachable)
+	log.Printf("%d of %d providers reachable at startup", count, len(endpoints))
+	return count
+}
+
+// checkEndpoints checks the endpoints concurrently and returns those that are unreachable.
+func (c *WalletBalanceCollector) checkEndpoints(ctx context.Context, endpoints []EndpointConfig) []EndpointConfig {
+	ctx, cancel := context.WithTimeout(ctx, c.config.CollectTimeout+c.config.StartupStagger)
+	defer cancel()
+
+	type check struct {
+		endpoint  EndpointConfig
+		reachable bool
+	}
+	checks := make(chan check, len(endpoints))
+	for _, endpoint := range endpoints {
+		go func() {
+			checks <- check{endpoint, c.checkEndpoint(ctx, endpoint)}
+		}()
+	}
+
+	var unreachable []EndpointConfig
+	for range endpoints {
+		if check := <-checks; !check.reachable {
+			unreachable = append(unreachable, check.endpoint)
+		}
+	}
+	return unreachable
+}
+
+// checkEndpoint reports whether the endpoint answers a block number query.
+func (c *WalletBalanceCollector) checkEndpoint(ctx context.Context, endpoint EndpointConfig) bool {
+	if err := c.waitForStart(ctx, endpoint); err != nil {
+		log.Printf("Provider %s is unreachable at startup: %v", endpoint.Name, err)
+		return false
+	}
+
+	client, err := c.getClient(ctx, endpoint)
+	if err != nil {
+		log.Printf("Provider %s is unreachable at startup: %v", endpoint.Name, err)
+		return false
+	}
+
+	if _, err := client.BlockNumber(ctx); err != nil {
+		c.countRPCError(endpoint, err)
+		log.Printf("Provider %s is unreachable at startup: %v", endpoint.Name, wrapRPCError(err))
+		return false
+	}
+	return true
+}
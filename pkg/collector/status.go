@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errNonJSONResponse is wrapped around errors caused by an endpoint answering with something other
+// than JSON, typically an HTML error page from a load balancer in front of the node.
+var errNonJSONResponse = errors.New("endpoint returned non-JSON response, likely a gateway error")
+
+// Reasons reported in the reason label of rpc_endpoint_status.
+const (
+	reasonOK              = "ok"
+	reasonNonJSON         = "non_json_response"
+	reasonHTTPError       = "http_error"
+	reasonRPCError        = "rpc_error"
+	reasonTimeout         = "timeout"
+	reasonConnectionError = "connection_error"
+	reasonChainIDMismatch = "chain_id_mismatch"
+	reasonInvalidProof    = "invalid_proof"
+	reasonCircuitOpen     = "circuit_open"
+)
+
+// errChainIDMismatch is wrapped around the errors of the wallets of an endpoint that reports another
+// chain ID than its expected_chain_id or the chain it is pinned to, or that has just switched chains.
+var errChainIDMismatch = errors.New("chain ID mismatch")
+
+// wrapRPCError replaces the JSON decode errors and HTML bodies that go-ethereum reports for non-JSON
+// responses with errNonJSONResponse. Other errors are returned unchanged.
+func wrapRPCError(err error) error {
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && !json.Valid(bytes.TrimSpace(httpErr.Body)) {
+		return fmt.Errorf("%w (HTTP %s)", errNonJSONResponse, httpErr.Status)
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("%w (%v)", errNonJSONResponse, err)
+	}
+	return err
+}
+
+// countRPCError counts a JSON-RPC error answered by the endpoint in rpc_error_code_total by its error
+// code. Other failures, such as HTTP errors and timeouts, carry no code and are not counted.
+func (c *WalletBalanceCollector) countRPCError(endpoint EndpointConfig, err error) {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		c.rpcErrorCodes.WithLabelValues(c.endpointLabelValues(endpoint, endpoint.Name, strconv.Itoa(rpcErr.ErrorCode()))...).Inc()
+	}
+}
+
+// endpointStatusReason classifies the error of a failed fetch for the reason label of rpc_endpoint_status:
+// by the first ERROR_REASONS or default pattern matching its message, or else by its type. Chain ID
+// mismatches, invalid proofs and open circuit breakers are detected by the collector and always keep
+// their own reason.
+func (c *WalletBalanceCollector) endpointStatusReason(err error) string {
+	var (
+		httpErr rpc.HTTPError
+		rpcErr  rpc.Error
+		netErr  net.Error
+	)
+	switch {
+	case errors.Is(err, errChainIDMismatch):
+		return reasonChainIDMismatch
+	case errors.Is(err, errInvalidProof):
+		return reasonInvalidProof
+	case errors.Is(err, errCircuitOpen):
+		return reasonCircuitOpen
+	}
+	if reason, ok := c.matchErrorReason(err); ok {
+		return reason
+	}
+
+	switch {
+	case errors.Is(err, errNonJSONResponse):
+		return reasonNonJSON
+	case errors.As(err, &httpErr):
+		return reasonHTTPError
+	case errors.As(err, &rpcErr):
+		return reasonRPCError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	default:
+		return reasonConnectionError
+	}
+}
+
+// endpointStatuses tracks the status of each endpoint during a collection, keyed by endpoint name.
+// An endpoint is up if at least one of its wallets was fetched; otherwise it is down for the reason
+// of its most recent failure.
+type endpointStatuses map[string]string
+
+// add records the outcome of a fetch from the endpoint: reasonOK, or the reason it failed.
+func (s endpointStatuses) add(endpoint EndpointConfig, reason string) {
+	if s[endpoint.Name] != reasonOK {
+		s[endpoint.Name] = reason
+	}
+}
+
+// collectEndpointStatuses emits rpc_endpoint_status for each endpoint with a recorded outcome, and
+// the number of those endpoints that are up and down.
+func (c *WalletBalanceCollector) collectEndpointStatuses(ch chan<- prometheus.Metric, endpoints []EndpointConfig, statuses endpointStatuses) {
+	var up, down int
+	for _, endpoint := range endpoints {
+		reason, ok := statuses[endpoint.Name]
+		if !ok {
+			continue
+		}
+
+		value := 0.0
+		if reason == reasonOK {
+			value = 1
+			up++
+		} else {
+			down++
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.statusMetric,
+			prometheus.GaugeValue,
+			value,
+			c.endpointLabelValues(endpoint, endpoint.Name, reason)...,
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.upMetric, prometheus.GaugeValue, float64(up))
+	ch <- prometheus.MustNewConstMetric(c.downMetric, prometheus.GaugeValue, float64(down))
+}
@@ -0,0 +1,288 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// balanceOfSelector is the selector of the ERC-20 balanceOf(address) method.
+var balanceOfSelector = common.FromHex("0x70a08231")
+
+// multicallABI describes the aggregate3 method of Multicall3.
+var multicallABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(`[{"name":"aggregate3","type":"function","stateMutability":"payable",
+		"inputs":[{"name":"calls","type":"tuple[]","components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],
+		"outputs":[{"name":"returnData","type":"tuple[]","components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}]`))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// multicallCall and multicallResult are the input and output tuples of aggregate3.
+type multicallCall struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// TokenConfig describes an ERC-20 token whose balance is monitored for every wallet of an endpoint.
+type TokenConfig struct {
+	// Symbol is the token label value. Without an address, the token is looked up by its uppercased
+	// symbol in the built-in registry for the endpoint's chain.
+	Symbol  string `yaml:"symbol"`
+	Address string `yaml:"address"`
+	// Decimals overrides the registry's decimals. It is required with an address.
+	Decimals *uint8 `yaml:"decimals"`
+}
+
+// UnmarshalYAML accepts either a bare symbol or a mapping with symbol, address and decimals.
+func (t *TokenConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&t.Symbol); err == nil {
+		return nil
+	}
+
+	type plain TokenConfig
+	return unmarshal((*plain)(t))
+}
+
+// validate normalizes the token address and checks that the token can be resolved.
+func (t *TokenConfig) validate() error {
+	if t.Symbol == "" {
+		return fmt.Errorf("token without a symbol")
+	}
+	if t.Address == "" {
+		t.Symbol = strings.ToUpper(t.Symbol)
+		if !isKnownToken(t.Symbol) {
+			return fmt.Errorf("unknown token %s (set its address and decimals)", t.Symbol)
+		}
+		return nil
+	}
+
+	address, err := NormalizeAddress(t.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address of token %s: %v", t.Symbol, err)
+	}
+	t.Address = address
+	if t.Decimals == nil {
+		return fmt.Errorf("token %s has an address but no decimals", t.Symbol)
+	}
+	return nil
+}
+
+// validateTokenThresholds checks that every token threshold of the wallet names one of the tokens and
+// is a non-negative number, and replaces the symbols with those of the tokens, which match case-insensitively.
+func (w *WalletConfig) validateTokenThresholds(tokens []TokenConfig) error {
+	if len(w.TokenThresholds) == 0 {
+		return nil
+	}
+
+	thresholds := make(map[string]float64, len(w.TokenThresholds))
+	for symbol, threshold := range w.TokenThresholds {
+		index := slices.IndexFunc(tokens, func(token TokenConfig) bool {
+			return strings.EqualFold(token.Symbol, symbol)
+		})
+		if index < 0 {
+			return fmt.Errorf("threshold for token %s, which is not in the endpoint's tokens", symbol)
+		}
+		if threshold < 0 || math.IsInf(threshold, 0) || math.IsNaN(threshold) {
+			return fmt.Errorf("invalid threshold for token %s: %v (must be a non-negative number of whole tokens)", symbol, threshold)
+		}
+		thresholds[tokens[index].Symbol] = threshold
+	}
+	w.TokenThresholds = thresholds
+	return nil
+}
+
+// tokenBalance is the fetched balance of a token held by a wallet.
+type tokenBalance struct {
+	symbol  string
+	balance float64
+}
+
+// resolvedToken is a token with the address and decimals that apply on the endpoint's chain.
+type resolvedToken struct {
+	symbol   string
+	address  common.Address
+	decimals uint8
+}
+
+// resolveTokens returns the endpoint's tokens with the registry entries of the chain filled in.
+// Tokens that the registry does not know on the chain are logged and skipped.
+func resolveTokens(endpoint EndpointConfig, chainID string) []resolvedToken {
+	var tokens []resolvedToken
+	for _, token := range endpoint.Tokens {
+		if token.Address != "" {
+			tokens = append(tokens, resolvedToken{token.Symbol, common.HexToAddress(token.Address), *token.Decimals})
+			continue
+		}
+
+		known, ok := knownChains[chainID].tokens[token.Symbol]
+		if !ok {
+			log.Printf("Token %s is not known on chain %q of provider %s, set its address", token.Symbol, chainID, endpoint.Name)
+			continue
+		}
+		decimals := known.decimals
+		if token.Decimals != nil {
+			decimals = *token.Decimals
+		}
+		tokens = append(tokens, resolvedToken{token.Symbol, common.HexToAddress(known.address), decimals})
+	}
+	return tokens
+}
+
+// multicallAddress returns the Multicall3 address of the endpoint: the configured one, otherwise the
+// registry's for the chain, or empty if neither is known.
+func multicallAddress(endpoint EndpointConfig, chainID string) string {
+	if endpoint.Multicall != "" {
+		return endpoint.Multicall
+	}
+	return knownChains[chainID].multicall
+}
+
+// fetchTokens retrieves the balances of the endpoint's tokens held by the wallet at blockNumber, or the
+// latest block if it is nil, in a single Multicall3 call if the chain has one and with a call per
+// token otherwise. Failed tokens are logged and omitted.
+func (c *WalletBalanceCollector) fetchTokens(ctx context.Context, endpoint EndpointConfig, client *ethclient.Client, wallet WalletConfig, blockNumber *big.Int) []tokenBalance {
+	if len(endpoint.Tokens) == 0 || endpoint.Aggregate {
+		return nil
+	}
+	chainID := c.chainLabel(endpoint)
+	tokens := resolveTokens(endpoint, chainID)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	ctx, span := startRPCSpan(ctx, "eth_call", endpoint, attribute.String("wallet", wallet.Address), attribute.Int("tokens", len(tokens)))
+	data := append(append([]byte{}, balanceOfSelector...), common.LeftPadBytes(wallet.hexAddress().Bytes(), 32)...)
+
+	calls := make([]multicallCall, len(tokens))
+	for i, token := range tokens {
+		calls[i] = multicallCall{Target: token.address, AllowFailure: true, CallData: data}
+	}
+	results, err := callContracts(ctx, client, multicallAddress(endpoint, chainID), calls, blockNumber)
+	if err != nil {
+		c.countRPCError(endpoint, err)
+		err = wrapRPCError(err)
+		endSpan(span, err)
+		c.logEndpointError(endpoint, err, "Error retrieving token balances for wallet %s from provider %s: %v", wallet.Address, endpoint.Name, err)
+		return nil
+	}
+	endSpan(span, nil)
+
+	balances := make([]tokenBalance, 0, len(tokens))
+	for i, token := range tokens {
+		if len(results[i]) < 32 {
+			c.logs.printf(endpoint.Name+"\xff"+token.address.Hex(), "Error retrieving %s balance for wallet %s from provider %s: call to %s failed", token.symbol, wallet.Address, endpoint.Name, token.address.Hex())
+			continue
+		}
+		balances = append(balances, tokenBalance{
+			symbol:  token.symbol,
+			balance: tokenAmount(new(big.Int).SetBytes(results[i][:32]), token.decimals),
+		})
+	}
+	return balances
+}
+
+// callContracts makes the calls at blockNumber, or the latest block if it is nil, in a single call of
+// the Multicall3 contract at multicall if it is set and with a call each otherwise. In a single call,
+// the result of a failed call is empty; otherwise the first failed call fails them all.
+func callContracts(ctx context.Context, client *ethclient.Client, multicall string, calls []multicallCall, blockNumber *big.Int) ([][]byte, error) {
+	if multicall != "" {
+		return callMulticall(ctx, client, common.HexToAddress(multicall), calls, blockNumber)
+	}
+
+	results := make([][]byte, len(calls))
+	for i, call := range calls {
+		var err error
+		if results[i], err = client.CallContract(ctx, ethereum.CallMsg{To: &call.Target, Data: call.CallData}, blockNumber); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// callMulticall makes the calls through Multicall3's aggregate3. The result of a failed call is empty.
+func callMulticall(ctx context.Context, client *ethclient.Client, multicall common.Address, calls []multicallCall, blockNumber *big.Int) ([][]byte, error) {
+	input, err := multicallABI.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &multicall, Data: input}, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	unpacked, err := multicallABI.Unpack("aggregate3", output)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected result of aggregate3 on %s: %v", multicall.Hex(), err)
+	}
+	returned := *abi.ConvertType(unpacked[0], new([]multicallResult)).(*[]multicallResult)
+	if len(returned) != len(calls) {
+		return nil, fmt.Errorf("unexpected result of aggregate3 on %s: %d results for %d calls", multicall.Hex(), len(returned), len(calls))
+	}
+
+	results := make([][]byte, len(returned))
+	for i, result := range returned {
+		if result.Success {
+			results[i] = result.ReturnData
+		}
+	}
+	return results, nil
+}
+
+// tokenAmount converts a raw token amount to whole tokens.
+func tokenAmount(amount *big.Int, decimals uint8) float64 {
+	balance, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), decimalUnits[decimals].float).Float64()
+	return balance
+}
+
+// collectTokens emits the token balances fetched for a wallet, and whether they are below the wallet's
+// thresholds.
+func (c *WalletBalanceCollector) collectTokens(ch chan<- prometheus.Metric, result fetchResult) {
+	if c.expired(result.fetchedAt) {
+		return
+	}
+
+	chainID := c.chainLabel(result.endpoint)
+	for _, token := range result.tokens {
+		ch <- c.withChangeTime(prometheus.MustNewConstMetric(
+			c.tokenMetric,
+			prometheus.GaugeValue,
+			token.balance,
+			c.walletLabelValues(result.endpoint, result.wallet, chainID, c.tokenLabels(result.wallet, chainID, token.symbol)...)...,
+		), result, token.symbol, token.balance)
+
+		threshold, ok := result.wallet.TokenThresholds[token.symbol]
+		if !ok {
+			continue
+		}
+		below := 0.0
+		if token.balance < threshold {
+			below = 1
+		}
+		ch <- c.withFetchTime(prometheus.MustNewConstMetric(
+			c.thresholdMetric,
+			prometheus.GaugeValue,
+			below,
+			c.walletLabelValues(result.endpoint, result.wallet, chainID, c.addressLabel(result.wallet.Address), chainID, token.symbol)...,
+		), result.fetchedAt)
+	}
+}
@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans around RPC calls. It uses the global tracer provider, which does nothing
+// unless the program installs one.
+var tracer = otel.Tracer("eth-balance-exporter/pkg/collector")
+
+// startRPCSpan starts a span for an RPC operation on the endpoint. The URL is redacted so that API keys
+// do not end up in traces.
+func startRPCSpan(ctx context.Context, name string, endpoint EndpointConfig, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("provider", endpoint.Name),
+		attribute.String("rpc_url", redactURL(endpoint.URL)),
+	}, attributes...)...))
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
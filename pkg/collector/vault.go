@@ -0,0 +1,255 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultTimeout bounds each request to Vault.
+const vaultTimeout = 30 * time.Second
+
+// DefaultVaultAddress is the address of the local Vault server, used when VAULT_ADDR is not set.
+const DefaultVaultAddress = "https://127.0.0.1:8200"
+
+// DefaultVaultInterval is the interval between reads of the Vault secret used when VAULT_INTERVAL is
+// not set.
+const DefaultVaultInterval = 5 * time.Minute
+
+// vaultPlaceholder matches the placeholders in RPC URLs that are replaced by a key of the Vault secret,
+// such as ${vault:alchemy_key}.
+var vaultPlaceholder = regexp.MustCompile(`\$\{vault:([^}]*)\}`)
+
+// VaultSource reads the secrets injected into RPC URLs, such as provider API keys, from a secret of
+// HashiCorp Vault's KV secrets engine.
+type VaultSource struct {
+	address string
+	path    string
+	token   string
+	client  *http.Client
+
+	// renewable is set if the token can be renewed, as reported by Vault when the secret is first read.
+	renewable bool
+
+	// secrets holds the string values of the secret's keys as last read.
+	secrets map[string]string
+	mutex   sync.Mutex
+}
+
+// NewVaultSource creates a source that reads the secret at path, such as secret/data/eth-balance-exporter
+// for version 2 of the KV engine, from the Vault server at address, authenticating with token. As with
+// the Vault CLI, an empty address selects DefaultVaultAddress.
+func NewVaultSource(address, path, token string) (*VaultSource, error) {
+	address = strings.TrimRight(strings.TrimSpace(address), "/")
+	if address == "" {
+		address = DefaultVaultAddress
+	}
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		return nil, fmt.Errorf("invalid Vault address: %s (must start with http:// or https://)", redactURL(address))
+	}
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return nil, errors.New("no Vault secret path configured")
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, errors.New("no Vault token configured (set VAULT_TOKEN)")
+	}
+
+	return &VaultSource{
+		address: address,
+		path:    path,
+		token:   token,
+		client:  &http.Client{Timeout: vaultTimeout},
+	}, nil
+}
+
+// Load reads the secret from Vault, and whether the token is renewable.
+func (s *VaultSource) Load() error {
+	secrets, err := s.fetch()
+	if err != nil {
+		return err
+	}
+
+	var token struct {
+		Data struct {
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := s.do(http.MethodGet, "auth/token/lookup-self", &token); err != nil {
+		return fmt.Errorf("error looking up Vault token: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.secrets = secrets
+	s.mutex.Unlock()
+	s.renewable = token.Data.Renewable
+	return nil
+}
+
+// fetch returns the string values of the secret's keys. Secrets of both versions of the KV engine are
+// supported: version 2 nests the keys under data, along with the secret's metadata.
+func (s *VaultSource) fetch() (map[string]string, error) {
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := s.do(http.MethodGet, s.path, &secret); err != nil {
+		return nil, err
+	}
+
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	secrets := make(map[string]string, len(data))
+	for key, value := range data {
+		if value, ok := value.(string); ok {
+			secrets[key] = value
+		}
+	}
+	return secrets, nil
+}
+
+// renewToken renews the token for its default TTL, if it is renewable.
+func (s *VaultSource) renewToken() error {
+	if !s.renewable {
+		return nil
+	}
+	return s.do(http.MethodPost, "auth/token/renew-self", nil)
+}
+
+// do sends a request to the Vault API at path and decodes the response into result, if not nil.
+func (s *VaultSource) do(method, path string, result any) error {
+	request, err := http.NewRequest(method, s.address+"/v1/"+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Vault-Token", s.token)
+
+	response, err := doHTTP(s.client, request, "Vault")
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound && path == s.path {
+		return fmt.Errorf("Vault secret %s does not exist", s.path)
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response from Vault: %v", err)
+	}
+	return nil
+}
+
+// HasVaultPlaceholders reports whether the RPC URL of any endpoint contains a Vault placeholder.
+func HasVaultPlaceholders(endpoints []EndpointConfig) bool {
+	return slices.ContainsFunc(endpoints, func(endpoint EndpointConfig) bool { return vaultPlaceholder.MatchString(endpoint.URL) })
+}
+
+// Resolve returns the endpoints with the placeholders in their RPC URLs, such as ${vault:alchemy_key},
+// replaced by the values of those keys of the secret as last read. Endpoints resolved before are
+// resolved again from their original URL. A placeholder whose key the secret lacks is an error.
+func (s *VaultSource) Resolve(endpoints []EndpointConfig) ([]EndpointConfig, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	resolved := make([]EndpointConfig, len(endpoints))
+	for i, endpoint := range endpoints {
+		template := endpoint.URL
+		if endpoint.urlTemplate != "" {
+			template = endpoint.urlTemplate
+		}
+		if !vaultPlaceholder.MatchString(template) {
+			resolved[i] = endpoint
+			continue
+		}
+
+		var missing []string
+		endpoint.URL = vaultPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+			key := vaultPlaceholder.FindStringSubmatch(placeholder)[1]
+			value, ok := s.secrets[key]
+			if !ok {
+				missing = append(missing, key)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("Vault secret %s has no string value for %s, used by the RPC URL of endpoint %d", s.path, strings.Join(missing, ", "), i)
+		}
+		endpoint.urlTemplate = template
+		resolved[i] = endpoint
+	}
+	return resolved, nil
+}
+
+// RunVault rotates the Vault secrets, waiting the interval between reads, so that rotated API keys are
+// picked up without a restart. It never returns.
+func (c *WalletBalanceCollector) RunVault(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		c.rotateVaultSecrets()
+	}
+}
+
+// rotateVaultSecrets renews the Vault token and reads the secret again, and replaces the monitored
+// endpoints if a secret used in their RPC URLs changed. Errors are logged and the current RPC URLs are
+// kept.
+func (c *WalletBalanceCollector) rotateVaultSecrets() {
+	vault := c.config.Vault
+	if err := vault.renewToken(); err != nil {
+		log.Printf("Error renewing Vault token: %v", err)
+	}
+	secrets, err := vault.fetch()
+	if err != nil {
+		log.Printf("Error reading secrets from Vault: %v", err)
+		return
+	}
+
+	vault.mutex.Lock()
+	changed := !maps.Equal(secrets, vault.secrets)
+	vault.secrets = secrets
+	vault.mutex.Unlock()
+	if !changed {
+		return
+	}
+
+	current := c.getEndpoints()
+	endpoints, err := vault.Resolve(current)
+	if err != nil {
+		log.Printf("Error applying secrets from Vault, keeping the current RPC URLs: %v", err)
+		return
+	}
+	if !rpcURLsChanged(current, endpoints) {
+		return
+	}
+	if err := c.ReplaceEndpoints(endpoints); err != nil {
+		log.Printf("Error applying secrets from Vault, keeping the current RPC URLs: %v", err)
+		return
+	}
+	log.Print("Applied rotated secrets from Vault to the RPC URLs")
+}
+
+// rpcURLsChanged reports whether any endpoint's RPC URL differs between two resolutions of the same
+// endpoints.
+func rpcURLsChanged(previous, current []EndpointConfig) bool {
+	for i := range previous {
+		if previous[i].URL != current[i].URL {
+			return true
+		}
+	}
+	return false
+}
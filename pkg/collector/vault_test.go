@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newStubVault starts a Vault server holding a KV version 2 secret at secret/data/exporter whose key
+// api_key is the value of apiKey when read.
+func newStubVault(t *testing.T, apiKey *atomic.Value) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/secret/data/exporter":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{"data": map[string]any{"api_key": apiKey.Load()}, "metadata": map[string]any{}},
+			})
+		case "/v1/auth/token/lookup-self":
+			_, _ = w.Write([]byte(`{"data":{"renewable":false}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestRotateVaultSecretsKeepsState checks that an endpoint whose RPC URL changes with a rotated API key
+// keeps its cached balances and contract flags under its new URL, and reconnects with the new URL.
+func TestRotateVaultSecretsKeepsState(t *testing.T) {
+	var failing atomic.Bool
+	rpcServer := newStubRPCFunc(t, func(method string, _ []json.RawMessage) (string, error) {
+		switch {
+		case method == "eth_chainId":
+			return `"0x1"`, nil
+		case method == "eth_getCode":
+			return `"0x"`, nil
+		case method == "eth_getBalance" && !failing.Load():
+			return `"0xde0b6b3a7640000"`, nil
+		}
+		return "", errors.New("rate limited")
+	})
+	var apiKey atomic.Value
+	apiKey.Store("first")
+	vault, err := NewVaultSource(newStubVault(t, &apiKey).URL, "secret/data/exporter", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	address := "0x742d35Cc6634C0532925a3b844Bc454e4438f44e"
+	wallet := WalletConfig{Address: address, parsed: common.HexToAddress(address)}
+	endpoints, err := vault.Resolve([]EndpointConfig{{Name: "stub", URL: rpcServer.URL + "/${vault:api_key}", Wallets: []WalletConfig{wallet}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	c, err := Register(registry, endpoints, CollectorConfig{Vault: vault, StaleBehavior: StaleHold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey.Store("second")
+	c.rotateVaultSecrets()
+	oldURL, newURL := rpcServer.URL+"/first", rpcServer.URL+"/second"
+	if got := c.getEndpoints()[0].URL; got != newURL {
+		t.Fatalf("endpoint URL after rotation = %s, want %s", got, newURL)
+	}
+
+	c.cacheMutex.Lock()
+	_, oldBalance := c.lastBalances[balanceKey(oldURL, address)]
+	last, newBalance := c.lastBalances[balanceKey(newURL, address)]
+	_, contract := c.contracts[balanceKey(newURL, address)]
+	c.cacheMutex.Unlock()
+	if oldBalance || !newBalance || last.balance != 1 || last.endpoint.URL != newURL {
+		t.Errorf("cached balance not moved to the new URL: under old URL %v, under new URL %v (%+v)", oldBalance, newBalance, last)
+	}
+	if !contract {
+		t.Error("contract flag not moved to the new URL")
+	}
+	c.clientMutex.Lock()
+	_, oldClient := c.clientCache[oldURL]
+	c.clientMutex.Unlock()
+	if oldClient {
+		t.Error("client connected to the old URL was kept")
+	}
+
+	// The held balance is still exported while the provider fails after the rotation
+	failing.Store(true)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := false
+	for _, family := range families {
+		if family.GetName() == "wallet_balance_eth" && len(family.GetMetric()) == 1 && family.GetMetric()[0].GetGauge().GetValue() == 1 {
+			held = true
+		}
+	}
+	if !held {
+		t.Error("wallet_balance_eth does not hold the balance cached before the rotation")
+	}
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sources of monitored addresses in the source label of resolved_addresses_total.
+var addressSources = []string{"file", "xpub", "ens", "admin"}
+
+// PrintWallets writes the resolved list of monitored wallets as a table, one wallet per line, with the
+// endpoint, chain, address, name and derivation index of each. Wallets include those derived from
+// xpubs, routed by chain prefix and added from the admin wallets file. The chain is taken from the
+// endpoint's configured chain, or shown as "-" if it is only known once eth_chainId is queried.
+func (c *WalletBalanceCollector) PrintWallets(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ENDPOINT\tCHAIN\tADDRESS\tNAME\tINDEX")
+
+	endpoints := c.getEndpoints()
+	count := 0
+	for _, endpoint := range endpoints {
+		chain := endpoint.Chain
+		if chain == "" {
+			chain = "-"
+		}
+		for _, wallet := range endpoint.Wallets {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", endpoint.Name, chain, wallet.Address, wallet.Name, wallet.derivationLabel())
+			count++
+		}
+	}
+	fmt.Fprintf(table, "\n%d wallets on %d endpoints\n", count, len(endpoints))
+	return table.Flush()
+}
+
+// collectResolvedAddresses emits the number of monitored wallets across all endpoints by the source
+// of their address: listed in the configuration, derived from an xpub, resolved from an ENS name or
+// added through the admin API. ENS names that have not been resolved yet are not counted.
+func (c *WalletBalanceCollector) collectResolvedAddresses(ch chan<- prometheus.Metric) {
+	c.endpointsMutex.Lock()
+	endpoints := c.withENSAddresses(c.endpoints)
+	added := make(map[string]bool, len(c.added))
+	for _, wallet := range c.added {
+		added[balanceKey(wallet.RPCURL, strings.ToLower(wallet.Address))] = true
+	}
+	c.endpointsMutex.Unlock()
+
+	counts := make(map[string]int, len(addressSources))
+	for _, endpoint := range endpoints {
+		for _, wallet := range endpoint.Wallets {
+			switch {
+			case wallet.Derived:
+				counts["xpub"]++
+			case wallet.ENS != "":
+				counts["ens"]++
+			case added[balanceKey(endpoint.URL, strings.ToLower(wallet.Address))]:
+				counts["admin"]++
+			default:
+				counts["file"]++
+			}
+		}
+	}
+	for _, source := range addressSources {
+		ch <- prometheus.MustNewConstMetric(c.resolvedMetric, prometheus.GaugeValue, float64(counts[source]), source)
+	}
+}
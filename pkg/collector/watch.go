@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultConfigWatchDelay is how long the config file must stay unchanged before a change is reloaded,
+// used when CONFIG_WATCH_DELAY is not set.
+const DefaultConfigWatchDelay = time.Second
+
+// WatchConfigFile reloads the config file at path whenever its content changes, once no change has
+// been made for the delay, so that a file that is still being written is not read. The file's
+// directory is watched rather than the file itself, so that the file keeps being watched when it is
+// replaced by renaming another file over it, as editors and Kubernetes ConfigMap volumes do. Errors
+// reading the file and invalid configurations are logged and the current configuration is kept.
+//
+// It returns an error if the directory cannot be watched, and otherwise watches in the background.
+func (c *WalletBalanceCollector) WatchConfigFile(path string, delay time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// last is the content last read, so that events for other files of the directory, and writes that
+	// leave the content unchanged, are not reloaded
+	last, _ := os.ReadFile(path)
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(delay)
+		timer.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op != fsnotify.Chmod {
+					timer.Reset(delay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching config file %s: %v", path, err)
+			case <-timer.C:
+				data, err := os.ReadFile(path)
+				if err != nil {
+					log.Printf("Error reading config file, keeping the current configuration: %v", err)
+					continue
+				}
+				if bytes.Equal(data, last) {
+					continue
+				}
+				last = data
+
+				if err := c.reloadConfig(data, "config file "+path); err != nil {
+					log.Printf("Error reloading config file, keeping the current configuration: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}